@@ -2,12 +2,16 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cyverse/irodsfs-monitor/types"
@@ -18,14 +22,125 @@ import (
 // APIClient is a struct that holds connection information of a API client
 type APIClient struct {
 	APIRootURL string
+	Token      string
 	Timeout    time.Duration
+	TLSConfig  *tls.Config
+
+	httpClient *http.Client
+	mutex      sync.Mutex
+}
+
+// ServiceError is an error returned when the service responds with an unexpected status
+type ServiceError struct {
+	StatusCode int
+	Status     string
+}
+
+func newServiceError(resp *http.Response) *ServiceError {
+	return &ServiceError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+}
+
+// Error returns an error message
+func (err *ServiceError) Error() string {
+	return fmt.Sprintf("service error returned - %s", err.Status)
+}
+
+// IsRetryable checks if the same request may succeed later
+func (err *ServiceError) IsRetryable() bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusRequestTimeout
 }
 
 // NewAPIClient creates a new API client
 func NewAPIClient(apiRootURL string, timeout time.Duration) *APIClient {
 	return &APIClient{
 		APIRootURL: apiRootURL,
+		Token:      "",
+		Timeout:    timeout,
+		TLSConfig:  nil,
+		httpClient: nil,
+	}
+}
+
+// NewAPIClientWithToken creates a new API client that authenticates with a bearer token
+func NewAPIClientWithToken(apiRootURL string, token string, timeout time.Duration) *APIClient {
+	return &APIClient{
+		APIRootURL: apiRootURL,
+		Token:      token,
 		Timeout:    timeout,
+		TLSConfig:  nil,
+		httpClient: nil,
+	}
+}
+
+// SetTLS configures TLS with a CA bundle to verify the service and a client certificate
+// The system CA pool is used if caCertPath is empty, and no client certificate is sent if clientCertPath is empty
+func (client *APIClient) SetTLS(caCertPath string, clientCertPath string, clientKeyPath string) error {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(caCertPath) > 0 {
+		caBytes, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return err
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("failed to load CA certificates from %s", caCertPath)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if len(clientCertPath) > 0 {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.TLSConfig = tlsConfig
+	// recreate the HTTP client with the new configuration
+	client.httpClient = nil
+	return nil
+}
+
+// getHTTPClient returns the HTTP client, it is created once so connections are reused
+func (client *APIClient) getHTTPClient() *http.Client {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.httpClient != nil {
+		return client.httpClient
+	}
+
+	httpClient := &http.Client{
+		Timeout: client.Timeout,
+	}
+
+	if client.TLSConfig != nil {
+		httpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: client.TLSConfig,
+		}
+	}
+
+	client.httpClient = httpClient
+	return httpClient
+}
+
+func (client *APIClient) setAuthHeader(req *http.Request) {
+	if len(client.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
 	}
 }
 
@@ -43,13 +158,8 @@ func (client *APIClient) makeAPIURL(apiPath string) string {
 	return u + apiPath
 }
 
-// AddInstance registers an instance
-func (client *APIClient) AddInstance(instance *types.ReportInstance) (string, error) {
-	logger := log.WithFields(log.Fields{
-		"package":  "client",
-		"function": "APIClient.AddInstance",
-	})
-
+// prepareInstance fills fields of the instance that are not given
+func prepareInstance(instance *types.ReportInstance) {
 	if len(instance.ClientHostname) == 0 {
 		hostname, err := os.Hostname()
 		if err == nil {
@@ -65,6 +175,16 @@ func (client *APIClient) AddInstance(instance *types.ReportInstance) (string, er
 	if instance.CreationTime.IsZero() {
 		instance.CreationTime = time.Now().UTC()
 	}
+}
+
+// AddInstance registers an instance
+func (client *APIClient) AddInstance(instance *types.ReportInstance) (string, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.AddInstance",
+	})
+
+	prepareInstance(instance)
 
 	JSONBytes, err := json.Marshal(instance)
 	if err != nil {
@@ -79,20 +199,22 @@ func (client *APIClient) AddInstance(instance *types.ReportInstance) (string, er
 		return "", err
 	}
 
+	client.setAuthHeader(req)
+
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	req.Body = ioutil.NopCloser(bytes.NewReader(JSONBytes))
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return "", fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return "", err
 	}
 
 	return instance.InstanceID, nil
@@ -112,18 +234,20 @@ func (client *APIClient) ListInstances(instance *types.ReportInstance) ([]types.
 		return nil, err
 	}
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return nil, fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
 	}
 
 	var instances []types.ReportInstance
@@ -142,6 +266,57 @@ func (client *APIClient) ListInstances(instance *types.ReportInstance) ([]types.
 	return instances, nil
 }
 
+// ListInstancesWithQuery lists instances registered that match the query
+// It returns a cursor for the next page, the cursor is empty if there are no more instances
+func (client *APIClient) ListInstancesWithQuery(query *types.InstanceQuery) ([]types.ReportInstance, string, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.ListInstancesWithQuery",
+	})
+
+	url := client.makeAPIURL("/instances")
+	if query != nil {
+		url = url + "?" + query.Values().Encode()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	var instances []types.ReportInstance
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	err = json.Unmarshal(responseJSON, &instances)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	return instances, resp.Header.Get(types.NextCursorHeader), nil
+}
+
 // GetInstance returns an instance registered
 func (client *APIClient) GetInstance(instanceID string) (types.ReportInstance, error) {
 	logger := log.WithFields(log.Fields{
@@ -156,18 +331,20 @@ func (client *APIClient) GetInstance(instanceID string) (types.ReportInstance, e
 		return types.ReportInstance{}, err
 	}
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return types.ReportInstance{}, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return types.ReportInstance{}, fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return types.ReportInstance{}, err
 	}
 
 	var instance types.ReportInstance
@@ -200,20 +377,59 @@ func (client *APIClient) TerminateInstance(instanceID string) error {
 		return err
 	}
 
+	client.setAuthHeader(req)
+
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
 	}
+
+	return nil
+}
+
+// Heartbeat tells the service that the instance is alive, instances without heartbeats or transfers become stale
+func (client *APIClient) Heartbeat(instanceID string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.Heartbeat",
+	})
+
+	if len(instanceID) == 0 {
+		return fmt.Errorf("invalid instance id")
+	}
+
+	url := client.makeAPIURL(fmt.Sprintf("/instances/%s/heartbeat", instanceID))
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
 	}
 
 	return nil
@@ -243,26 +459,82 @@ func (client *APIClient) AddFileTransfer(transfer *types.ReportFileTransfer) err
 		return err
 	}
 
+	client.setAuthHeader(req)
+
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	httpClient := client.getHTTPClient()
 	req.Body = ioutil.NopCloser(bytes.NewReader(JSONBytes))
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
 	}
 
 	return nil
 }
 
+// AddFileTransfers adds file transfers in a batch, it returns whether each transfer is accepted or rejected
+func (client *APIClient) AddFileTransfers(transfers []*types.ReportFileTransfer) (*types.ReportFileTransferBatchResult, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.AddFileTransfers",
+	})
+
+	JSONBytes, err := json.Marshal(transfers)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	url := client.makeAPIURL("/transfers/batch")
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	client.setAuthHeader(req)
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Body = ioutil.NopCloser(bytes.NewReader(JSONBytes))
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
+	}
+
+	var result types.ReportFileTransferBatchResult
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(responseJSON, &result)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // ListFileTransfers lists all file transfers
 func (client *APIClient) ListFileTransfers() ([]types.ReportFileTransfer, error) {
 	logger := log.WithFields(log.Fields{
@@ -277,18 +549,20 @@ func (client *APIClient) ListFileTransfers() ([]types.ReportFileTransfer, error)
 		return nil, err
 	}
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return nil, fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
 	}
 
 	var transfers []types.ReportFileTransfer
@@ -307,6 +581,57 @@ func (client *APIClient) ListFileTransfers() ([]types.ReportFileTransfer, error)
 	return transfers, nil
 }
 
+// ListFileTransfersWithQuery lists file transfers that match the query
+// It returns a cursor for the next page, the cursor is empty if there are no more transfers
+func (client *APIClient) ListFileTransfersWithQuery(query *types.TransferQuery) ([]types.ReportFileTransfer, string, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.ListFileTransfersWithQuery",
+	})
+
+	url := client.makeAPIURL("/transfers")
+	if query != nil {
+		url = url + "?" + query.Values().Encode()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	var transfers []types.ReportFileTransfer
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	err = json.Unmarshal(responseJSON, &transfers)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	return transfers, resp.Header.Get(types.NextCursorHeader), nil
+}
+
 // ListFileTransfersForInstance lists all file transfers
 func (client *APIClient) ListFileTransfersForInstance(instanceID string) ([]types.ReportFileTransfer, error) {
 	logger := log.WithFields(log.Fields{
@@ -321,18 +646,20 @@ func (client *APIClient) ListFileTransfersForInstance(instanceID string) ([]type
 		return nil, err
 	}
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
-	}
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return nil, fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
 	}
 
 	var transfers []types.ReportFileTransfer
@@ -351,6 +678,107 @@ func (client *APIClient) ListFileTransfersForInstance(instanceID string) ([]type
 	return transfers, nil
 }
 
+// GetTransferStats returns aggregated statistics of file transfers
+// It also returns why the stats do not count some transfers removed by retention, empty if they count all
+func (client *APIClient) GetTransferStats(query *types.StatsQuery) ([]types.TransferStats, string, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.GetTransferStats",
+	})
+
+	url := client.makeAPIURL("/stats/transfers")
+	if query != nil {
+		url = url + "?" + query.Values().Encode()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	var stats []types.TransferStats
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	err = json.Unmarshal(responseJSON, &stats)
+	if err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	return stats, resp.Header.Get(types.StatsPartialHeader), nil
+}
+
+// GetTop returns a ranking of hot files or collections
+func (client *APIClient) GetTop(query *types.TopQuery) ([]types.TopEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.GetTop",
+	})
+
+	url := client.makeAPIURL("/stats/top")
+	if query != nil {
+		url = url + "?" + query.Values().Encode()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
+	}
+
+	var entries []types.TopEntry
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(responseJSON, &entries)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return entries, nil
+}
+
 // CleanUp clears all data
 func (client *APIClient) CleanUp() error {
 	logger := log.WithFields(log.Fields{
@@ -365,21 +793,150 @@ func (client *APIClient) CleanUp() error {
 		return err
 	}
 
+	client.setAuthHeader(req)
+
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	httpClient := &http.Client{
-		Timeout: client.Timeout,
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// CleanUpDaysOld clears data older than the given days
+func (client *APIClient) CleanUpDaysOld(days int) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.CleanUpDaysOld",
+	})
+
+	url := client.makeAPIURL(fmt.Sprintf("/cleanup/%d", days))
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	client.setAuthHeader(req)
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	httpClient := client.getHTTPClient()
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		logger.Error(err)
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("service error returned - %s", resp.Status))
-		return fmt.Errorf("service error returned - %s", resp.Status)
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
 	}
 
 	return nil
 }
+
+// Export writes a table of instances, transfers or transfer blocks to w in the requested format
+// The output is streamed, so the client timeout should be long enough or zero for large exports
+func (client *APIClient) Export(query *types.ExportQuery, w io.Writer) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.Export",
+	})
+
+	url := client.makeAPIURL("/export")
+	if query != nil {
+		url = url + "?" + query.Values().Encode()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	client.setAuthHeader(req)
+
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Import loads a dump of instances and file transfers, a JSON array or NDJSON, into the service keeping their timestamps
+// With dedup, instances and transfers that already exist in the service are skipped
+func (client *APIClient) Import(dump io.Reader, dedup bool) (*types.ReportImportResult, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "APIClient.Import",
+	})
+
+	url := client.makeAPIURL(fmt.Sprintf("/import?dedup=%t", dedup))
+	req, err := http.NewRequest("POST", url, dump)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	client.setAuthHeader(req)
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	httpClient := client.getHTTPClient()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = newServiceError(resp)
+		logger.Error(err)
+		return nil, err
+	}
+
+	var result types.ReportImportResult
+	responseJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = json.Unmarshal(responseJSON, &result)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,530 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ReporterQueueSizeDefault         int           = 1000
+	ReporterBatchSizeDefault         int           = 100
+	ReporterFlushIntervalDefault     time.Duration = 1 * time.Second
+	ReporterBackoffMinDefault        time.Duration = 1 * time.Second
+	ReporterBackoffMaxDefault        time.Duration = 5 * time.Minute
+	ReporterSpoolMaxBytesDefault     int64         = 64 * 1024 * 1024 // 64MB
+	ReporterHeartbeatIntervalDefault time.Duration = 1 * time.Minute
+
+	reportKindAddInstance       string = "add_instance"
+	reportKindTerminateInstance string = "terminate_instance"
+	reportKindAddFileTransfer   string = "add_file_transfer"
+)
+
+// ReporterConfig holds the parameters of a reporter
+type ReporterConfig struct {
+	// QueueSize is the max number of reports kept in memory, reports over it go to the spool
+	QueueSize int
+	// BatchSize is the max number of reports sent at once
+	BatchSize     int
+	FlushInterval time.Duration
+	// failed sends are retried after a backoff that doubles from BackoffMin to BackoffMax
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+	// SpoolPath is a directory to keep reports while the service is unreachable, spooling is disabled if empty
+	SpoolPath     string
+	SpoolMaxBytes int64
+	// HeartbeatInterval is how often heartbeats of reported instances are sent, heartbeats are disabled if zero
+	HeartbeatInterval time.Duration
+}
+
+// NewDefaultReporterConfig creates a default reporter config
+func NewDefaultReporterConfig() *ReporterConfig {
+	return &ReporterConfig{
+		QueueSize:         ReporterQueueSizeDefault,
+		BatchSize:         ReporterBatchSizeDefault,
+		FlushInterval:     ReporterFlushIntervalDefault,
+		BackoffMin:        ReporterBackoffMinDefault,
+		BackoffMax:        ReporterBackoffMaxDefault,
+		SpoolPath:         "",
+		SpoolMaxBytes:     ReporterSpoolMaxBytesDefault,
+		HeartbeatInterval: ReporterHeartbeatIntervalDefault,
+	}
+}
+
+// report is a queued report, it is also the format of spooled reports
+type report struct {
+	Kind       string                    `json:"kind"`
+	Instance   *types.ReportInstance     `json:"instance,omitempty"`
+	InstanceID string                    `json:"instance_id,omitempty"`
+	Transfer   *types.ReportFileTransfer `json:"transfer,omitempty"`
+}
+
+// Reporter sends reports to the service in background
+// Reports are queued without blocking callers, sent in batches in the order they are queued,
+// and spooled on disk while the service is unreachable
+type Reporter struct {
+	Client *APIClient
+	Config *ReporterConfig
+
+	spool     *reportSpool
+	pending   []*report
+	instances map[string]*types.ReportInstance // reported and not terminated, registered again if the service lost them
+	mutex     sync.Mutex
+
+	backoff          time.Duration
+	nextAttemptTime  time.Time
+	dropped          uint64
+	batchUnsupported bool
+
+	flushChan     chan bool
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// NewReporter creates a reporter, reports spooled by a previous run are sent once the reporter starts
+func NewReporter(client *APIClient, config *ReporterConfig) (*Reporter, error) {
+	if config == nil {
+		config = NewDefaultReporterConfig()
+	}
+
+	reporter := &Reporter{
+		Client: client,
+		Config: config,
+
+		spool:     nil,
+		pending:   []*report{},
+		instances: map[string]*types.ReportInstance{},
+		mutex:     sync.Mutex{},
+
+		backoff:          0,
+		nextAttemptTime:  time.Time{},
+		dropped:          0,
+		batchUnsupported: false,
+
+		flushChan:     make(chan bool, 1),
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+
+	if len(config.SpoolPath) > 0 {
+		spool, err := newReportSpool(config.SpoolPath, config.SpoolMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		reporter.spool = spool
+	}
+
+	return reporter, nil
+}
+
+// Start starts sending reports in background
+func (reporter *Reporter) Start() {
+	reporter.waitGroup.Add(1)
+	go reporter.sendLoop()
+}
+
+// Close stops the reporter, reports that could not be sent are spooled for the next run
+func (reporter *Reporter) Close() {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.Close",
+	})
+
+	close(reporter.terminateChan)
+	reporter.waitGroup.Wait()
+
+	// one last try, without waiting for backoff
+	reporter.nextAttemptTime = time.Time{}
+	reporter.flush()
+
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	if len(reporter.pending) > 0 {
+		if reporter.spool != nil {
+			err := reporter.spool.Append(reporter.pending)
+			if err != nil {
+				logger.WithError(err).Errorf("Could not spool %d reports", len(reporter.pending))
+				atomic.AddUint64(&reporter.dropped, uint64(len(reporter.pending)))
+			}
+		} else {
+			atomic.AddUint64(&reporter.dropped, uint64(len(reporter.pending)))
+		}
+
+		reporter.pending = []*report{}
+	}
+
+	if dropped := atomic.LoadUint64(&reporter.dropped); dropped > 0 {
+		logger.Warnf("Dropped %d reports in total", dropped)
+	}
+}
+
+// Dropped returns the number of reports dropped because both the queue and the spool were full
+func (reporter *Reporter) Dropped() uint64 {
+	return atomic.LoadUint64(&reporter.dropped)
+}
+
+// AddInstance queues an instance registration and returns the instance ID
+func (reporter *Reporter) AddInstance(instance *types.ReportInstance) string {
+	prepareInstance(instance)
+
+	instanceCopy := *instance
+	reporter.enqueue(&report{
+		Kind:     reportKindAddInstance,
+		Instance: &instanceCopy,
+	})
+
+	reporter.mutex.Lock()
+	reporter.instances[instance.InstanceID] = &instanceCopy
+	reporter.mutex.Unlock()
+
+	return instance.InstanceID
+}
+
+// TerminateInstance queues an instance termination
+func (reporter *Reporter) TerminateInstance(instanceID string) {
+	reporter.mutex.Lock()
+	delete(reporter.instances, instanceID)
+	reporter.mutex.Unlock()
+
+	reporter.enqueue(&report{
+		Kind:       reportKindTerminateInstance,
+		InstanceID: instanceID,
+	})
+}
+
+// AddFileTransfer queues a file transfer
+func (reporter *Reporter) AddFileTransfer(transfer *types.ReportFileTransfer) error {
+	if len(transfer.InstanceID) == 0 {
+		return fmt.Errorf("invalid instance id")
+	}
+
+	transferCopy := *transfer
+	reporter.enqueue(&report{
+		Kind:     reportKindAddFileTransfer,
+		Transfer: &transferCopy,
+	})
+	return nil
+}
+
+// enqueue adds a report to the queue without blocking on the service
+// When the queue is full, queued reports are moved to the spool, so the order of reports is kept
+func (reporter *Reporter) enqueue(r *report) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.enqueue",
+	})
+
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	if len(reporter.pending) >= reporter.Config.QueueSize {
+		if reporter.spool == nil {
+			atomic.AddUint64(&reporter.dropped, 1)
+			logger.Warn("Report queue is full, dropping a report")
+			return
+		}
+
+		err := reporter.spool.Append(reporter.pending)
+		if err != nil {
+			atomic.AddUint64(&reporter.dropped, uint64(len(reporter.pending)))
+			logger.WithError(err).Warnf("Could not spool, dropping %d reports", len(reporter.pending))
+		}
+
+		reporter.pending = []*report{}
+	}
+
+	reporter.pending = append(reporter.pending, r)
+
+	if len(reporter.pending) >= reporter.Config.BatchSize {
+		select {
+		case reporter.flushChan <- true:
+		default:
+		}
+	}
+}
+
+// requeue puts reports that could not be sent back in front of all other reports
+func (reporter *Reporter) requeue(reports []*report) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.requeue",
+	})
+
+	if len(reports) == 0 {
+		return
+	}
+
+	if reporter.spool != nil {
+		err := reporter.spool.Prepend(reports)
+		if err == nil {
+			return
+		}
+
+		logger.WithError(err).Warn("Could not spool, keeping reports in memory")
+	}
+
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	reporter.pending = append(append([]*report{}, reports...), reporter.pending...)
+	if len(reporter.pending) > reporter.Config.QueueSize {
+		overflow := len(reporter.pending) - reporter.Config.QueueSize
+		atomic.AddUint64(&reporter.dropped, uint64(overflow))
+		logger.Warnf("Report queue is full, dropping %d reports", overflow)
+		reporter.pending = reporter.pending[:reporter.Config.QueueSize]
+	}
+}
+
+// takePending removes up to a batch of reports from the queue
+func (reporter *Reporter) takePending() []*report {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	count := len(reporter.pending)
+	if count > reporter.Config.BatchSize {
+		count = reporter.Config.BatchSize
+	}
+
+	reports := make([]*report, count)
+	copy(reports, reporter.pending[:count])
+	reporter.pending = reporter.pending[count:]
+	return reports
+}
+
+func (reporter *Reporter) sendLoop() {
+	defer reporter.waitGroup.Done()
+
+	flushTicker := time.NewTicker(reporter.Config.FlushInterval)
+	defer flushTicker.Stop()
+
+	var heartbeatChan <-chan time.Time
+	if reporter.Config.HeartbeatInterval > 0 {
+		heartbeatTicker := time.NewTicker(reporter.Config.HeartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeatChan = heartbeatTicker.C
+	}
+
+	for {
+		select {
+		case <-reporter.terminateChan:
+			return
+		case <-flushTicker.C:
+			reporter.flush()
+		case <-reporter.flushChan:
+			reporter.flush()
+		case <-heartbeatChan:
+			reporter.sendHeartbeats()
+		}
+	}
+}
+
+// flush sends spooled reports first and then queued reports, until one fails
+func (reporter *Reporter) flush() {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.flush",
+	})
+
+	if time.Now().Before(reporter.nextAttemptTime) {
+		return
+	}
+
+	for {
+		if reporter.spool != nil && !reporter.spool.Empty() {
+			seq, reports, offset, err := reporter.spool.Oldest()
+			if err != nil {
+				// reading the segment again would fail the same way and block all later reports,
+				// reports read before the error stay in the segment and are sent next
+				quarantinePath, quarantineErr := reporter.spool.Quarantine(seq, offset, reports)
+				if quarantineErr != nil {
+					logger.WithError(quarantineErr).Error("Could not quarantine an unreadable spool segment")
+					reporter.failed()
+					return
+				}
+
+				logger.WithError(err).Errorf("Could not read a spool segment after %d reports, moved the rest to %s", len(reports), quarantinePath)
+				continue
+			}
+
+			sent, err := reporter.send(reports)
+			replaceErr := reporter.spool.Replace(seq, reports[sent:])
+			if replaceErr != nil {
+				logger.WithError(replaceErr).Error("Could not update the spool")
+			}
+
+			if err != nil {
+				logger.WithError(err).Warnf("Could not send spooled reports, retrying in %s", reporter.backoff)
+				return
+			}
+			continue
+		}
+
+		reports := reporter.takePending()
+		if len(reports) == 0 {
+			return
+		}
+
+		sent, err := reporter.send(reports)
+		if err != nil {
+			reporter.requeue(reports[sent:])
+			logger.WithError(err).Warnf("Could not send reports, retrying in %s", reporter.backoff)
+			return
+		}
+	}
+}
+
+// send sends reports in order and returns how many are done
+// Reports rejected by the service are dropped, as sending them again would fail the same way
+func (reporter *Reporter) send(reports []*report) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.send",
+	})
+
+	i := 0
+	for i < len(reports) {
+		r := reports[i]
+
+		// consecutive transfers go in a batch
+		batchEnd := i
+		for batchEnd < len(reports) && reports[batchEnd].Kind == reportKindAddFileTransfer {
+			batchEnd++
+		}
+
+		if batchEnd-i > 1 && !reporter.batchUnsupported {
+			err := reporter.sendTransferBatch(reports[i:batchEnd])
+			if err == nil {
+				i = batchEnd
+				continue
+			}
+
+			if serviceErr, ok := err.(*ServiceError); ok && (serviceErr.StatusCode == http.StatusNotFound || serviceErr.StatusCode == http.StatusMethodNotAllowed) {
+				// the service is older than the batch API
+				logger.Info("The service does not support batches, sending transfers one by one")
+				reporter.batchUnsupported = true
+				continue
+			}
+
+			if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.IsRetryable() {
+				reporter.failed()
+				return i, err
+			}
+
+			logger.WithError(err).Warnf("Dropping %d transfer reports rejected by the service", batchEnd-i)
+			i = batchEnd
+			continue
+		}
+
+		var err error
+		switch r.Kind {
+		case reportKindAddInstance:
+			_, err = reporter.Client.AddInstance(r.Instance)
+		case reportKindTerminateInstance:
+			err = reporter.Client.TerminateInstance(r.InstanceID)
+		case reportKindAddFileTransfer:
+			err = reporter.Client.AddFileTransfer(r.Transfer)
+		default:
+			logger.Warnf("Dropping a report of unknown kind %s", r.Kind)
+		}
+
+		if err != nil {
+			if serviceErr, ok := err.(*ServiceError); !ok || serviceErr.IsRetryable() {
+				reporter.failed()
+				return i, err
+			}
+
+			logger.WithError(err).Warnf("Dropping a %s report rejected by the service", r.Kind)
+		}
+
+		i++
+	}
+
+	reporter.backoff = 0
+	return len(reports), nil
+}
+
+// sendTransferBatch sends transfer reports in a batch, transfers rejected individually are dropped
+func (reporter *Reporter) sendTransferBatch(reports []*report) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.sendTransferBatch",
+	})
+
+	transfers := make([]*types.ReportFileTransfer, len(reports))
+	for i, r := range reports {
+		transfers[i] = r.Transfer
+	}
+
+	result, err := reporter.Client.AddFileTransfers(transfers)
+	if err != nil {
+		return err
+	}
+
+	if result.Rejected > 0 {
+		for _, itemResult := range result.Results {
+			if !itemResult.Accepted {
+				logger.Warnf("Dropping a transfer report rejected by the service - %s", itemResult.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// failed delays the next attempt with exponential backoff
+func (reporter *Reporter) failed() {
+	if reporter.backoff == 0 {
+		reporter.backoff = reporter.Config.BackoffMin
+	} else {
+		reporter.backoff *= 2
+	}
+
+	if reporter.backoff > reporter.Config.BackoffMax {
+		reporter.backoff = reporter.Config.BackoffMax
+	}
+
+	reporter.nextAttemptTime = time.Now().Add(reporter.backoff)
+}
+
+// sendHeartbeats sends heartbeats of instances reported and not terminated
+func (reporter *Reporter) sendHeartbeats() {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "Reporter.sendHeartbeats",
+	})
+
+	if time.Now().Before(reporter.nextAttemptTime) {
+		// the service is unreachable
+		return
+	}
+
+	reporter.mutex.Lock()
+	instances := []*types.ReportInstance{}
+	for _, instance := range reporter.instances {
+		instances = append(instances, instance)
+	}
+	reporter.mutex.Unlock()
+
+	for _, instance := range instances {
+		err := reporter.Client.Heartbeat(instance.InstanceID)
+		if serviceErr, ok := err.(*ServiceError); ok && serviceErr.StatusCode == http.StatusNotFound {
+			// the service lost the instance, e.g. it restarted without persistent storage or removed the instance by retention
+			logger.Infof("The service does not know instance %s, registering it again", instance.InstanceID)
+			_, err = reporter.Client.AddInstance(instance)
+			if err == nil {
+				err = reporter.Client.Heartbeat(instance.InstanceID)
+			}
+		}
+
+		if err != nil {
+			logger.WithError(err).Debugf("Could not send a heartbeat of instance %s", instance.InstanceID)
+		}
+	}
+}
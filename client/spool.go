@@ -0,0 +1,377 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolSegmentSuffix string = ".ndjson"
+	// unreadable parts of segments are kept for inspection in files with this suffix
+	spoolQuarantineSuffix string = ".corrupt"
+	// sequence of the first segment, segments can be prepended below it
+	spoolInitialSequence uint64 = 1 << 40
+)
+
+// reportSpool is a bounded on-disk FIFO queue of reports
+// Reports are stored in segment files, one report in JSON per line, and segments are consumed in sequence order
+// Quarantined files count toward the size limit, the oldest are removed to make room for reports
+type reportSpool struct {
+	dirPath  string
+	maxBytes int64
+
+	segments    []uint64
+	sizes       map[uint64]int64
+	quarantined map[uint64]int64
+	size        int64
+	mutex       sync.Mutex
+}
+
+// newReportSpool creates a spool in the directory, loading segments left by a previous run
+func newReportSpool(dirPath string, maxBytes int64) (*reportSpool, error) {
+	err := os.MkdirAll(dirPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &reportSpool{
+		dirPath:     dirPath,
+		maxBytes:    maxBytes,
+		segments:    []uint64{},
+		sizes:       map[uint64]int64{},
+		quarantined: map[uint64]int64{},
+		size:        0,
+		mutex:       sync.Mutex{},
+	}
+
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), spoolSegmentSuffix+spoolQuarantineSuffix) {
+			seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spoolSegmentSuffix+spoolQuarantineSuffix), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			spool.quarantined[seq] = entry.Size()
+			spool.size += entry.Size()
+			continue
+		}
+
+		if !strings.HasSuffix(entry.Name(), spoolSegmentSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		spool.segments = append(spool.segments, seq)
+		spool.sizes[seq] = entry.Size()
+		spool.size += entry.Size()
+	}
+
+	sort.Slice(spool.segments, func(i int, j int) bool {
+		return spool.segments[i] < spool.segments[j]
+	})
+
+	return spool, nil
+}
+
+func (spool *reportSpool) segmentPath(seq uint64) string {
+	return filepath.Join(spool.dirPath, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+}
+
+func (spool *reportSpool) quarantinePath(seq uint64) string {
+	return spool.segmentPath(seq) + spoolQuarantineSuffix
+}
+
+// Empty checks if the spool has no reports
+func (spool *reportSpool) Empty() bool {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return len(spool.segments) == 0
+}
+
+// Size returns the size of the spool in bytes, including quarantined files
+func (spool *reportSpool) Size() int64 {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.size
+}
+
+// Append adds reports after all spooled reports
+func (spool *reportSpool) Append(reports []*report) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	seq := spoolInitialSequence
+	if len(spool.segments) > 0 {
+		seq = spool.segments[len(spool.segments)-1] + 1
+	}
+
+	err := spool.writeSegment(seq, reports)
+	if err != nil {
+		return err
+	}
+
+	spool.segments = append(spool.segments, seq)
+	return nil
+}
+
+// Prepend adds reports before all spooled reports
+func (spool *reportSpool) Prepend(reports []*report) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	seq := spoolInitialSequence
+	if len(spool.segments) > 0 {
+		seq = spool.segments[0] - 1
+	}
+
+	err := spool.writeSegment(seq, reports)
+	if err != nil {
+		return err
+	}
+
+	spool.segments = append([]uint64{seq}, spool.segments...)
+	return nil
+}
+
+// writeSegment writes reports to a new segment file, it fails if the spool would exceed its size limit
+func (spool *reportSpool) writeSegment(seq uint64, reports []*report) error {
+	var buffer bytes.Buffer
+	for _, report := range reports {
+		reportBytes, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		buffer.Write(reportBytes)
+		buffer.WriteByte('\n')
+	}
+
+	size := int64(buffer.Len())
+	spool.pruneQuarantined(size)
+	if spool.maxBytes > 0 && spool.size+size > spool.maxBytes {
+		return fmt.Errorf("spool is full (%d bytes)", spool.size)
+	}
+
+	// write to a temp file first, so a crash never leaves a partial segment
+	tempPath := spool.segmentPath(seq) + ".tmp"
+	err := ioutil.WriteFile(tempPath, buffer.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tempPath, spool.segmentPath(seq))
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	spool.sizes[seq] = size
+	spool.size += size
+	return nil
+}
+
+// pruneQuarantined removes the oldest quarantined files until the size fits in the limit
+func (spool *reportSpool) pruneQuarantined(size int64) {
+	logger := log.WithFields(log.Fields{
+		"package":  "client",
+		"function": "reportSpool.pruneQuarantined",
+	})
+
+	if spool.maxBytes <= 0 || len(spool.quarantined) == 0 {
+		return
+	}
+
+	seqs := []uint64{}
+	for seq := range spool.quarantined {
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i int, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	for _, seq := range seqs {
+		if spool.size+size <= spool.maxBytes {
+			return
+		}
+
+		err := os.Remove(spool.quarantinePath(seq))
+		if err != nil && !os.IsNotExist(err) {
+			logger.WithError(err).Errorf("Could not remove a quarantined spool file %s", spool.quarantinePath(seq))
+			continue
+		}
+
+		logger.Warnf("Removed a quarantined spool file %s to make room for reports", spool.quarantinePath(seq))
+		spool.size -= spool.quarantined[seq]
+		delete(spool.quarantined, seq)
+	}
+}
+
+// Oldest returns the oldest segment and its reports
+// If the segment cannot be read to the end, the reports before the error are returned with the offset where reading stopped
+func (spool *reportSpool) Oldest() (uint64, []*report, int64, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if len(spool.segments) == 0 {
+		return 0, nil, 0, fmt.Errorf("spool is empty")
+	}
+
+	seq := spool.segments[0]
+	segmentFile, err := os.Open(spool.segmentPath(seq))
+	if err != nil {
+		return seq, nil, 0, err
+	}
+	defer segmentFile.Close()
+
+	reports := []*report{}
+	offset := int64(0)
+	scanner := bufio.NewScanner(segmentFile)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		offset += int64(advance)
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var r report
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			// skip a broken line rather than blocking the whole spool
+			continue
+		}
+
+		reports = append(reports, &r)
+	}
+
+	return seq, reports, offset, scanner.Err()
+}
+
+// Replace replaces reports in the segment, the segment is removed if no reports are given
+func (spool *reportSpool) Replace(seq uint64, reports []*report) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	spool.size -= spool.sizes[seq]
+	delete(spool.sizes, seq)
+
+	if len(reports) > 0 {
+		// never fails for the size limit as the segment only shrinks
+		maxBytes := spool.maxBytes
+		spool.maxBytes = 0
+		err := spool.writeSegment(seq, reports)
+		spool.maxBytes = maxBytes
+		return err
+	}
+
+	for i, segment := range spool.segments {
+		if segment == seq {
+			spool.segments = append(spool.segments[:i], spool.segments[i+1:]...)
+			break
+		}
+	}
+
+	err := os.Remove(spool.segmentPath(seq))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Quarantine moves the segment from the offset where it cannot be read to a file kept for inspection
+// The segment keeps the reports read before the offset, and is removed if none are given
+func (spool *reportSpool) Quarantine(seq uint64, offset int64, reports []*report) (string, error) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	segmentPath := spool.segmentPath(seq)
+	quarantinePath := spool.quarantinePath(seq)
+
+	segmentBytes, err := ioutil.ReadFile(segmentPath)
+	if err == nil && offset <= int64(len(segmentBytes)) {
+		tempPath := quarantinePath + ".tmp"
+		err = ioutil.WriteFile(tempPath, segmentBytes[offset:], 0600)
+		if err != nil {
+			return "", err
+		}
+
+		err = os.Rename(tempPath, quarantinePath)
+		if err != nil {
+			os.Remove(tempPath)
+			return "", err
+		}
+	} else {
+		// the whole file is kept if its remainder cannot be copied
+		reports = nil
+		err = os.Rename(segmentPath, quarantinePath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	quarantineSize := int64(0)
+	if info, err := os.Stat(quarantinePath); err == nil {
+		quarantineSize = info.Size()
+	}
+
+	spool.size -= spool.sizes[seq] + spool.quarantined[seq]
+	delete(spool.sizes, seq)
+	spool.quarantined[seq] = quarantineSize
+	spool.size += quarantineSize
+
+	if len(reports) > 0 {
+		// never fails for the size limit, the reports are a part of the segment
+		maxBytes := spool.maxBytes
+		spool.maxBytes = 0
+		err = spool.writeSegment(seq, reports)
+		spool.maxBytes = maxBytes
+		spool.pruneQuarantined(0)
+		return quarantinePath, err
+	}
+
+	for i, segment := range spool.segments {
+		if segment == seq {
+			spool.segments = append(spool.segments[:i], spool.segments[i+1:]...)
+			break
+		}
+	}
+
+	err = os.Remove(segmentPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	spool.pruneQuarantined(0)
+	return quarantinePath, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+const (
+	CleanupCommand string = "cleanup"
+)
+
+// cleanupMain clears data of a running service, all data or data older than the given days
+func cleanupMain(args []string) error {
+	var server serverOptions
+	var days int
+	var all bool
+
+	flagSet := flag.NewFlagSet(CleanupCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	flagSet.IntVar(&days, "days", 0, "Clear data older than the days")
+	flagSet.BoolVar(&all, "all", false, "Clear all data")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if all == (days > 0) {
+		flagSet.Usage()
+		return fmt.Errorf("either -days or -all must be given")
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	if all {
+		err = apiClient.CleanUp()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Cleared all data")
+		return nil
+	}
+
+	err = apiClient.CleanUpDaysOld(days)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cleared data older than %d days\n", days)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cyverse/irodsfs-monitor/client"
+	"github.com/cyverse/irodsfs-monitor/service"
+)
+
+// commands are subcommands that talk to a running service, given as the first argument
+var commands = map[string]func(args []string) error{
+	InstancesCommand: instancesMain,
+	TransfersCommand: transfersMain,
+	StatsCommand:     statsMain,
+	HotCommand:       hotMain,
+	TopCommand:       topMain,
+	CleanupCommand:   cleanupMain,
+	ExportCommand:    exportMain,
+	ImportCommand:    importMain,
+}
+
+// dispatchSubcommand runs a subcommand of a command, given as the first argument
+func dispatchSubcommand(command string, subcommands map[string]func(args []string) error, args []string) error {
+	names := []string{}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s <%s> [options]\n", os.Args[0], command, strings.Join(names, "|"))
+		if len(args) == 0 {
+			return fmt.Errorf("no %s command is given", command)
+		}
+		return flag.ErrHelp
+	}
+
+	subcommand, ok := subcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown %s command %s, expected one of %s", command, args[0], strings.Join(names, ", "))
+	}
+
+	return subcommand(args[1:])
+}
+
+// serverOptions are flags of subcommands to access a running service
+type serverOptions struct {
+	URL        string
+	Token      string
+	CACertPath string
+}
+
+func (options *serverOptions) addFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&options.URL, "server", fmt.Sprintf("http://localhost:%d", service.ServicePortDefault), "Service URL")
+	flagSet.StringVar(&options.Token, "token", os.Getenv("IRODSFS_MONITOR_TOKEN"), "Bearer token to authenticate")
+	flagSet.StringVar(&options.CACertPath, "ca", "", "CA certificate file to verify the service")
+}
+
+// newClient creates an API client, without timeout as subcommands may transfer large data
+func (options *serverOptions) newClient() (*client.APIClient, error) {
+	apiClient := client.NewAPIClientWithToken(options.URL, options.Token, 0)
+	if len(options.CACertPath) > 0 {
+		err := apiClient.SetTLS(options.CACertPath, "", "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return apiClient, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ExportCommand string = "export"
+)
+
+// exportMain exports a table from a running service to a file or stdout
+func exportMain(args []string) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "exportMain",
+	})
+
+	var server serverOptions
+	var outputPath string
+	var table, format, start, end string
+
+	flagSet := flag.NewFlagSet(ExportCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	flagSet.StringVar(&table, "table", types.ExportTableTransfers, "Table to export (instances, transfers or blocks)")
+	flagSet.StringVar(&format, "format", types.ExportFormatCSV, "Output format (csv, ndjson or parquet)")
+	flagSet.StringVar(&start, "start", "", "Start of the time range in RFC3339")
+	flagSet.StringVar(&end, "end", "", "End of the time range in RFC3339")
+	flagSet.StringVar(&outputPath, "o", "", "Output file path, stdout if empty")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	values := url.Values{}
+	values.Set("table", table)
+	values.Set("format", format)
+	values.Set("start", start)
+	values.Set("end", end)
+
+	query, err := types.NewExportQueryFromValues(values)
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if len(outputPath) > 0 {
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			logger.WithError(err).Errorf("Could not create the output file - %s", outputPath)
+			return err
+		}
+		defer outputFile.Close()
+
+		output = outputFile
+	}
+
+	return apiClient.Export(query, output)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cyverse/irodsfs-monitor/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ImportCommand string = "import"
+)
+
+// importMain imports dumps of instances, file transfers and transfer blocks into a running service
+// Dumps are imported in the given order, so instances should come before their transfers
+func importMain(args []string) error {
+	var server serverOptions
+	var dedup bool
+
+	flagSet := flag.NewFlagSet(ImportCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	flagSet.BoolVar(&dedup, "dedup", true, "Skip instances and transfers that already exist")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [options] <dump file, - for stdin>...\n", os.Args[0], ImportCommand)
+		fmt.Fprintln(flagSet.Output(), "Transfer blocks are joined to transfers in the same dump, concatenate exported transfers and blocks to keep them")
+		flagSet.PrintDefaults()
+	}
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return fmt.Errorf("no dump file is given")
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	for _, dumpPath := range flagSet.Args() {
+		err = importDump(apiClient, dumpPath, dedup)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func importDump(apiClient *client.APIClient, dumpPath string, dedup bool) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "main",
+		"function": "importDump",
+	})
+
+	var dump io.Reader = os.Stdin
+	if dumpPath != "-" {
+		dumpFile, err := os.Open(dumpPath)
+		if err != nil {
+			logger.WithError(err).Errorf("Could not open the dump file - %s", dumpPath)
+			return err
+		}
+		defer dumpFile.Close()
+
+		dump = dumpFile
+	}
+
+	result, err := apiClient.Import(dump, dedup)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: imported %d instances and %d transfers, skipped %d instances and %d transfers, rejected %d records\n", dumpPath, result.InstancesImported, result.TransfersImported, result.InstancesSkipped, result.TransfersSkipped, result.Rejected)
+	for _, importErr := range result.Errors {
+		fmt.Printf("%s: record %d - %s\n", dumpPath, importErr.Index, importErr.Error)
+	}
+
+	return nil
+}
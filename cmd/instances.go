@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	InstancesCommand string = "instances"
+)
+
+// instancesMain lists or gets instances of a running service
+func instancesMain(args []string) error {
+	return dispatchSubcommand(InstancesCommand, map[string]func(args []string) error{
+		"list": instancesListMain,
+		"get":  instancesGetMain,
+	}, args)
+}
+
+func instancesListMain(args []string) error {
+	var server serverOptions
+	var output outputOptions
+	var limit int
+	var all bool
+
+	queryFlags := []queryFlag{
+		{Name: "zone", Key: "zone", Usage: "Match the zone"},
+		{Name: "client-user", Key: "client_user", Usage: "Match the client user"},
+		{Name: "proxy-user", Key: "proxy_user", Usage: "Match the proxy user"},
+		{Name: "client-hostname", Key: "client_hostname", Usage: "Match the client hostname"},
+		{Name: "client-host-ip", Key: "client_host_ip", Usage: "Match the client host IP"},
+		{Name: "pool-address", Key: "pool_address", Usage: "Match the pool address"},
+		{Name: "terminated", Key: "terminated", Usage: "Match terminated (true) or not terminated (false) instances"},
+		{Name: "state", Key: "state", Usage: "Match the state (active, stale, lost or terminated)"},
+		{Name: "created-after", Key: "created_after", Usage: "Created at or after the time in RFC3339"},
+		{Name: "created-before", Key: "created_before", Usage: "Created before the time in RFC3339"},
+		{Name: "last-activity-after", Key: "last_activity_after", Usage: "Last active at or after the time in RFC3339"},
+		{Name: "last-activity-before", Key: "last_activity_before", Usage: "Last active before the time in RFC3339"},
+		{Name: "sort", Key: "sort", Usage: "Sort key (creation_time, last_activity_time, termination_time, instance_id, zone, client_user or client_hostname)"},
+		{Name: "order", Key: "order", Usage: "Sort order (asc or desc)"},
+		{Name: "cursor", Key: "cursor", Usage: "Cursor of the page to list"},
+	}
+
+	flagSet := flag.NewFlagSet(InstancesCommand+" list", flag.ContinueOnError)
+	server.addFlags(flagSet)
+	output.addFlags(flagSet)
+	addQueryFlags(flagSet, queryFlags)
+	flagSet.IntVar(&limit, "limit", 0, "Max number of instances in a page, unlimited if 0")
+	flagSet.BoolVar(&all, "all", false, "List all pages")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = output.validate()
+	if err != nil {
+		return err
+	}
+
+	values := queryValues(queryFlags)
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+
+	query, err := types.NewInstanceQueryFromValues(values)
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	instances := []types.ReportInstance{}
+	for {
+		page, nextCursor, err := apiClient.ListInstancesWithQuery(query)
+		if err != nil {
+			return err
+		}
+
+		instances = append(instances, page...)
+		if len(nextCursor) == 0 {
+			break
+		}
+
+		if !all {
+			fmt.Fprintf(os.Stderr, "More instances are available, list them with -cursor %s or -all\n", nextCursor)
+			break
+		}
+
+		query.Cursor = nextCursor
+	}
+
+	header := []string{"INSTANCE_ID", "STATE", "USER", "HOST", "ZONE", "CREATED", "LAST_ACTIVITY"}
+	return output.print(instances, header, func(table *outputTable) {
+		for _, instance := range instances {
+			host := instance.ClientHostname
+			if len(host) == 0 {
+				host = instance.ClientHostIP
+			}
+
+			table.row(instance.InstanceID, instance.State, instance.ClientUser, host, instance.Zone, formatOutputTime(instance.CreationTime), formatOutputTime(instance.LastActivityTime))
+		}
+	})
+}
+
+func instancesGetMain(args []string) error {
+	var server serverOptions
+	var output outputOptions
+
+	flagSet := flag.NewFlagSet(InstancesCommand+" get", flag.ContinueOnError)
+	server.addFlags(flagSet)
+	output.addFlags(flagSet)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s get [options] <instance ID>\n", os.Args[0], InstancesCommand)
+		flagSet.PrintDefaults()
+	}
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return fmt.Errorf("an instance ID must be given")
+	}
+
+	// flags may also follow the instance ID
+	instanceID := flagSet.Arg(0)
+	err = flagSet.Parse(flagSet.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	if flagSet.NArg() > 0 {
+		flagSet.Usage()
+		return fmt.Errorf("only one instance ID must be given")
+	}
+
+	err = output.validate()
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	instance, err := apiClient.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	return output.print(instance, []string{"FIELD", "VALUE"}, func(table *outputTable) {
+		table.row("instance_id", instance.InstanceID)
+		table.row("state", instance.State)
+		table.row("zone", instance.Zone)
+		table.row("client_user", instance.ClientUser)
+		table.row("proxy_user", instance.ProxyUser)
+		table.row("client_hostname", instance.ClientHostname)
+		table.row("client_host_ip", instance.ClientHostIP)
+		table.row("irods_host", fmt.Sprintf("%s:%d", instance.Host, instance.Port))
+		table.row("auth_scheme", instance.AuthScheme)
+		table.row("pool_address", instance.PoolAddress)
+		table.row("read_ahead_max", strconv.Itoa(instance.ReadAheadMax))
+		table.row("buffer_size_max", formatOutputSize(instance.BufferSizeMax))
+		table.row("connection_max", strconv.Itoa(instance.ConnectionMax))
+		table.row("operation_timeout", instance.OperationTimeout)
+		table.row("connection_idle_timeout", instance.ConnectionIdleTimeout)
+		table.row("metadata_cache_timeout", instance.MetadataCacheTimeout)
+		table.row("metadata_cache_cleanup_time", instance.MetadataCacheCleanupTime)
+		table.row("creation_time", formatOutputTime(instance.CreationTime))
+		table.row("last_activity_time", formatOutputTime(instance.LastActivityTime))
+		table.row("termination_time", formatOutputTime(instance.TerminationTime))
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -29,6 +30,12 @@ func (w *NilWriter) Write(p []byte) (n int, err error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			runCommand(command, os.Args[2:])
+		}
+	}
+
 	// check if this is subprocess running in the background
 	isChildProc := false
 
@@ -48,6 +55,19 @@ func main() {
 	}
 }
 
+// runCommand runs a subcommand and exits
+func runCommand(command func(args []string) error, args []string) {
+	err := command(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func RunFSDaemon(execPath string, config *service.Config) error {
 	return parentRun(execPath, config)
 }
@@ -243,8 +263,16 @@ func run(config *service.Config, isChildProcess bool) error {
 	})
 
 	// run a service
-	svc := service.NewMonitorService(config)
-	err := svc.Init()
+	svc, err := service.NewMonitorService(config)
+	if err != nil {
+		logger.WithError(err).Error("Could not create the service")
+		if isChildProcess {
+			fmt.Fprintln(os.Stderr, InterProcessCommunicationFinishError)
+		}
+		return err
+	}
+
+	err = svc.Init()
 	if err != nil {
 		logger.WithError(err).Error("Could not init the service")
 		if isChildProcess {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OutputFormatTable string = "table"
+	OutputFormatJSON  string = "json"
+	OutputFormatYAML  string = "yaml"
+)
+
+// outputOptions are flags of subcommands that print results
+type outputOptions struct {
+	Format string
+}
+
+func (options *outputOptions) addFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&options.Format, "output", OutputFormatTable, "Output format (table, json or yaml)")
+}
+
+func (options *outputOptions) validate() error {
+	switch options.Format {
+	case OutputFormatTable, OutputFormatJSON, OutputFormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %s", options.Format)
+	}
+}
+
+// print writes the value to stdout in the output format, writeTable writes rows of the table format
+func (options *outputOptions) print(value interface{}, header []string, writeTable func(table *outputTable)) error {
+	return options.write(os.Stdout, value, header, writeTable)
+}
+
+func (options *outputOptions) write(w io.Writer, value interface{}, header []string, writeTable func(table *outputTable)) error {
+	switch options.Format {
+	case OutputFormatJSON:
+		jsonBytes, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(jsonBytes))
+		return err
+	case OutputFormatYAML:
+		// go through JSON, so YAML has the same field names as the APIs
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+		decoder.UseNumber()
+
+		var generic interface{}
+		err = decoder.Decode(&generic)
+		if err != nil {
+			return err
+		}
+
+		yamlBytes, err := yaml.Marshal(yamlValue(generic))
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(yamlBytes)
+		return err
+	default:
+		table := &outputTable{
+			writer: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0),
+		}
+		if len(header) > 0 {
+			table.row(header...)
+		}
+
+		writeTable(table)
+		return table.writer.Flush()
+	}
+}
+
+// yamlValue converts JSON numbers in a decoded JSON value, so integers are not written as floats
+func yamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = yamlValue(elem)
+		}
+		return v
+	case []interface{}:
+		for idx, elem := range v {
+			v[idx] = yamlValue(elem)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// outputTable writes aligned columns
+type outputTable struct {
+	writer *tabwriter.Writer
+}
+
+func (table *outputTable) row(columns ...string) {
+	fmt.Fprintln(table.writer, strings.Join(columns, "\t"))
+}
+
+// formatOutputTime formats a time in the table format, empty if not set
+func formatOutputTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// formatOutputSize formats a size in bytes in the table format
+func formatOutputSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// queryFlag is a flag of a subcommand that sets an API query parameter
+type queryFlag struct {
+	Name  string
+	Key   string
+	Usage string
+	value string
+}
+
+// addQueryFlags adds string flags for API query parameters
+func addQueryFlags(flagSet *flag.FlagSet, flags []queryFlag) {
+	for idx := range flags {
+		flagSet.StringVar(&flags[idx].value, flags[idx].Name, "", flags[idx].Usage)
+	}
+}
+
+// queryValues returns API query parameters of flags that are given
+func queryValues(flags []queryFlag) url.Values {
+	values := url.Values{}
+	for _, f := range flags {
+		if len(f.value) > 0 {
+			values.Set(f.Key, f.value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	StatsCommand string = "stats"
+	HotCommand   string = "hot"
+)
+
+// statsMain prints aggregated statistics of file transfers of a running service
+func statsMain(args []string) error {
+	var server serverOptions
+	var output outputOptions
+
+	queryFlags := []queryFlag{
+		{Name: "group-by", Key: "group_by", Usage: "Group transfers by user, zone, host, instance or path_prefix, all transfers are in one group if empty"},
+		{Name: "path-depth", Key: "path_depth", Usage: "Number of path components of path_prefix groups (default 3)"},
+		{Name: "bucket", Key: "bucket", Usage: "Time bucket (minute, hour or day), the whole window is one bucket if empty"},
+		{Name: "start", Key: "start", Usage: "Start of the time window in RFC3339"},
+		{Name: "end", Key: "end", Usage: "End of the time window in RFC3339"},
+	}
+
+	flagSet := flag.NewFlagSet(StatsCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	output.addFlags(flagSet)
+	addQueryFlags(flagSet, queryFlags)
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = output.validate()
+	if err != nil {
+		return err
+	}
+
+	query, err := types.NewStatsQueryFromValues(queryValues(queryFlags))
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	stats, partial, err := apiClient.GetTransferStats(query)
+	if err != nil {
+		return err
+	}
+
+	if len(partial) > 0 {
+		fmt.Fprintf(os.Stderr, "Stats are partial, %s\n", partial)
+	}
+
+	header := []string{"GROUP", "BUCKET", "FILES", "TRANSFERRED", "AVERAGE"}
+	return output.print(stats, header, func(table *outputTable) {
+		for _, entry := range stats {
+			bucket := ""
+			if entry.BucketStart != nil {
+				bucket = formatOutputTime(*entry.BucketStart)
+			}
+
+			table.row(entry.Group, bucket, strconv.FormatInt(entry.FileCount, 10), formatOutputSize(entry.TotalTransferSize), formatOutputSize(entry.AverageTransferSize))
+		}
+	})
+}
+
+// hotMain prints a ranking of hot files or collections of a running service
+// It was the top command before top became the live view of instance activity
+func hotMain(args []string) error {
+	var server serverOptions
+	var output outputOptions
+
+	queryFlags := []queryFlag{
+		{Name: "target", Key: "target", Usage: "Rank file (default) or collection"},
+		{Name: "rank-by", Key: "rank_by", Usage: "Rank by bytes (default), opens or instances"},
+		{Name: "access", Key: "access", Usage: "Count read or write transfers, all transfers if empty"},
+		{Name: "limit", Key: "limit", Usage: "Max number of entries (default 20)"},
+		{Name: "start", Key: "start", Usage: "Start of the time window in RFC3339"},
+		{Name: "end", Key: "end", Usage: "End of the time window in RFC3339"},
+	}
+
+	flagSet := flag.NewFlagSet(HotCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	output.addFlags(flagSet)
+	addQueryFlags(flagSet, queryFlags)
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = output.validate()
+	if err != nil {
+		return err
+	}
+
+	query, err := types.NewTopQueryFromValues(queryValues(queryFlags))
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	entries, err := apiClient.GetTop(query)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"PATH", "TRANSFERRED", "OPENS", "INSTANCES"}
+	return output.print(entries, header, func(table *outputTable) {
+		for _, entry := range entries {
+			table.row(entry.Path, formatOutputSize(entry.TransferSize), strconv.FormatInt(entry.OpenCount, 10), strconv.FormatInt(entry.InstanceCount, 10))
+		}
+	})
+}
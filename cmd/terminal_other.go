@@ -0,0 +1,21 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "fmt"
+
+// isTerminal checks if the file descriptor is a terminal, terminals are not detected on this platform
+func isTerminal(fd int) bool {
+	return false
+}
+
+// makeRawTerminal is not supported on this platform, keys are read after Enter
+func makeRawTerminal(fd int) (func(), error) {
+	return nil, fmt.Errorf("raw terminal is not supported")
+}
+
+// terminalSize returns the default size, terminal sizes are not detected on this platform
+func terminalSize(fd int) (int, int) {
+	return terminalWidthDefault, terminalHeightDefault
+}
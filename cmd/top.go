@@ -0,0 +1,630 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cyverse/irodsfs-monitor/client"
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	TopCommand string = "top"
+
+	terminalWidthDefault  int = 80
+	terminalHeightDefault int = 24
+
+	topSortThroughput string = "throughput"
+	topSortOpens      string = "opens"
+	topSortUser       string = "user"
+	topSortHost       string = "host"
+	topSortInstance   string = "instance"
+	topSortUptime     string = "uptime"
+
+	topHelp string = "q quit  t/o/u/h/i/c sort  r reverse  / filter  esc clear filter  space refresh"
+)
+
+// topSortKeys are keys that sort instances
+var topSortKeys = map[byte]string{
+	't': topSortThroughput,
+	'o': topSortOpens,
+	'u': topSortUser,
+	'h': topSortHost,
+	'i': topSortInstance,
+	'c': topSortUptime,
+}
+
+// topInstance is an active instance with its recent activity
+type topInstance struct {
+	InstanceID   string
+	User         string
+	Host         string
+	Zone         string
+	CreationTime time.Time
+	RecentBytes  int64
+	RecentOpens  int64
+}
+
+// topUser is a user with active instances or transfers
+type topUser struct {
+	User        string
+	Instances   int64
+	RecentBytes int64
+	FileCount   int64
+	TotalBytes  int64
+}
+
+// topSnapshot is the state of the service at a refresh
+type topSnapshot struct {
+	Time      time.Time
+	Window    time.Duration
+	Instances []topInstance
+	// Opens are transfers opened in the window, the latest first
+	Opens     []types.ReportFileTransfer
+	OpenUsers map[string]string
+	Users     []topUser
+}
+
+// collectTop gets active instances, transfers in the window before now, and cumulative bytes per user
+func collectTop(apiClient *client.APIClient, window time.Duration, now time.Time) (*topSnapshot, error) {
+	instances, _, err := apiClient.ListInstancesWithQuery(&types.InstanceQuery{
+		State: types.InstanceStateActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	windowStart := now.Add(-window)
+
+	closedQuery := types.NewTransferQuery()
+	closedQuery.ClosedAfter = windowStart
+	closed, _, err := apiClient.ListFileTransfersWithQuery(closedQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	openedQuery := types.NewTransferQuery()
+	openedQuery.OpenedAfter = windowStart
+	openedQuery.SortBy = "file_open_time"
+	openedQuery.SortOrder = "desc"
+	opened, _, err := apiClient.ListFileTransfersWithQuery(openedQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// rollups count users over the whole time, so the stats are never partial
+	stats, _, err := apiClient.GetTransferStats(&types.StatsQuery{
+		GroupBy: types.StatsGroupByUser,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &topSnapshot{
+		Time:      now,
+		Window:    window,
+		Instances: []topInstance{},
+		Opens:     opened,
+		OpenUsers: map[string]string{},
+		Users:     []topUser{},
+	}
+
+	recentBytes := map[string]int64{}
+	for _, transfer := range closed {
+		recentBytes[transfer.InstanceID] += transfer.TransferSize
+	}
+
+	recentOpens := map[string]int64{}
+	for _, transfer := range opened {
+		recentOpens[transfer.InstanceID]++
+	}
+
+	users := map[string]*topUser{}
+	for _, instance := range instances {
+		host := instance.ClientHostname
+		if len(host) == 0 {
+			host = instance.ClientHostIP
+		}
+
+		snapshot.Instances = append(snapshot.Instances, topInstance{
+			InstanceID:   instance.InstanceID,
+			User:         instance.ClientUser,
+			Host:         host,
+			Zone:         instance.Zone,
+			CreationTime: instance.CreationTime,
+			RecentBytes:  recentBytes[instance.InstanceID],
+			RecentOpens:  recentOpens[instance.InstanceID],
+		})
+		snapshot.OpenUsers[instance.InstanceID] = instance.ClientUser
+
+		user, ok := users[instance.ClientUser]
+		if !ok {
+			user = &topUser{
+				User: instance.ClientUser,
+			}
+			users[instance.ClientUser] = user
+		}
+
+		user.Instances++
+		user.RecentBytes += recentBytes[instance.InstanceID]
+	}
+
+	for _, entry := range stats {
+		user, ok := users[entry.Group]
+		if !ok {
+			user = &topUser{
+				User: entry.Group,
+			}
+			users[entry.Group] = user
+		}
+
+		user.FileCount = entry.FileCount
+		user.TotalBytes = entry.TotalTransferSize
+	}
+
+	for _, user := range users {
+		snapshot.Users = append(snapshot.Users, *user)
+	}
+
+	sort.Slice(snapshot.Users, func(i int, j int) bool {
+		if snapshot.Users[i].TotalBytes != snapshot.Users[j].TotalBytes {
+			return snapshot.Users[i].TotalBytes > snapshot.Users[j].TotalBytes
+		}
+		return snapshot.Users[i].User < snapshot.Users[j].User
+	})
+
+	return snapshot, nil
+}
+
+// topView is the state of the terminal view
+type topView struct {
+	ServerURL string
+	Interval  time.Duration
+
+	SortBy  string
+	Reverse bool
+	Filter  string
+
+	// editing is true while a filter is typed
+	editing     bool
+	filterInput string
+
+	snapshot  *topSnapshot
+	lastError error
+}
+
+// matchFilter checks if any of the fields contains the filter, ignoring case
+func (view *topView) matchFilter(fields ...string) bool {
+	if len(view.Filter) == 0 {
+		return true
+	}
+
+	filter := strings.ToLower(view.Filter)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortInstances sorts instances by the sort key, the busiest, longest running or alphabetically first unless reversed
+func (view *topView) sortInstances(instances []topInstance) {
+	less := func(a *topInstance, b *topInstance) bool {
+		switch view.SortBy {
+		case topSortOpens:
+			if a.RecentOpens != b.RecentOpens {
+				return a.RecentOpens > b.RecentOpens
+			}
+		case topSortUser:
+			if a.User != b.User {
+				return a.User < b.User
+			}
+		case topSortHost:
+			if a.Host != b.Host {
+				return a.Host < b.Host
+			}
+		case topSortInstance:
+		case topSortUptime:
+			if !a.CreationTime.Equal(b.CreationTime) {
+				return a.CreationTime.Before(b.CreationTime)
+			}
+		default:
+			if a.RecentBytes != b.RecentBytes {
+				return a.RecentBytes > b.RecentBytes
+			}
+		}
+		return a.InstanceID < b.InstanceID
+	}
+
+	sort.SliceStable(instances, func(i int, j int) bool {
+		if view.Reverse {
+			return less(&instances[j], &instances[i])
+		}
+		return less(&instances[i], &instances[j])
+	})
+}
+
+// handleKey changes the view by a key, and returns false to quit
+func (view *topView) handleKey(key byte) bool {
+	if view.editing {
+		switch key {
+		case '\r', '\n':
+			view.Filter = view.filterInput
+			view.editing = false
+		case 27: // escape
+			view.editing = false
+		case 127, 8: // backspace
+			if len(view.filterInput) > 0 {
+				_, size := utf8.DecodeLastRuneInString(view.filterInput)
+				view.filterInput = view.filterInput[:len(view.filterInput)-size]
+			}
+		default:
+			if key >= 32 {
+				view.filterInput += string(key)
+			}
+		}
+		return true
+	}
+
+	switch key {
+	case 'q', 'Q', 3: // ctrl-c if signals are not raised
+		return false
+	case 'r':
+		view.Reverse = !view.Reverse
+	case '/':
+		view.editing = true
+		view.filterInput = view.Filter
+	case 27:
+		view.Filter = ""
+	default:
+		if sortBy, ok := topSortKeys[key]; ok {
+			if view.SortBy == sortBy {
+				view.Reverse = !view.Reverse
+			} else {
+				view.SortBy = sortBy
+				view.Reverse = false
+			}
+		}
+	}
+	return true
+}
+
+// formatRate formats bytes transferred in the window as bytes per second
+func formatRate(size int64, window time.Duration) string {
+	if window <= 0 {
+		return ""
+	}
+	return formatOutputSize(int64(float64(size)/window.Seconds())) + "/s"
+}
+
+// formatUptime formats how long an instance has been running
+func formatUptime(creationTime time.Time, now time.Time) string {
+	if creationTime.IsZero() {
+		return ""
+	}
+
+	uptime := now.Sub(creationTime)
+	if uptime < 0 {
+		uptime = 0
+	}
+
+	days := int64(uptime / (24 * time.Hour))
+	uptime -= time.Duration(days) * 24 * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%dd%02dh", days, int64(uptime/time.Hour))
+	}
+	return fmt.Sprintf("%02d:%02d", int64(uptime/time.Hour), int64((uptime%time.Hour)/time.Minute))
+}
+
+// tableLines formats rows in aligned columns, the header first
+func tableLines(header []string, rows [][]string) []string {
+	var sb strings.Builder
+	writer := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+
+	return strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+}
+
+// truncateLine cuts the line to the width in runes
+func truncateLine(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+
+	runes := []rune(line)
+	return string(runes[:width])
+}
+
+// render returns lines of the view, up to the height if positive
+// Rows of sections are limited to fit the height, space left by a section goes to the following ones
+func (view *topView) render(width int, height int) []string {
+	snapshot := view.snapshot
+	lines := []string{}
+
+	title := fmt.Sprintf("irodsfs-monitor top - %s - refresh every %s", view.ServerURL, view.Interval)
+	if snapshot != nil {
+		title = fmt.Sprintf("%s - %s", title, snapshot.Time.Local().Format("15:04:05"))
+	}
+	lines = append(lines, title)
+
+	if snapshot == nil {
+		if view.lastError != nil {
+			lines = append(lines, "Error: "+view.lastError.Error())
+		} else {
+			lines = append(lines, "Loading...")
+		}
+		return lines
+	}
+
+	instances := []topInstance{}
+	var recentBytes int64
+	for _, instance := range snapshot.Instances {
+		recentBytes += instance.RecentBytes
+		if view.matchFilter(instance.InstanceID, instance.User, instance.Host, instance.Zone) {
+			instances = append(instances, instance)
+		}
+	}
+	view.sortInstances(instances)
+
+	opens := []types.ReportFileTransfer{}
+	for _, transfer := range snapshot.Opens {
+		if view.matchFilter(transfer.InstanceID, snapshot.OpenUsers[transfer.InstanceID], transfer.FilePath) {
+			opens = append(opens, transfer)
+		}
+	}
+
+	users := []topUser{}
+	for _, user := range snapshot.Users {
+		if view.matchFilter(user.User) {
+			users = append(users, user)
+		}
+	}
+
+	summary := fmt.Sprintf("%d active instances, %s and %d opens in the last %s | sort: %s", len(snapshot.Instances), formatRate(recentBytes, snapshot.Window), len(snapshot.Opens), snapshot.Window, view.SortBy)
+	if view.Reverse {
+		summary += " (reversed)"
+	}
+	if len(view.Filter) > 0 {
+		summary += " | filter: " + view.Filter
+	}
+	lines = append(lines, summary)
+
+	if view.lastError != nil {
+		lines = append(lines, "Error: "+view.lastError.Error())
+	} else {
+		lines = append(lines, "")
+	}
+
+	instanceRows := [][]string{}
+	for _, instance := range instances {
+		instanceRows = append(instanceRows, []string{instance.InstanceID, instance.User, instance.Host, formatRate(instance.RecentBytes, snapshot.Window), formatOutputSize(instance.RecentBytes), strconv.FormatInt(instance.RecentOpens, 10), formatUptime(instance.CreationTime, snapshot.Time)})
+	}
+
+	openRows := [][]string{}
+	for _, transfer := range opens {
+		openRows = append(openRows, []string{transfer.FileOpenTime.Local().Format("15:04:05"), transfer.InstanceID, snapshot.OpenUsers[transfer.InstanceID], transfer.FileOpenMode, formatOutputSize(transfer.FileSize), transfer.FilePath})
+	}
+
+	userRows := [][]string{}
+	for _, user := range users {
+		userRows = append(userRows, []string{user.User, strconv.FormatInt(user.Instances, 10), formatRate(user.RecentBytes, snapshot.Window), strconv.FormatInt(user.FileCount, 10), formatOutputSize(user.TotalBytes)})
+	}
+
+	sections := []struct {
+		title  string
+		header []string
+		rows   [][]string
+		share  int
+	}{
+		{fmt.Sprintf("Active instances (%d)", len(instances)), []string{"INSTANCE_ID", "USER", "HOST", "THROUGHPUT", "BYTES", "OPENS", "UPTIME"}, instanceRows, 2},
+		{fmt.Sprintf("Recent file opens (%d)", len(opens)), []string{"OPENED", "INSTANCE_ID", "USER", "MODE", "FILE_SIZE", "PATH"}, openRows, 2},
+		{fmt.Sprintf("Users (%d)", len(users)), []string{"USER", "INSTANCES", "THROUGHPUT", "FILES", "TRANSFERRED"}, userRows, 1},
+	}
+
+	// title and header of each section, a blank line between sections, and the footer
+	available := height - len(lines) - 2*len(sections) - (len(sections) - 1) - 1
+	for idx, section := range sections {
+		rows := section.rows
+		if height > 0 {
+			limit := available
+			if idx < len(sections)-1 {
+				limit = available / section.share
+			}
+
+			if limit < 0 {
+				limit = 0
+			}
+
+			if len(rows) > limit {
+				rows = rows[:limit]
+			}
+			available -= len(rows)
+		}
+
+		if idx > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, section.title)
+		lines = append(lines, tableLines(section.header, rows)...)
+	}
+
+	if height > 0 {
+		for len(lines) < height-1 {
+			lines = append(lines, "")
+		}
+
+		if view.editing {
+			lines = append(lines, "filter (enter to apply, esc to cancel): "+view.filterInput+"_")
+		} else {
+			lines = append(lines, topHelp)
+		}
+
+		if len(lines) > height {
+			lines = lines[:height]
+		}
+	}
+
+	for idx := range lines {
+		lines[idx] = truncateLine(lines[idx], width)
+	}
+	return lines
+}
+
+// topMain shows live activity of a running service in the terminal
+func topMain(args []string) error {
+	var server serverOptions
+	var interval time.Duration
+	var window time.Duration
+	var once bool
+
+	view := &topView{}
+
+	flagSet := flag.NewFlagSet(TopCommand, flag.ContinueOnError)
+	server.addFlags(flagSet)
+	flagSet.DurationVar(&interval, "interval", 2*time.Second, "Refresh interval")
+	flagSet.DurationVar(&window, "window", 1*time.Minute, "Time window of recent throughput and file opens")
+	flagSet.StringVar(&view.SortBy, "sort", topSortThroughput, "Sort instances by throughput, opens, user, host, instance or uptime")
+	flagSet.StringVar(&view.Filter, "filter", "", "Show instances, opens and users that contain the text")
+	flagSet.BoolVar(&once, "once", false, "Print once and exit, also when the output is not a terminal")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s %s [options]\n", os.Args[0], TopCommand)
+		fmt.Fprintf(flagSet.Output(), "Shows live activity of instances, hot files and collections are ranked by %s %s, which was %s before\n", os.Args[0], HotCommand, TopCommand)
+		flagSet.PrintDefaults()
+	}
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 || window <= 0 {
+		return fmt.Errorf("interval and window must be positive")
+	}
+
+	switch view.SortBy {
+	case topSortThroughput, topSortOpens, topSortUser, topSortHost, topSortInstance, topSortUptime:
+	default:
+		return fmt.Errorf("unknown sort key %s", view.SortBy)
+	}
+
+	view.ServerURL = server.URL
+	view.Interval = interval
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	stdoutFd := int(os.Stdout.Fd())
+	if once || !isTerminal(stdoutFd) {
+		view.snapshot, err = collectTop(apiClient, window, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return writeLines(os.Stdout, view.render(0, 0))
+	}
+
+	return runTop(apiClient, view, window)
+}
+
+// runTop refreshes the view in the terminal until quit
+func runTop(apiClient *client.APIClient, view *topView, window time.Duration) error {
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+
+	keys := make(chan byte)
+	if isTerminal(stdinFd) {
+		restore, err := makeRawTerminal(stdinFd)
+		if err == nil {
+			defer restore()
+		}
+
+		go func() {
+			buffer := make([]byte, 1)
+			for {
+				n, err := os.Stdin.Read(buffer)
+				if err != nil {
+					return
+				}
+
+				if n > 0 {
+					keys <- buffer[0]
+				}
+			}
+		}()
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+
+	// use the alternate screen and hide the cursor, both are restored on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	draw := func() {
+		width, height := terminalSize(stdoutFd)
+		fmt.Print("\x1b[H\x1b[2J" + strings.Join(view.render(width, height), "\r\n"))
+	}
+
+	refresh := func() {
+		snapshot, err := collectTop(apiClient, window, time.Now())
+		view.lastError = err
+		if err == nil {
+			view.snapshot = snapshot
+		}
+		draw()
+	}
+
+	draw()
+	refresh()
+
+	ticker := time.NewTicker(view.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signalChan:
+			return nil
+		case <-ticker.C:
+			refresh()
+		case key := <-keys:
+			if !view.handleKey(key) {
+				return nil
+			}
+
+			if key == ' ' && !view.editing {
+				refresh()
+			} else {
+				draw()
+			}
+		}
+	}
+}
+
+// writeLines writes lines to w
+func writeLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	TransfersCommand string = "transfers"
+)
+
+// transfersMain lists file transfers of a running service
+func transfersMain(args []string) error {
+	return dispatchSubcommand(TransfersCommand, map[string]func(args []string) error{
+		"list": transfersListMain,
+	}, args)
+}
+
+func transfersListMain(args []string) error {
+	var server serverOptions
+	var output outputOptions
+	var limit int
+	var all bool
+
+	queryFlags := []queryFlag{
+		{Name: "instance-id", Key: "instance_id", Usage: "Match the instance that made the transfer"},
+		{Name: "zone", Key: "zone", Usage: "Match the zone of the instance"},
+		{Name: "client-user", Key: "client_user", Usage: "Match the client user of the instance"},
+		{Name: "client-hostname", Key: "client_hostname", Usage: "Match the client hostname of the instance"},
+		{Name: "client-host-ip", Key: "client_host_ip", Usage: "Match the client host IP of the instance"},
+		{Name: "file-path", Key: "file_path", Usage: "Match the file path"},
+		{Name: "file-path-prefix", Key: "file_path_prefix", Usage: "Match file paths that start with the prefix"},
+		{Name: "file-path-glob", Key: "file_path_glob", Usage: "Match file paths with a glob pattern"},
+		{Name: "file-open-mode", Key: "file_open_mode", Usage: "Match the file open mode"},
+		{Name: "min-file-size", Key: "min_file_size", Usage: "Min file size in bytes"},
+		{Name: "max-file-size", Key: "max_file_size", Usage: "Max file size in bytes"},
+		{Name: "min-transfer-size", Key: "min_transfer_size", Usage: "Min transfer size in bytes"},
+		{Name: "max-transfer-size", Key: "max_transfer_size", Usage: "Max transfer size in bytes"},
+		{Name: "sequential-access", Key: "sequential_access", Usage: "Match sequential (true) or non-sequential (false) transfers"},
+		{Name: "opened-after", Key: "opened_after", Usage: "Opened at or after the time in RFC3339"},
+		{Name: "opened-before", Key: "opened_before", Usage: "Opened before the time in RFC3339"},
+		{Name: "closed-after", Key: "closed_after", Usage: "Closed at or after the time in RFC3339"},
+		{Name: "closed-before", Key: "closed_before", Usage: "Closed before the time in RFC3339"},
+		{Name: "sort", Key: "sort", Usage: "Sort key (file_open_time, file_close_time, file_path, file_size or transfer_size)"},
+		{Name: "order", Key: "order", Usage: "Sort order (asc or desc)"},
+		{Name: "cursor", Key: "cursor", Usage: "Cursor of the page to list"},
+	}
+
+	flagSet := flag.NewFlagSet(TransfersCommand+" list", flag.ContinueOnError)
+	server.addFlags(flagSet)
+	output.addFlags(flagSet)
+	addQueryFlags(flagSet, queryFlags)
+	flagSet.IntVar(&limit, "limit", 0, "Max number of transfers in a page, unlimited if 0")
+	flagSet.BoolVar(&all, "all", false, "List all pages")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	err = output.validate()
+	if err != nil {
+		return err
+	}
+
+	values := queryValues(queryFlags)
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+
+	query, err := types.NewTransferQueryFromValues(values)
+	if err != nil {
+		return err
+	}
+
+	apiClient, err := server.newClient()
+	if err != nil {
+		return err
+	}
+
+	transfers := []types.ReportFileTransfer{}
+	for {
+		page, nextCursor, err := apiClient.ListFileTransfersWithQuery(query)
+		if err != nil {
+			return err
+		}
+
+		transfers = append(transfers, page...)
+		if len(nextCursor) == 0 {
+			break
+		}
+
+		if !all {
+			fmt.Fprintf(os.Stderr, "More transfers are available, list them with -cursor %s or -all\n", nextCursor)
+			break
+		}
+
+		query.Cursor = nextCursor
+	}
+
+	header := []string{"INSTANCE_ID", "OPENED", "CLOSED", "MODE", "FILE_SIZE", "TRANSFERRED", "PATH", "ERROR"}
+	return output.print(transfers, header, func(table *outputTable) {
+		for _, transfer := range transfers {
+			table.row(transfer.InstanceID, formatOutputTime(transfer.FileOpenTime), formatOutputTime(transfer.FileCloseTime), transfer.FileOpenMode, formatOutputSize(transfer.FileSize), formatOutputSize(transfer.TransferSize), transfer.FilePath, transfer.Error)
+		}
+	})
+}
@@ -1,12 +1,13 @@
 module github.com/cyverse/irodsfs-monitor
 
-go 1.14
+go 1.16
 
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/rs/xid v1.3.0
 	github.com/sirupsen/logrus v1.8.1
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 	gopkg.in/yaml.v2 v2.4.0
 )
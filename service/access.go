@@ -0,0 +1,372 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// accessPatternThreshold is the fraction of block transitions that must follow a pattern for a transfer to have the pattern
+const accessPatternThreshold float64 = 0.9
+
+// accessInterval is a byte range [Start, End) of a file
+type accessInterval struct {
+	Start int64
+	End   int64
+}
+
+// transferAccess is the access pattern of a file transfer
+type transferAccess struct {
+	Pattern      string
+	Stride       int64
+	ReRead       bool
+	PartialRead  bool
+	Blocks       []types.FileBlock
+	Intervals    []accessInterval
+	AccessedSize int64
+}
+
+// orderedBlocks returns non-empty blocks of the transfer in access order
+func orderedBlocks(transfer *types.ReportFileTransfer) []types.FileBlock {
+	blocks := []types.FileBlock{}
+	for _, block := range transfer.TransferBlocks {
+		if block.Length > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+
+	// blocks with the same or no access time stay in the reported order
+	sort.SliceStable(blocks, func(i int, j int) bool {
+		return blocks[i].AccessTime.Before(blocks[j].AccessTime)
+	})
+
+	return blocks
+}
+
+// classifyBlocks returns whether blocks are accessed sequentially, in strides or randomly
+// A stride is the distance between offsets of successive blocks that leave a gap between them
+func classifyBlocks(blocks []types.FileBlock) (string, int64) {
+	if len(blocks) == 0 {
+		return types.AccessPatternUnknown, 0
+	}
+
+	if len(blocks) == 1 {
+		return types.AccessPatternSequential, 0
+	}
+
+	transitions := len(blocks) - 1
+	sequentialTransitions := 0
+	strides := map[int64]int{}
+	for i := 1; i < len(blocks); i++ {
+		prev := &blocks[i-1]
+		gap := blocks[i].Offset - (prev.Offset + prev.Length)
+		if gap == 0 {
+			sequentialTransitions++
+		} else if gap > 0 {
+			strides[blocks[i].Offset-prev.Offset]++
+		}
+	}
+
+	if float64(sequentialTransitions)/float64(transitions) >= accessPatternThreshold {
+		return types.AccessPatternSequential, 0
+	}
+
+	stride, strideCount := mostCommonStride(strides)
+	if float64(strideCount)/float64(transitions) >= accessPatternThreshold {
+		return types.AccessPatternStrided, stride
+	}
+
+	return types.AccessPatternRandom, 0
+}
+
+// mostCommonStride returns the stride counted the most, the smallest one on ties
+func mostCommonStride(strides map[int64]int) (int64, int) {
+	var stride int64
+	count := 0
+	for s, c := range strides {
+		if c > count || (c == count && s < stride) {
+			stride = s
+			count = c
+		}
+	}
+
+	return stride, count
+}
+
+// mergeIntervals merges overlapping intervals, and returns them with the number of bytes they cover
+func mergeIntervals(intervals []accessInterval) ([]accessInterval, int64) {
+	sorted := make([]accessInterval, len(intervals))
+	copy(sorted, intervals)
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	merged := []accessInterval{}
+	var size int64
+	for _, interval := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && interval.Start <= merged[last].End {
+			if interval.End > merged[last].End {
+				size += interval.End - merged[last].End
+				merged[last].End = interval.End
+			}
+			continue
+		}
+
+		merged = append(merged, interval)
+		size += interval.End - interval.Start
+	}
+
+	return merged, size
+}
+
+// analyzeTransferAccess finds the access pattern of a file transfer from its blocks
+func analyzeTransferAccess(transfer *types.ReportFileTransfer) *transferAccess {
+	access := &transferAccess{
+		Blocks:    orderedBlocks(transfer),
+		Intervals: []accessInterval{},
+	}
+
+	access.Pattern, access.Stride = classifyBlocks(access.Blocks)
+
+	for _, block := range access.Blocks {
+		access.Intervals = append(access.Intervals, accessInterval{
+			Start: block.Offset,
+			End:   block.Offset + block.Length,
+		})
+		access.AccessedSize += block.Length
+	}
+
+	_, uniqueSize := mergeIntervals(access.Intervals)
+	access.ReRead = uniqueSize < access.AccessedSize
+	access.PartialRead = len(access.Blocks) > 0 && uniqueSize < transfer.FileSize
+	return access
+}
+
+// blockSizeBucketMin returns the lower bound of the histogram bucket of the block size, buckets are powers of two
+func blockSizeBucketMin(length int64) int64 {
+	var min int64 = 1
+	for min*2 <= length {
+		min *= 2
+	}
+	return min
+}
+
+// AnalyzeAccess finds the access pattern of file transfers on a path
+func AnalyzeAccess(filePath string, transfers []types.ReportFileTransfer) *types.AccessAnalysis {
+	analysis := &types.AccessAnalysis{
+		FilePath:           filePath,
+		PatternCounts:      map[string]int64{},
+		BlockSizeHistogram: []types.BlockSizeBucket{},
+	}
+
+	intervals := []accessInterval{}
+	histogram := map[int64]int64{}
+	strides := map[int64]int{}
+	for idx := range transfers {
+		transfer := &transfers[idx]
+		access := analyzeTransferAccess(transfer)
+
+		analysis.TransferCount++
+		if transfer.FileSize > analysis.FileSize {
+			analysis.FileSize = transfer.FileSize
+		}
+
+		analysis.BlockCount += int64(len(access.Blocks))
+		analysis.AccessedSize += access.AccessedSize
+		intervals = append(intervals, access.Intervals...)
+
+		analysis.PatternCounts[access.Pattern]++
+		if access.Pattern == types.AccessPatternStrided {
+			strides[access.Stride]++
+		}
+
+		if access.ReRead {
+			analysis.PatternCounts[types.AccessPatternReRead]++
+		}
+
+		if access.PartialRead {
+			analysis.PatternCounts[types.AccessPatternPartialRead]++
+		}
+
+		if transfer.SequentialAccess {
+			analysis.SequentialAccessReported++
+		}
+
+		// transfers without blocks cannot be verified
+		if access.Pattern != types.AccessPatternUnknown {
+			observed := access.Pattern == types.AccessPatternSequential
+			if observed {
+				analysis.SequentialAccessObserved++
+			}
+
+			if observed != transfer.SequentialAccess {
+				analysis.SequentialAccessMismatches++
+			}
+		}
+
+		for _, block := range access.Blocks {
+			histogram[blockSizeBucketMin(block.Length)]++
+		}
+	}
+
+	_, analysis.UniqueSize = mergeIntervals(intervals)
+
+	if analysis.FileSize > 0 {
+		analysis.Coverage = float64(analysis.UniqueSize) / float64(analysis.FileSize)
+		if analysis.Coverage > 1 {
+			// blocks past the end of a file that was truncated
+			analysis.Coverage = 1
+		}
+	}
+
+	if analysis.UniqueSize > 0 {
+		analysis.ReReadAmplification = float64(analysis.AccessedSize) / float64(analysis.UniqueSize)
+	}
+
+	analysis.Pattern = types.AccessPatternUnknown
+	var patternCount int64
+	for _, pattern := range []string{types.AccessPatternSequential, types.AccessPatternStrided, types.AccessPatternRandom} {
+		if analysis.PatternCounts[pattern] > patternCount {
+			analysis.Pattern = pattern
+			patternCount = analysis.PatternCounts[pattern]
+		}
+	}
+
+	if analysis.Pattern == types.AccessPatternStrided {
+		analysis.Stride, _ = mostCommonStride(strides)
+	}
+
+	for min, count := range histogram {
+		analysis.BlockSizeHistogram = append(analysis.BlockSizeHistogram, types.BlockSizeBucket{
+			Min:   min,
+			Max:   min*2 - 1,
+			Count: count,
+		})
+	}
+
+	sort.Slice(analysis.BlockSizeHistogram, func(i int, j int) bool {
+		return analysis.BlockSizeHistogram[i].Min < analysis.BlockSizeHistogram[j].Min
+	})
+
+	return analysis
+}
+
+// ListFileTransfersForAccess returns file transfers on the path in the query
+func ListFileTransfersForAccess(query *types.AccessQuery, storage Storage) []types.ReportFileTransfer {
+	instanceIDs := []string{}
+	if len(query.InstanceID) > 0 {
+		instanceIDs = append(instanceIDs, query.InstanceID)
+	} else {
+		for _, instance := range storage.ListInstances() {
+			instanceIDs = append(instanceIDs, instance.InstanceID)
+		}
+	}
+
+	transfers := []types.ReportFileTransfer{}
+	for _, instanceID := range instanceIDs {
+		for _, transfer := range storage.ListFileTransfersForInstance(instanceID) {
+			if transfer.FilePath != query.FilePath {
+				continue
+			}
+
+			t := transferTime(&transfer)
+			if !query.StartTime.IsZero() && t.Before(query.StartTime) {
+				continue
+			}
+
+			if !query.EndTime.IsZero() && !t.Before(query.EndTime) {
+				continue
+			}
+
+			transfers = append(transfers, transfer)
+		}
+	}
+
+	return transfers
+}
+
+func (svc *MonitorService) getTransferAccess(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getTransferAccess",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	vars := mux.Vars(r)
+	instanceID := vars["instance_id"]
+
+	sequence, err := strconv.ParseInt(vars["sequence"], 10, 64)
+	if err != nil || sequence <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid transfer sequence number %s", vars["sequence"])))
+		return
+	}
+
+	if _, ok := svc.Storage.GetInstance(instanceID); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unable to find an instance for ID %s", instanceID)))
+		return
+	}
+
+	transfer, ok := svc.Storage.GetFileTransfer(instanceID, sequence)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unable to find a transfer for ID %s", transferID(instanceID, sequence))))
+		return
+	}
+
+	analysis := AnalyzeAccess(transfer.FilePath, []types.ReportFileTransfer{transfer})
+	analysis.TransferID = transferID(instanceID, sequence)
+
+	responseJSON, err := json.Marshal(analysis)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
+
+func (svc *MonitorService) getPathAccess(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getPathAccess",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	query, err := types.NewAccessQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	transfers := ListFileTransfersForAccess(query, svc.Storage)
+	analysis := AnalyzeAccess(query.FilePath, transfers)
+
+	responseJSON, err := json.Marshal(analysis)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
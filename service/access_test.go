@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/gorilla/mux"
+)
+
+var accessTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// accessTestTransfer makes a transfer of blocks accessed in the given order, blocks are offset and length pairs
+func accessTestTransfer(fileSize int64, blocks ...int64) types.ReportFileTransfer {
+	transfer := types.ReportFileTransfer{
+		InstanceID:     "a",
+		FilePath:       "/zone/home/user/file",
+		FileSize:       fileSize,
+		TransferBlocks: []types.FileBlock{},
+	}
+
+	for i := 0; i+1 < len(blocks); i += 2 {
+		transfer.TransferBlocks = append(transfer.TransferBlocks, types.FileBlock{
+			Offset:     blocks[i],
+			Length:     blocks[i+1],
+			AccessTime: accessTestTime.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	return transfer
+}
+
+// accessTestSequentialBlocks returns count blocks of the length from offset 0 without gaps
+func accessTestSequentialBlocks(count int, length int64) []int64 {
+	blocks := []int64{}
+	for i := 0; i < count; i++ {
+		blocks = append(blocks, int64(i)*length, length)
+	}
+	return blocks
+}
+
+func TestAnalyzeTransferAccess(t *testing.T) {
+	// 11 blocks with one gap, so 9 of 10 transitions are sequential
+	oneGap := accessTestSequentialBlocks(11, 100)
+	for i := 12; i < len(oneGap); i += 2 {
+		oneGap[i] += 50
+	}
+
+	// 10 blocks in strides with one random jump, and with two
+	oneJump := []int64{}
+	for i := int64(0); i < 10; i++ {
+		oneJump = append(oneJump, i*300, 100)
+	}
+	oneJump = append(oneJump, 10000, 100)
+	twoJumps := append(append([]int64{}, oneJump...), 20000, 100)
+
+	// blocks reported out of order with access times in order
+	reordered := accessTestTransfer(300, 0, 100, 100, 100, 200, 100)
+	reordered.TransferBlocks[0], reordered.TransferBlocks[2] = reordered.TransferBlocks[2], reordered.TransferBlocks[0]
+
+	tests := []struct {
+		name        string
+		transfer    types.ReportFileTransfer
+		pattern     string
+		stride      int64
+		reRead      bool
+		partialRead bool
+		accessed    int64
+	}{
+		{"no blocks", accessTestTransfer(1000), types.AccessPatternUnknown, 0, false, false, 0},
+		{"empty blocks are ignored", accessTestTransfer(1000, 0, 0, 500, 0), types.AccessPatternUnknown, 0, false, false, 0},
+		{"one block", accessTestTransfer(100, 0, 100), types.AccessPatternSequential, 0, false, false, 100},
+		{"sequential", accessTestTransfer(1000, accessTestSequentialBlocks(10, 100)...), types.AccessPatternSequential, 0, false, false, 1000},
+		{"sequential at the threshold", accessTestTransfer(1150, oneGap...), types.AccessPatternSequential, 0, false, true, 1100},
+		{"sequential part of a file", accessTestTransfer(5000, accessTestSequentialBlocks(10, 100)...), types.AccessPatternSequential, 0, false, true, 1000},
+		{"access time order", reordered, types.AccessPatternSequential, 0, false, false, 300},
+		{"strided", accessTestTransfer(1000, 0, 100, 250, 100, 500, 100, 750, 100), types.AccessPatternStrided, 250, false, true, 400},
+		{"strided at the threshold", accessTestTransfer(20000, oneJump...), types.AccessPatternStrided, 300, false, true, 1100},
+		{"strided below the threshold", accessTestTransfer(30000, twoJumps...), types.AccessPatternRandom, 0, false, true, 1200},
+		{"random", accessTestTransfer(1000, 500, 100, 0, 100, 800, 100, 200, 100), types.AccessPatternRandom, 0, false, true, 400},
+		{"backwards", accessTestTransfer(300, 200, 100, 100, 100, 0, 100), types.AccessPatternRandom, 0, false, false, 300},
+		{"re-read", accessTestTransfer(200, 0, 200, 0, 100), types.AccessPatternRandom, 0, true, false, 300},
+		{"overlapping blocks", accessTestTransfer(300, 0, 200, 100, 200), types.AccessPatternRandom, 0, true, false, 400},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access := analyzeTransferAccess(&test.transfer)
+
+			if access.Pattern != test.pattern {
+				t.Errorf("expected pattern %s, got %s", test.pattern, access.Pattern)
+			}
+
+			if access.Stride != test.stride {
+				t.Errorf("expected stride %d, got %d", test.stride, access.Stride)
+			}
+
+			if access.ReRead != test.reRead {
+				t.Errorf("expected re-read %t, got %t", test.reRead, access.ReRead)
+			}
+
+			if access.PartialRead != test.partialRead {
+				t.Errorf("expected partial read %t, got %t", test.partialRead, access.PartialRead)
+			}
+
+			if access.AccessedSize != test.accessed {
+				t.Errorf("expected %d bytes accessed, got %d", test.accessed, access.AccessedSize)
+			}
+		})
+	}
+}
+
+func TestAnalyzeAccess(t *testing.T) {
+	sequential := accessTestTransfer(1000, accessTestSequentialBlocks(10, 100)...)
+	sequential.SequentialAccess = true
+
+	// reported sequential, but the blocks are not
+	strided := accessTestTransfer(1000, 0, 100, 250, 100, 500, 100, 750, 100)
+	strided.SequentialAccess = true
+
+	partial := accessTestTransfer(1000, 0, 100, 100, 100, 200, 300)
+	unknown := accessTestTransfer(1000)
+
+	analysis := AnalyzeAccess("/zone/home/user/file", []types.ReportFileTransfer{sequential, strided, partial, unknown})
+
+	expectedCounts := map[string]int64{
+		types.AccessPatternSequential:  2,
+		types.AccessPatternStrided:     1,
+		types.AccessPatternUnknown:     1,
+		types.AccessPatternPartialRead: 2,
+	}
+	if !reflect.DeepEqual(analysis.PatternCounts, expectedCounts) {
+		t.Errorf("expected pattern counts %v, got %v", expectedCounts, analysis.PatternCounts)
+	}
+
+	if analysis.Pattern != types.AccessPatternSequential {
+		t.Errorf("expected pattern %s, got %s", types.AccessPatternSequential, analysis.Pattern)
+	}
+
+	if analysis.TransferCount != 4 || analysis.BlockCount != 17 {
+		t.Errorf("expected 4 transfers and 17 blocks, got %d and %d", analysis.TransferCount, analysis.BlockCount)
+	}
+
+	if analysis.SequentialAccessReported != 2 || analysis.SequentialAccessObserved != 2 || analysis.SequentialAccessMismatches != 2 {
+		t.Errorf("expected 2 reported, 2 observed and 2 mismatches, got %d, %d and %d",
+			analysis.SequentialAccessReported, analysis.SequentialAccessObserved, analysis.SequentialAccessMismatches)
+	}
+
+	if analysis.AccessedSize != 1900 || analysis.UniqueSize != 1000 || analysis.Coverage != 1 {
+		t.Errorf("expected 1900 bytes accessed, 1000 unique and full coverage, got %d, %d and %f",
+			analysis.AccessedSize, analysis.UniqueSize, analysis.Coverage)
+	}
+
+	expectedHistogram := []types.BlockSizeBucket{
+		{Min: 64, Max: 127, Count: 16},
+		{Min: 256, Max: 511, Count: 1},
+	}
+	if !reflect.DeepEqual(analysis.BlockSizeHistogram, expectedHistogram) {
+		t.Errorf("expected histogram %v, got %v", expectedHistogram, analysis.BlockSizeHistogram)
+	}
+}
+
+func TestAnalyzeAccessStrided(t *testing.T) {
+	transfers := []types.ReportFileTransfer{
+		accessTestTransfer(1000, 0, 100, 250, 100, 500, 100),
+		accessTestTransfer(1000, 0, 100, 250, 100, 500, 100),
+		accessTestTransfer(1000, 0, 100, 400, 100, 800, 100),
+	}
+
+	analysis := AnalyzeAccess("/zone/home/user/file", transfers)
+	if analysis.Pattern != types.AccessPatternStrided || analysis.Stride != 250 {
+		t.Errorf("expected strided by 250, got %s by %d", analysis.Pattern, analysis.Stride)
+	}
+}
+
+// TestGetTransferAccess checks that transfers are found by their sequence numbers after earlier transfers are removed
+func TestGetTransferAccess(t *testing.T) {
+	storage := NewMemoryStorage()
+	err := storage.AddInstance(types.ReportInstance{InstanceID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fileSize := range []int64{100, 200, 300} {
+		transfer := accessTestTransfer(fileSize, 0, fileSize)
+		transfer.FileOpenTime = accessTestTime
+		transfer.FileCloseTime = accessTestTime.Add(time.Duration(fileSize) * time.Second)
+		err = storage.AddFileTransfer(transfer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage.ApplyRetention(&RetentionPolicy{MaxTransfersPerInstance: 2}, accessTestTime.Add(time.Hour))
+
+	svc := &MonitorService{Storage: storage}
+	router := mux.NewRouter()
+	router.HandleFunc("/transfers/{instance_id}/{sequence}/access", svc.getTransferAccess)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		fileSize int64
+	}{
+		{"kept transfer", "/transfers/a/2/access", http.StatusOK, 200},
+		{"last transfer", "/transfers/a/3/access", http.StatusOK, 300},
+		{"removed transfer", "/transfers/a/1/access", http.StatusNotFound, 0},
+		{"future transfer", "/transfers/a/4/access", http.StatusNotFound, 0},
+		{"unknown instance", "/transfers/b/1/access", http.StatusNotFound, 0},
+		{"zero sequence", "/transfers/a/0/access", http.StatusBadRequest, 0},
+		{"invalid sequence", "/transfers/a/x/access", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d - %s", test.status, recorder.Code, recorder.Body.String())
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			analysis := types.AccessAnalysis{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &analysis)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if analysis.FileSize != test.fileSize {
+				t.Errorf("expected the transfer of a %d bytes file, got %d", test.fileSize, analysis.FileSize)
+			}
+		})
+	}
+}
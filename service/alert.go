@@ -0,0 +1,593 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// alertResolvedRetention is how long resolved alerts are listed
+	alertResolvedRetention time.Duration = 24 * time.Hour
+)
+
+// AlertNotifier delivers alerts when they fire or resolve
+type AlertNotifier interface {
+	Notify(alert *types.Alert) error
+}
+
+// NewAlertNotifier creates an alert notifier from config
+func NewAlertNotifier(config *AlertNotifierConfig, timeout time.Duration) (AlertNotifier, error) {
+	switch config.Type {
+	case AlertNotifierWebhook:
+		return &WebhookAlertNotifier{
+			Config: *config,
+			httpClient: &http.Client{
+				Timeout: timeout,
+			},
+		}, nil
+	case AlertNotifierSMTP:
+		return &SMTPAlertNotifier{
+			Config:  *config,
+			Timeout: timeout,
+		}, nil
+	case AlertNotifierLog:
+		return &LogAlertNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert notifier type %s", config.Type)
+	}
+}
+
+// describeAlert returns a human readable summary of the alert
+func describeAlert(alert *types.Alert) string {
+	return fmt.Sprintf("[%s] %s %s: %s", alert.State, alert.Severity, alert.ID, alert.Message)
+}
+
+// WebhookAlertNotifier posts alerts to a webhook
+type WebhookAlertNotifier struct {
+	Config     AlertNotifierConfig
+	httpClient *http.Client
+}
+
+// Notify posts the alert
+func (notifier *WebhookAlertNotifier) Notify(alert *types.Alert) error {
+	var body []byte
+	var err error
+	if notifier.Config.Format == WebhookFormatSlack {
+		body, err = json.Marshal(map[string]string{
+			"text": describeAlert(alert),
+		})
+	} else {
+		body, err = json.Marshal(alert)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", notifier.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(WebhookEventHeader, "alert_"+alert.State)
+	if len(notifier.Config.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(notifier.Config.Secret, body))
+	}
+
+	resp, err := notifier.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook error returned - %s", resp.Status)
+	}
+
+	return nil
+}
+
+// SMTPAlertNotifier mails alerts
+type SMTPAlertNotifier struct {
+	Config AlertNotifierConfig
+	// Timeout limits the whole SMTP session, so an unresponsive server never blocks alert evaluation
+	Timeout time.Duration
+}
+
+// Notify mails the alert
+func (notifier *SMTPAlertNotifier) Notify(alert *types.Alert) error {
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("From: %s\r\n", notifier.Config.From))
+	sb.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(notifier.Config.To, ", ")))
+	sb.WriteString(fmt.Sprintf("Subject: [irodsfs-monitor] %s %s %s\r\n", strings.ToUpper(alert.State), alert.Severity, alert.ID))
+	sb.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(describeAlert(alert) + "\r\n\r\n")
+	sb.WriteString(fmt.Sprintf("Rule: %s (%s)\r\n", alert.Rule, alert.RuleType))
+	sb.WriteString(fmt.Sprintf("Value: %d (threshold %d)\r\n", alert.Value, alert.Threshold))
+	sb.WriteString(fmt.Sprintf("Started: %s\r\n", alert.StartTime.Format(time.RFC3339)))
+	if alert.State == types.AlertStateResolved {
+		sb.WriteString(fmt.Sprintf("Resolved: %s\r\n", alert.ResolvedTime.Format(time.RFC3339)))
+	}
+
+	return notifier.sendMail([]byte(sb.String()))
+}
+
+// sendMail sends the message as smtp.SendMail does, within the timeout
+func (notifier *SMTPAlertNotifier) sendMail(message []byte) error {
+	host, _, err := net.SplitHostPort(notifier.Config.SMTPAddress)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", notifier.Config.SMTPAddress, notifier.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if notifier.Timeout > 0 {
+		err = conn.SetDeadline(time.Now().Add(notifier.Timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{
+			ServerName: host,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(notifier.Config.SMTPUsername) > 0 {
+		err = client.Auth(smtp.PlainAuth("", notifier.Config.SMTPUsername, notifier.Config.SMTPPassword, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(notifier.Config.From)
+	if err != nil {
+		return err
+	}
+
+	for _, to := range notifier.Config.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// LogAlertNotifier writes alerts to the service log
+type LogAlertNotifier struct{}
+
+// Notify logs the alert
+func (notifier *LogAlertNotifier) Notify(alert *types.Alert) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "LogAlertNotifier.Notify",
+	})
+
+	if alert.State == types.AlertStateResolved {
+		logger.Info(describeAlert(alert))
+	} else {
+		logger.Warn(describeAlert(alert))
+	}
+	return nil
+}
+
+// alertViolation is a subject that breaks a rule at an evaluation
+type alertViolation struct {
+	Subject   string
+	Value     int64
+	Threshold int64
+	Message   string
+}
+
+// instanceHost returns the client host of the instance, the IP is used if the hostname is not given
+func instanceHost(instance *types.ReportInstance) string {
+	if len(instance.ClientHostname) > 0 {
+		return instance.ClientHostname
+	}
+	return instance.ClientHostIP
+}
+
+// evaluateAlertRule returns subjects that break the rule at the given time
+func evaluateAlertRule(rule *AlertRuleConfig, storage Storage, now time.Time) []alertViolation {
+	violations := []alertViolation{}
+	instances := storage.ListInstances()
+
+	switch rule.Type {
+	case types.AlertRuleInstanceInactive:
+		for _, instance := range instances {
+			if instance.GetState() == types.InstanceStateTerminated {
+				continue
+			}
+
+			lastActivity := instance.LastActivityTime
+			if lastActivity.IsZero() {
+				lastActivity = instance.CreationTime
+			}
+
+			idle := now.Sub(lastActivity)
+			if idle >= rule.Duration {
+				violations = append(violations, alertViolation{
+					Subject:   instance.InstanceID,
+					Value:     int64(idle.Seconds()),
+					Threshold: int64(rule.Duration.Seconds()),
+					Message:   fmt.Sprintf("instance %s of %s on %s has been inactive for %s", instance.InstanceID, instance.ClientUser, instanceHost(&instance), idle.Truncate(time.Second)),
+				})
+			}
+		}
+	case types.AlertRuleInstancesPerUser:
+		counts := map[string]int64{}
+		for _, instance := range instances {
+			if instance.GetState() != types.InstanceStateTerminated {
+				counts[instance.ClientUser]++
+			}
+		}
+
+		for user, count := range counts {
+			if count > rule.Threshold {
+				violations = append(violations, alertViolation{
+					Subject:   user,
+					Value:     count,
+					Threshold: rule.Threshold,
+					Message:   fmt.Sprintf("user %s runs %d instances, more than %d", user, count, rule.Threshold),
+				})
+			}
+		}
+	case types.AlertRuleHostTransferSize:
+		windowStart := now.Add(-rule.Window)
+		sizes := map[string]int64{}
+		for _, instance := range instances {
+			host := instanceHost(&instance)
+			for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+				t := transferTime(&transfer)
+				if t.After(windowStart) && !t.After(now) {
+					sizes[host] += transfer.TransferSize
+				}
+			}
+		}
+
+		for host, size := range sizes {
+			if size > rule.Threshold {
+				violations = append(violations, alertViolation{
+					Subject:   host,
+					Value:     size,
+					Threshold: rule.Threshold,
+					Message:   fmt.Sprintf("host %s transferred %d bytes in %s, more than %d", host, size, rule.Window, rule.Threshold),
+				})
+			}
+		}
+	case types.AlertRuleTerminatedInstances:
+		windowStart := now.Add(-rule.Window)
+		var count int64
+		for _, instance := range instances {
+			if instance.Terminated && instance.TerminationTime.After(windowStart) && !instance.TerminationTime.After(now) {
+				count++
+			}
+		}
+
+		if count > rule.Threshold {
+			violations = append(violations, alertViolation{
+				Value:     count,
+				Threshold: rule.Threshold,
+				Message:   fmt.Sprintf("%d instances terminated in %s, more than %d", count, rule.Window, rule.Threshold),
+			})
+		}
+	}
+
+	return violations
+}
+
+// alertID returns the ID of the alert of the rule on the subject
+func alertID(rule string, subject string) string {
+	if len(subject) == 0 {
+		return rule
+	}
+	return rule + "/" + subject
+}
+
+// alertNotification is an alert to deliver to a notifier
+type alertNotification struct {
+	notifierName string
+	alert        types.Alert
+}
+
+// AlertEngine periodically evaluates alert rules against storage and notifies fired and resolved alerts
+type AlertEngine struct {
+	Rules     []AlertRuleConfig
+	Notifiers map[string]AlertNotifier
+
+	storage       Storage
+	interval      time.Duration
+	alerts        map[string]*types.Alert
+	mutex         sync.Mutex
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// NewAlertEngine creates an alert engine
+func NewAlertEngine(config *Config, storage Storage) (*AlertEngine, error) {
+	notifiers := map[string]AlertNotifier{}
+	for _, notifierConfig := range config.AlertNotifiers {
+		notifier, err := NewAlertNotifier(&notifierConfig, config.WebhookTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		notifiers[notifierConfig.Name] = notifier
+	}
+
+	return &AlertEngine{
+		Rules:     config.AlertRules,
+		Notifiers: notifiers,
+
+		storage:       storage,
+		interval:      config.AlertInterval,
+		alerts:        map[string]*types.Alert{},
+		mutex:         sync.Mutex{},
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}, nil
+}
+
+// Init starts the alert engine
+func (engine *AlertEngine) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "AlertEngine.Init",
+	})
+
+	logger.Infof("Starting the alert engine with %d rules and %d notifiers (every %s)", len(engine.Rules), len(engine.Notifiers), engine.interval)
+
+	engine.waitGroup.Add(1)
+	go engine.runLoop()
+
+	return nil
+}
+
+// Destroy stops the alert engine
+func (engine *AlertEngine) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "AlertEngine.Destroy",
+	})
+
+	logger.Info("Stopping the alert engine")
+
+	close(engine.terminateChan)
+	engine.waitGroup.Wait()
+}
+
+func (engine *AlertEngine) runLoop() {
+	defer engine.waitGroup.Done()
+
+	engine.Evaluate(time.Now().UTC())
+
+	ticker := time.NewTicker(engine.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-engine.terminateChan:
+			return
+		case <-ticker.C:
+			engine.Evaluate(time.Now().UTC())
+		}
+	}
+}
+
+// Evaluate evaluates all rules once, and notifies alerts that fired or resolved
+func (engine *AlertEngine) Evaluate(now time.Time) {
+	notifications := []alertNotification{}
+
+	engine.mutex.Lock()
+	for idx := range engine.Rules {
+		rule := &engine.Rules[idx]
+		firing := map[string]bool{}
+		for _, violation := range evaluateAlertRule(rule, engine.storage, now) {
+			id := alertID(rule.Name, violation.Subject)
+			firing[id] = true
+
+			alert, ok := engine.alerts[id]
+			if ok && alert.State == types.AlertStateFiring {
+				alert.Value = violation.Value
+				alert.Message = violation.Message
+				alert.LastFireTime = now
+				continue
+			}
+
+			severity := rule.Severity
+			if len(severity) == 0 {
+				severity = types.AlertSeverityWarning
+			}
+
+			alert = &types.Alert{
+				ID:           id,
+				Rule:         rule.Name,
+				RuleType:     rule.Type,
+				Severity:     severity,
+				Subject:      violation.Subject,
+				State:        types.AlertStateFiring,
+				Message:      violation.Message,
+				Value:        violation.Value,
+				Threshold:    violation.Threshold,
+				StartTime:    now,
+				LastFireTime: now,
+			}
+			engine.alerts[id] = alert
+			notifications = append(notifications, engine.notificationsFor(rule, alert)...)
+		}
+
+		for id, alert := range engine.alerts {
+			if alert.Rule != rule.Name || alert.State != types.AlertStateFiring || firing[id] {
+				continue
+			}
+
+			alert.State = types.AlertStateResolved
+			alert.ResolvedTime = now
+			notifications = append(notifications, engine.notificationsFor(rule, alert)...)
+		}
+	}
+
+	for id, alert := range engine.alerts {
+		if alert.State == types.AlertStateResolved && now.Sub(alert.ResolvedTime) >= alertResolvedRetention {
+			delete(engine.alerts, id)
+		}
+	}
+	engine.mutex.Unlock()
+
+	engine.notify(notifications)
+}
+
+// notificationsFor returns notifications of the alert to the notifiers of the rule
+func (engine *AlertEngine) notificationsFor(rule *AlertRuleConfig, alert *types.Alert) []alertNotification {
+	names := rule.Notifiers
+	if len(names) == 0 {
+		for name := range engine.Notifiers {
+			names = append(names, name)
+		}
+	}
+
+	notifications := []alertNotification{}
+	for _, name := range names {
+		notifications = append(notifications, alertNotification{
+			notifierName: name,
+			alert:        *alert,
+		})
+	}
+
+	return notifications
+}
+
+// notify delivers notifications, failed deliveries are logged and not retried
+func (engine *AlertEngine) notify(notifications []alertNotification) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "AlertEngine.notify",
+	})
+
+	for _, notification := range notifications {
+		notifier, ok := engine.Notifiers[notification.notifierName]
+		if !ok {
+			continue
+		}
+
+		err := notifier.Notify(&notification.alert)
+		if err != nil {
+			logger.WithError(err).Errorf("Could not notify alert %s (%s) to %s", notification.alert.ID, notification.alert.State, notification.notifierName)
+		}
+	}
+}
+
+// ListAlerts returns alerts in the state of the rule, all alerts are returned if empty
+// Firing alerts come first, then the latest started
+func (engine *AlertEngine) ListAlerts(state string, rule string) []types.Alert {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	alerts := []types.Alert{}
+	for _, alert := range engine.alerts {
+		if len(state) > 0 && alert.State != state {
+			continue
+		}
+
+		if len(rule) > 0 && alert.Rule != rule {
+			continue
+		}
+
+		alerts = append(alerts, *alert)
+	}
+
+	sort.Slice(alerts, func(i int, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == types.AlertStateFiring
+		}
+
+		if !alerts[i].StartTime.Equal(alerts[j].StartTime) {
+			return alerts[i].StartTime.After(alerts[j].StartTime)
+		}
+		return alerts[i].ID < alerts[j].ID
+	})
+
+	return alerts
+}
+
+func (svc *MonitorService) listAlerts(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.listAlerts",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", types.AlertStateFiring, types.AlertStateResolved:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("unknown alert state %s", state)))
+		return
+	}
+
+	alerts := []types.Alert{}
+	if svc.Alerts != nil {
+		alerts = svc.Alerts.ListAlerts(state, r.URL.Query().Get("rule"))
+	}
+
+	responseJSON, err := json.Marshal(alerts)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+var alertTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func alertTestAt(minutes int) time.Time {
+	return alertTestTime.Add(time.Duration(minutes) * time.Minute)
+}
+
+// alertTestNotifier records notifications as <notifier>:<state>:<alert id>
+type alertTestNotifier struct {
+	name     string
+	notified *[]string
+}
+
+func (notifier *alertTestNotifier) Notify(alert *types.Alert) error {
+	*notifier.notified = append(*notifier.notified, fmt.Sprintf("%s:%s:%s", notifier.name, alert.State, alert.ID))
+	return nil
+}
+
+func alertTestAddInstance(t *testing.T, storage Storage, instance types.ReportInstance) {
+	err := storage.AddInstance(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func alertTestAddTransfer(t *testing.T, storage Storage, instanceID string, size int64, closed time.Time) {
+	err := storage.AddFileTransfer(types.ReportFileTransfer{
+		InstanceID:    instanceID,
+		FilePath:      "/zone/home/user/file",
+		FileSize:      size,
+		TransferSize:  size,
+		FileOpenMode:  "r",
+		FileOpenTime:  closed.Add(-time.Minute),
+		FileCloseTime: closed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// alertTestStep changes storage, evaluates the rules at the minutes after alertTestTime and checks the result
+type alertTestStep struct {
+	minutes int
+	change  func(t *testing.T, storage Storage)
+	// expected notifications in sorted order
+	notified []string
+	// expected alerts as listed, as <alert id>:<state>:<value>
+	alerts []string
+}
+
+func TestAlertEngineEvaluate(t *testing.T) {
+	instance := func(instanceID string, user string) types.ReportInstance {
+		return types.ReportInstance{
+			InstanceID:       instanceID,
+			ClientUser:       user,
+			ClientHostname:   "h1",
+			CreationTime:     alertTestTime,
+			LastActivityTime: alertTestTime,
+		}
+	}
+
+	terminated := func(instanceID string, minutes int) types.ReportInstance {
+		terminatedInstance := instance(instanceID, "alice")
+		terminatedInstance.Terminated = true
+		terminatedInstance.TerminationTime = alertTestAt(minutes)
+		return terminatedInstance
+	}
+
+	tests := []struct {
+		name      string
+		rules     []AlertRuleConfig
+		instances []types.ReportInstance
+		steps     []alertTestStep
+	}{
+		{
+			name:      "inactive instance fires, resolves and fires again",
+			rules:     []AlertRuleConfig{{Name: "idle", Type: types.AlertRuleInstanceInactive, Duration: 30 * time.Minute, Notifiers: []string{"ops"}}},
+			instances: []types.ReportInstance{instance("a", "alice")},
+			steps: []alertTestStep{
+				{minutes: 29, notified: []string{}, alerts: []string{}},
+				{minutes: 30, notified: []string{"ops:firing:idle/a"}, alerts: []string{"idle/a:firing:1800"}},
+				// still firing, the value is updated without a notification
+				{minutes: 40, notified: []string{}, alerts: []string{"idle/a:firing:2400"}},
+				{
+					minutes: 45,
+					change: func(t *testing.T, storage Storage) {
+						active := instance("a", "alice")
+						active.LastActivityTime = alertTestAt(44)
+						alertTestAddInstance(t, storage, active)
+					},
+					notified: []string{"ops:resolved:idle/a"},
+					alerts:   []string{"idle/a:resolved:2400"},
+				},
+				{minutes: 74, notified: []string{"ops:firing:idle/a"}, alerts: []string{"idle/a:firing:1800"}},
+				{
+					minutes: 80,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddInstance(t, storage, terminated("a", 80))
+					},
+					notified: []string{"ops:resolved:idle/a"},
+					alerts:   []string{"idle/a:resolved:1800"},
+				},
+				// resolved alerts are listed for a day
+				{minutes: 80 + 24*60 - 1, notified: []string{}, alerts: []string{"idle/a:resolved:1800"}},
+				{minutes: 80 + 24*60, notified: []string{}, alerts: []string{}},
+			},
+		},
+		{
+			name:      "instances per user above the threshold",
+			rules:     []AlertRuleConfig{{Name: "many", Type: types.AlertRuleInstancesPerUser, Threshold: 2, Notifiers: []string{"ops"}}},
+			instances: []types.ReportInstance{instance("a", "alice"), instance("b", "alice"), instance("c", "bob")},
+			steps: []alertTestStep{
+				{minutes: 0, notified: []string{}, alerts: []string{}},
+				{
+					minutes: 1,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddInstance(t, storage, instance("d", "alice"))
+					},
+					notified: []string{"ops:firing:many/alice"},
+					alerts:   []string{"many/alice:firing:3"},
+				},
+				{
+					minutes: 2,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddInstance(t, storage, instance("e", "bob"))
+					},
+					notified: []string{},
+					alerts:   []string{"many/alice:firing:3"},
+				},
+				// terminated instances are not counted
+				{
+					minutes: 3,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddInstance(t, storage, terminated("d", 3))
+					},
+					notified: []string{"ops:resolved:many/alice"},
+					alerts:   []string{"many/alice:resolved:3"},
+				},
+			},
+		},
+		{
+			name:      "host transfer size in the window",
+			rules:     []AlertRuleConfig{{Name: "size", Type: types.AlertRuleHostTransferSize, Threshold: 1000, Window: time.Hour, Notifiers: []string{"ops"}}},
+			instances: []types.ReportInstance{instance("a", "alice")},
+			steps: []alertTestStep{
+				{
+					minutes: 10,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddTransfer(t, storage, "a", 600, alertTestAt(10))
+					},
+					notified: []string{},
+					alerts:   []string{},
+				},
+				{
+					minutes: 20,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddTransfer(t, storage, "a", 600, alertTestAt(20))
+					},
+					notified: []string{"ops:firing:size/h1"},
+					alerts:   []string{"size/h1:firing:1200"},
+				},
+				{minutes: 69, notified: []string{}, alerts: []string{"size/h1:firing:1200"}},
+				// the first transfer leaves the window
+				{minutes: 70, notified: []string{"ops:resolved:size/h1"}, alerts: []string{"size/h1:resolved:1200"}},
+			},
+		},
+		{
+			name:      "terminated instances of the fleet",
+			rules:     []AlertRuleConfig{{Name: "churn", Type: types.AlertRuleTerminatedInstances, Threshold: 1, Window: time.Hour, Notifiers: []string{"ops"}}},
+			instances: []types.ReportInstance{terminated("a", 0), instance("b", "alice")},
+			steps: []alertTestStep{
+				{minutes: 0, notified: []string{}, alerts: []string{}},
+				{
+					minutes: 5,
+					change: func(t *testing.T, storage Storage) {
+						alertTestAddInstance(t, storage, terminated("b", 5))
+					},
+					notified: []string{"ops:firing:churn"},
+					alerts:   []string{"churn:firing:2"},
+				},
+				{minutes: 60, notified: []string{"ops:resolved:churn"}, alerts: []string{"churn:resolved:2"}},
+			},
+		},
+		{
+			name: "notifiers of the rule",
+			rules: []AlertRuleConfig{
+				{Name: "idle", Type: types.AlertRuleInstanceInactive, Duration: 30 * time.Minute},
+				{Name: "idle_oncall", Type: types.AlertRuleInstanceInactive, Duration: 30 * time.Minute, Notifiers: []string{"oncall", "unknown"}},
+			},
+			instances: []types.ReportInstance{instance("a", "alice")},
+			steps: []alertTestStep{
+				{
+					minutes:  30,
+					notified: []string{"oncall:firing:idle/a", "oncall:firing:idle_oncall/a", "ops:firing:idle/a"},
+					alerts:   []string{"idle/a:firing:1800", "idle_oncall/a:firing:1800"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			for _, instance := range test.instances {
+				alertTestAddInstance(t, storage, instance)
+			}
+
+			notified := []string{}
+			engine, err := NewAlertEngine(&Config{AlertRules: test.rules}, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			engine.Notifiers["ops"] = &alertTestNotifier{name: "ops", notified: &notified}
+			engine.Notifiers["oncall"] = &alertTestNotifier{name: "oncall", notified: &notified}
+
+			for _, step := range test.steps {
+				if step.change != nil {
+					step.change(t, storage)
+				}
+
+				notified = notified[:0]
+				engine.Evaluate(alertTestAt(step.minutes))
+
+				sort.Strings(notified)
+				if !reflect.DeepEqual(notified, step.notified) {
+					t.Errorf("at %d minutes, expected notifications %v, got %v", step.minutes, step.notified, notified)
+				}
+
+				alerts := []string{}
+				for _, alert := range engine.ListAlerts("", "") {
+					alerts = append(alerts, fmt.Sprintf("%s:%s:%d", alert.ID, alert.State, alert.Value))
+				}
+
+				if !reflect.DeepEqual(alerts, step.alerts) {
+					t.Errorf("at %d minutes, expected alerts %v, got %v", step.minutes, step.alerts, alerts)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertEngineKeepsStartTime(t *testing.T) {
+	storage := NewMemoryStorage()
+	alertTestAddInstance(t, storage, types.ReportInstance{InstanceID: "a", CreationTime: alertTestTime})
+
+	engine, err := NewAlertEngine(&Config{AlertRules: []AlertRuleConfig{{Name: "idle", Type: types.AlertRuleInstanceInactive, Duration: time.Minute}}}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Evaluate(alertTestAt(1))
+	engine.Evaluate(alertTestAt(5))
+
+	alerts := engine.ListAlerts(types.AlertStateFiring, "idle")
+	if len(alerts) != 1 {
+		t.Fatalf("expected one firing alert, got %v", alerts)
+	}
+
+	if !alerts[0].StartTime.Equal(alertTestAt(1)) || !alerts[0].LastFireTime.Equal(alertTestAt(5)) {
+		t.Errorf("expected the alert to start at %s and fire last at %s, got %s and %s", alertTestAt(1), alertTestAt(5), alerts[0].StartTime, alerts[0].LastFireTime)
+	}
+
+	if alerts[0].Severity != types.AlertSeverityWarning {
+		t.Errorf("expected the default severity %s, got %s", types.AlertSeverityWarning, alerts[0].Severity)
+	}
+}
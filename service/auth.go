@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// RoleReporter can report instances and transfers, and terminate its own instances
+	RoleReporter string = "reporter"
+	// RoleReader can read reported data
+	RoleReader string = "reader"
+	// RoleAdmin can do everything, including cleaning up data
+	RoleAdmin string = "admin"
+)
+
+// authContextKey is a key of Principal in request context
+type authContextKey struct{}
+
+// Principal is an authenticated API user
+type Principal struct {
+	Role string
+	// TokenID identifies the token without revealing it
+	TokenID string
+}
+
+// authToken is a configured token and its role
+type authToken struct {
+	Token []byte
+	Role  string
+}
+
+// Authenticator checks bearer tokens of requests
+type Authenticator struct {
+	tokens        []authToken
+	tokenIDSecret []byte
+}
+
+// NewAuthenticator creates an authenticator with tokens in config
+func NewAuthenticator(config *Config) *Authenticator {
+	tokens := []authToken{}
+	add := func(tokenStrings []string, role string) {
+		for _, token := range tokenStrings {
+			if len(token) > 0 {
+				tokens = append(tokens, authToken{
+					Token: []byte(token),
+					Role:  role,
+				})
+			}
+		}
+	}
+
+	add(config.AdminTokens, RoleAdmin)
+	add(config.ReaderTokens, RoleReader)
+	add(config.ReporterTokens, RoleReporter)
+
+	return &Authenticator{
+		tokens:        tokens,
+		tokenIDSecret: []byte(config.TokenIDSecret),
+	}
+}
+
+// Enabled checks if any token is configured, all requests are allowed if not
+func (auth *Authenticator) Enabled() bool {
+	return len(auth.tokens) > 0
+}
+
+// TokenID returns an ID of the token that is safe to store
+// It is an HMAC keyed with the server's secret, so tokens cannot be guessed and checked against stored IDs
+func (auth *Authenticator) TokenID(token string) string {
+	mac := hmac.New(sha256.New, auth.tokenIDSecret)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Authenticate returns the principal of the request's bearer token
+func (auth *Authenticator) Authenticate(r *http.Request) (*Principal, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, false
+	}
+
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+
+	var found *authToken
+	for idx := range auth.tokens {
+		// compare all tokens in constant time
+		if subtle.ConstantTimeCompare(auth.tokens[idx].Token, token) == 1 && found == nil {
+			found = &auth.tokens[idx]
+		}
+	}
+
+	if found == nil {
+		return nil, false
+	}
+
+	return &Principal{
+		Role:    found.Role,
+		TokenID: auth.TokenID(string(token)),
+	}, true
+}
+
+// hasRole checks if the principal is allowed to do what the role can do
+func (principal *Principal) hasRole(role string) bool {
+	return principal.Role == RoleAdmin || principal.Role == role
+}
+
+// showReporterIDs checks if reporter IDs of instances may be returned for the request, only admins see them
+// Reporter IDs are kept for ownership checks, other roles do not need them
+func showReporterIDs(r *http.Request) bool {
+	principal := getPrincipal(r)
+	return principal == nil || principal.Role == RoleAdmin
+}
+
+// getPrincipal returns the principal of the request, nil if authentication is disabled
+func getPrincipal(r *http.Request) *Principal {
+	if principal, ok := r.Context().Value(authContextKey{}).(*Principal); ok {
+		return principal
+	}
+	return nil
+}
+
+// requireRole wraps the handler so that only principals with the role can access it
+func (svc *MonitorService) requireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithFields(log.Fields{
+			"package":  "service",
+			"function": "MonitorService.requireRole",
+		})
+
+		if !svc.Authenticator.Enabled() {
+			handler(w, r)
+			return
+		}
+
+		principal, ok := svc.Authenticator.Authenticate(r)
+		if !ok {
+			logger.Warnf("Unauthenticated request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("valid bearer token is required"))
+			return
+		}
+
+		if !principal.hasRole(role) {
+			logger.Warnf("Forbidden request (%s) from %s to %s with %s role", r.Method, r.RemoteAddr, r.RequestURI, principal.Role)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(role + " role is required"))
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, principal)))
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/gorilla/mux"
+)
+
+func TestAuthenticatorTokenID(t *testing.T) {
+	auth := NewAuthenticator(&Config{ReporterTokens: []string{"r1"}, TokenIDSecret: "secret"})
+	other := NewAuthenticator(&Config{ReporterTokens: []string{"r1"}, TokenIDSecret: "other"})
+
+	if auth.TokenID("r1") != auth.TokenID("r1") {
+		t.Error("expected the same ID for the same token")
+	}
+
+	if auth.TokenID("r1") == auth.TokenID("r2") {
+		t.Error("expected different IDs for different tokens")
+	}
+
+	if auth.TokenID("r1") == other.TokenID("r1") {
+		t.Error("expected different IDs with different secrets")
+	}
+}
+
+func TestReporterIDsShownToAdmins(t *testing.T) {
+	config := &Config{
+		AdminTokens:    []string{"a1"},
+		ReaderTokens:   []string{"rd1"},
+		ReporterTokens: []string{"r1"},
+		TokenIDSecret:  "secret",
+	}
+
+	svc := &MonitorService{
+		Config:        config,
+		Storage:       NewMemoryStorage(),
+		Authenticator: NewAuthenticator(config),
+	}
+
+	reporterID := svc.Authenticator.TokenID("r1")
+	err := svc.Storage.AddInstance(types.ReportInstance{InstanceID: "a", ReporterID: reporterID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc.Router = mux.NewRouter()
+	svc.Router.HandleFunc("/instances", svc.requireRole(RoleReader, svc.listInstances)).Methods("GET")
+	svc.Router.HandleFunc("/instances/{instance_id}", svc.requireRole(RoleReader, svc.getInstance)).Methods("GET")
+	svc.Router.HandleFunc("/export", svc.requireRole(RoleReader, svc.export)).Methods("GET")
+
+	tests := []struct {
+		token    string
+		expected string
+	}{
+		{"a1", reporterID},
+		{"rd1", ""},
+	}
+
+	for _, test := range tests {
+		for _, path := range []string{"/instances", "/instances/a", "/export?table=instances&format=ndjson"} {
+			request := httptest.NewRequest("GET", path, nil)
+			request.Header.Set("Authorization", "Bearer "+test.token)
+
+			recorder := httptest.NewRecorder()
+			svc.Router.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("expected status %d for %s, got %d - %s", http.StatusOK, path, recorder.Code, recorder.Body.String())
+			}
+
+			var instance types.ReportInstance
+			body := recorder.Body.Bytes()
+			if path == "/instances" {
+				var instances []types.ReportInstance
+				err = json.Unmarshal(body, &instances)
+				if err == nil && len(instances) == 1 {
+					instance = instances[0]
+				}
+			} else {
+				err = json.Unmarshal(body, &instance)
+			}
+
+			if err != nil {
+				t.Fatalf("failed to parse %s - %v", path, err)
+			}
+
+			if instance.ReporterID != test.expected {
+				t.Errorf("expected reporter ID %q in %s with token %s, got %q", test.expected, path, test.token, instance.ReporterID)
+			}
+		}
+	}
+}
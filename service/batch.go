@@ -0,0 +1,67 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+// splitJSONRecords splits a JSON array or NDJSON (one JSON object per line) into records
+func splitJSONRecords(body []byte) ([]json.RawMessage, error) {
+	records := []json.RawMessage{}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &records)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			records = append(records, json.RawMessage(line))
+		}
+	}
+
+	return records, nil
+}
+
+// parseTransferBatch parses a JSON array or NDJSON (one JSON object per line) of file transfers
+// A record that cannot be parsed gets an error at its index instead of failing the whole batch
+func parseTransferBatch(body []byte) ([]types.ReportFileTransfer, []error, error) {
+	records, err := splitJSONRecords(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read a JSON array of transfers - %v", err)
+	}
+
+	transfers := make([]types.ReportFileTransfer, len(records))
+	errs := make([]error, len(records))
+	for i, record := range records {
+		err := json.Unmarshal(record, &transfers[i])
+		if err != nil {
+			errs[i] = err
+		}
+	}
+
+	return transfers, errs, nil
+}
+
+// transferInstanceIDs returns distinct instance IDs of the transfers in order of appearance
+func transferInstanceIDs(transfers []types.ReportFileTransfer) []string {
+	instanceIDs := []string{}
+	seen := map[string]bool{}
+	for _, transfer := range transfers {
+		if !seen[transfer.InstanceID] {
+			seen[transfer.InstanceID] = true
+			instanceIDs = append(instanceIDs, transfer.InstanceID)
+		}
+	}
+
+	return instanceIDs
+}
@@ -2,25 +2,169 @@ package service
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/cyverse/irodsfs-monitor/types"
 	"github.com/kelseyhightower/envconfig"
 	yaml "gopkg.in/yaml.v2"
 )
 
 const (
 	ServicePortDefault int = 11010
+
+	StorageTypeMemory  string = "memory"
+	StorageTypeBolt    string = "bolt"
+	StorageTypeDefault string = StorageTypeMemory
+	StoragePathDefault string = "/var/lib/irodsfs-monitor/irodsfs-monitor.db"
+
+	JournalSnapshotEntriesDefault int = 10000
+
+	LargeTransferSizeDefault int64 = 1024 * 1024 * 1024 // 1GB
+
+	TransferBatchSizeMaxDefault int = 10000
+
+	RetentionInstanceTTLDefault             time.Duration = 7 * 24 * time.Hour
+	RetentionTransferTTLDefault             time.Duration = 7 * 24 * time.Hour
+	RetentionMaxTransfersPerInstanceDefault int           = 100000
+	RetentionIntervalDefault                time.Duration = 1 * time.Hour
+	RetentionHourlyRollupTTLDefault         time.Duration = 31 * 24 * time.Hour
+	RetentionDailyRollupTTLDefault          time.Duration = 400 * 24 * time.Hour
+
+	InstanceStaleTimeoutDefault time.Duration = 10 * time.Minute
+	InstanceLostTimeoutDefault  time.Duration = 1 * time.Hour
+
+	WebhookFormatJSON  string = "json"
+	WebhookFormatSlack string = "slack"
+
+	WebhookQueuePathDefault  string        = "/var/lib/irodsfs-monitor/webhooks"
+	WebhookTimeoutDefault    time.Duration = 10 * time.Second
+	WebhookMaxRetriesDefault int           = 10
+
+	AlertNotifierWebhook string = "webhook"
+	AlertNotifierSMTP    string = "smtp"
+	AlertNotifierLog     string = "log"
+
+	AlertIntervalDefault time.Duration = 1 * time.Minute
 )
 
+// WebhookConfig holds the parameters of a webhook endpoint
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Secret is a key for HMAC-SHA256 signatures of deliveries, deliveries are not signed if empty
+	Secret string `yaml:"secret,omitempty"`
+	// Format is WebhookFormatJSON (default) or WebhookFormatSlack
+	Format string `yaml:"format,omitempty"`
+	// Events are event types to deliver, at least one must be given
+	Events []string `yaml:"events"`
+
+	// filters on the instance that the event is about, ignored if empty
+	InstanceID string `yaml:"instance_id,omitempty"`
+	Zone       string `yaml:"zone,omitempty"`
+	ClientUser string `yaml:"client_user,omitempty"`
+}
+
 // Config holds the parameters list which can be configured
 type Config struct {
 	ServicePort int `envconfig:"SERVICE_PORT" yaml:"service_port"`
 
 	LogPath string `envconfig:"LOG_PATH" yaml:"log_path,omitempty"`
 
+	StorageType string `envconfig:"STORAGE_TYPE" yaml:"storage_type,omitempty"`
+	StoragePath string `envconfig:"STORAGE_PATH" yaml:"storage_path,omitempty"`
+
+	// JournalPath is a directory for the write-ahead journal, journaling is disabled if empty
+	JournalPath            string `envconfig:"JOURNAL_PATH" yaml:"journal_path,omitempty"`
+	JournalSnapshotEntries int    `envconfig:"JOURNAL_SNAPSHOT_ENTRIES" yaml:"journal_snapshot_entries,omitempty"`
+
+	// Retention removes terminated or lost instances and old transfers, non-terminated instances are kept
+	// zero TTLs or max keep data forever, and retention is disabled if the interval is zero
+	RetentionInstanceTTL             time.Duration `envconfig:"RETENTION_INSTANCE_TTL" yaml:"retention_instance_ttl,omitempty"`
+	RetentionTransferTTL             time.Duration `envconfig:"RETENTION_TRANSFER_TTL" yaml:"retention_transfer_ttl,omitempty"`
+	RetentionMaxTransfersPerInstance int           `envconfig:"RETENTION_MAX_TRANSFERS_PER_INSTANCE" yaml:"retention_max_transfers_per_instance,omitempty"`
+	RetentionInterval                time.Duration `envconfig:"RETENTION_INTERVAL" yaml:"retention_interval,omitempty"`
+	// transfers removed are summarized in hourly and daily rollups, which are kept for their own TTLs
+	RetentionHourlyRollupTTL time.Duration `envconfig:"RETENTION_HOURLY_ROLLUP_TTL" yaml:"retention_hourly_rollup_ttl,omitempty"`
+	RetentionDailyRollupTTL  time.Duration `envconfig:"RETENTION_DAILY_ROLLUP_TTL" yaml:"retention_daily_rollup_ttl,omitempty"`
+
+	// TransferBatchSizeMax is the max number of file transfers accepted in a batch
+	TransferBatchSizeMax int `envconfig:"TRANSFER_BATCH_SIZE_MAX" yaml:"transfer_batch_size_max,omitempty"`
+
+	// LargeTransferSize is a transfer size in bytes from which large transfer events are raised
+	LargeTransferSize int64 `envconfig:"LARGE_TRANSFER_SIZE" yaml:"large_transfer_size,omitempty"`
+
+	// instances without heartbeats or transfers become stale and then lost, detection is disabled if zero
+	InstanceStaleTimeout time.Duration `envconfig:"INSTANCE_STALE_TIMEOUT" yaml:"instance_stale_timeout,omitempty"`
+	InstanceLostTimeout  time.Duration `envconfig:"INSTANCE_LOST_TIMEOUT" yaml:"instance_lost_timeout,omitempty"`
+
+	// TLS is enabled if certificate and key are given, client certificates are required if client CA is given
+	TLSCertPath     string `envconfig:"TLS_CERT_PATH" yaml:"tls_cert_path,omitempty"`
+	TLSKeyPath      string `envconfig:"TLS_KEY_PATH" yaml:"tls_key_path,omitempty"`
+	TLSClientCAPath string `envconfig:"TLS_CLIENT_CA_PATH" yaml:"tls_client_ca_path,omitempty"`
+
+	// Tokens for bearer token authentication, authentication is disabled if no tokens are given
+	AdminTokens    []string `envconfig:"ADMIN_TOKENS" yaml:"admin_tokens,omitempty"`
+	ReaderTokens   []string `envconfig:"READER_TOKENS" yaml:"reader_tokens,omitempty"`
+	ReporterTokens []string `envconfig:"REPORTER_TOKENS" yaml:"reporter_tokens,omitempty"`
+	// TokenIDSecret is a key of token IDs that instances are claimed by, it must be given with reporter tokens
+	TokenIDSecret string `envconfig:"TOKEN_ID_SECRET" yaml:"token_id_secret,omitempty"`
+
+	// Webhooks can only be configured in YAML
+	Webhooks          []WebhookConfig `ignored:"true" yaml:"webhooks,omitempty"`
+	WebhookQueuePath  string          `envconfig:"WEBHOOK_QUEUE_PATH" yaml:"webhook_queue_path,omitempty"`
+	WebhookTimeout    time.Duration   `envconfig:"WEBHOOK_TIMEOUT" yaml:"webhook_timeout,omitempty"`
+	WebhookMaxRetries int             `envconfig:"WEBHOOK_MAX_RETRIES" yaml:"webhook_max_retries,omitempty"`
+
+	// Alert rules and notifiers can only be configured in YAML
+	AlertRules     []AlertRuleConfig     `ignored:"true" yaml:"alert_rules,omitempty"`
+	AlertNotifiers []AlertNotifierConfig `ignored:"true" yaml:"alert_notifiers,omitempty"`
+	AlertInterval  time.Duration         `envconfig:"ALERT_INTERVAL" yaml:"alert_interval,omitempty"`
+
+	// ConfigBaseline is the expected configuration of instances, keyed by configuration fields such as read_ahead_max
+	ConfigBaseline map[string]string `envconfig:"CONFIG_BASELINE" yaml:"config_baseline,omitempty"`
+
 	Foreground   bool `yaml:"foreground,omitempty"`
 	ChildProcess bool `yaml:"childprocess,omitempty"`
 }
 
+// AlertRuleConfig holds the parameters of an alert rule
+type AlertRuleConfig struct {
+	Name string `yaml:"name"`
+	// Type is one of types.AlertRule*
+	Type string `yaml:"type"`
+	// Severity is one of types.AlertSeverity*, warning if empty
+	Severity string `yaml:"severity,omitempty"`
+
+	// Threshold is the count or the size in bytes that a subject must exceed to fire
+	Threshold int64 `yaml:"threshold,omitempty"`
+	// Duration is how long an instance must be inactive to fire
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Window is the time range counted back from the evaluation
+	Window time.Duration `yaml:"window,omitempty"`
+
+	// Notifiers are names of notifiers to deliver alerts to, all notifiers are used if empty
+	Notifiers []string `yaml:"notifiers,omitempty"`
+}
+
+// AlertNotifierConfig holds the parameters of an alert notifier
+type AlertNotifierConfig struct {
+	Name string `yaml:"name"`
+	// Type is AlertNotifierWebhook, AlertNotifierSMTP or AlertNotifierLog
+	Type string `yaml:"type"`
+
+	// webhook parameters, deliveries are signed with the secret as webhooks for events are
+	URL    string `yaml:"url,omitempty"`
+	Secret string `yaml:"secret,omitempty"`
+	Format string `yaml:"format,omitempty"`
+
+	// SMTP parameters, authentication is used if the username is given
+	SMTPAddress  string   `yaml:"smtp_address,omitempty"`
+	SMTPUsername string   `yaml:"smtp_username,omitempty"`
+	SMTPPassword string   `yaml:"smtp_password,omitempty"`
+	From         string   `yaml:"from,omitempty"`
+	To           []string `yaml:"to,omitempty"`
+}
+
 // NewDefaultConfig creates DefaultConfig
 func NewDefaultConfig() *Config {
 	return &Config{
@@ -28,6 +172,46 @@ func NewDefaultConfig() *Config {
 
 		LogPath: "",
 
+		StorageType: StorageTypeDefault,
+		StoragePath: StoragePathDefault,
+
+		JournalPath:            "",
+		JournalSnapshotEntries: JournalSnapshotEntriesDefault,
+
+		RetentionInstanceTTL:             RetentionInstanceTTLDefault,
+		RetentionTransferTTL:             RetentionTransferTTLDefault,
+		RetentionMaxTransfersPerInstance: RetentionMaxTransfersPerInstanceDefault,
+		RetentionInterval:                RetentionIntervalDefault,
+		RetentionHourlyRollupTTL:         RetentionHourlyRollupTTLDefault,
+		RetentionDailyRollupTTL:          RetentionDailyRollupTTLDefault,
+
+		TransferBatchSizeMax: TransferBatchSizeMaxDefault,
+
+		LargeTransferSize: LargeTransferSizeDefault,
+
+		InstanceStaleTimeout: InstanceStaleTimeoutDefault,
+		InstanceLostTimeout:  InstanceLostTimeoutDefault,
+
+		TLSCertPath:     "",
+		TLSKeyPath:      "",
+		TLSClientCAPath: "",
+
+		AdminTokens:    []string{},
+		ReaderTokens:   []string{},
+		ReporterTokens: []string{},
+		TokenIDSecret:  "",
+
+		Webhooks:          []WebhookConfig{},
+		WebhookQueuePath:  WebhookQueuePathDefault,
+		WebhookTimeout:    WebhookTimeoutDefault,
+		WebhookMaxRetries: WebhookMaxRetriesDefault,
+
+		AlertRules:     []AlertRuleConfig{},
+		AlertNotifiers: []AlertNotifierConfig{},
+		AlertInterval:  AlertIntervalDefault,
+
+		ConfigBaseline: map[string]string{},
+
 		Foreground:   false,
 		ChildProcess: false,
 	}
@@ -35,30 +219,31 @@ func NewDefaultConfig() *Config {
 
 // NewConfigFromENV creates Config from Environmental Variables
 func NewConfigFromENV() (*Config, error) {
-	config := Config{
-		ServicePort: ServicePortDefault,
-	}
+	config := NewDefaultConfig()
 
-	err := envconfig.Process("", &config)
+	err := envconfig.Process("", config)
 	if err != nil {
 		return nil, fmt.Errorf("Env Read Error - %v", err)
 	}
 
-	return &config, nil
+	return config, nil
 }
 
 // NewConfigFromYAML creates Config from YAML
 func NewConfigFromYAML(yamlBytes []byte) (*Config, error) {
-	config := Config{
-		ServicePort: ServicePortDefault,
-	}
+	config := NewDefaultConfig()
 
-	err := yaml.Unmarshal(yamlBytes, &config)
+	err := yaml.Unmarshal(yamlBytes, config)
 	if err != nil {
 		return nil, fmt.Errorf("YAML Unmarshal Error - %v", err)
 	}
 
-	return &config, nil
+	return config, nil
+}
+
+// TLSEnabled checks if TLS is configured
+func (config *Config) TLSEnabled() bool {
+	return len(config.TLSCertPath) > 0 && len(config.TLSKeyPath) > 0
 }
 
 // Validate validates configuration
@@ -67,5 +252,172 @@ func (config *Config) Validate() error {
 		return fmt.Errorf("Service port must be given")
 	}
 
+	switch config.StorageType {
+	case StorageTypeMemory:
+	case StorageTypeBolt:
+		if len(config.StoragePath) == 0 {
+			return fmt.Errorf("Storage path must be given for %s storage", config.StorageType)
+		}
+	default:
+		return fmt.Errorf("Unknown storage type %s", config.StorageType)
+	}
+
+	if len(config.JournalPath) > 0 && config.StorageType != StorageTypeMemory {
+		return fmt.Errorf("Journal is only supported with %s storage", StorageTypeMemory)
+	}
+
+	if (len(config.TLSCertPath) > 0) != (len(config.TLSKeyPath) > 0) {
+		return fmt.Errorf("Both TLS certificate and key must be given")
+	}
+
+	if len(config.TLSClientCAPath) > 0 && len(config.TLSCertPath) == 0 {
+		return fmt.Errorf("TLS certificate and key must be given to verify client certificates")
+	}
+
+	if len(config.ReporterTokens) > 0 && len(config.TokenIDSecret) == 0 {
+		return fmt.Errorf("Token ID secret must be given with reporter tokens")
+	}
+
+	if config.RetentionInstanceTTL < 0 || config.RetentionTransferTTL < 0 || config.RetentionMaxTransfersPerInstance < 0 || config.RetentionInterval < 0 || config.RetentionHourlyRollupTTL < 0 || config.RetentionDailyRollupTTL < 0 {
+		return fmt.Errorf("Retention parameters must not be negative")
+	}
+
+	if config.TransferBatchSizeMax <= 0 {
+		return fmt.Errorf("Transfer batch size max must be positive")
+	}
+
+	if config.InstanceStaleTimeout < 0 || config.InstanceLostTimeout < 0 {
+		return fmt.Errorf("Instance timeouts must not be negative")
+	}
+
+	if config.InstanceStaleTimeout > 0 && config.InstanceLostTimeout > 0 && config.InstanceLostTimeout <= config.InstanceStaleTimeout {
+		return fmt.Errorf("Instance lost timeout must be longer than stale timeout")
+	}
+
+	for _, webhook := range config.Webhooks {
+		if len(webhook.URL) == 0 {
+			return fmt.Errorf("Webhook URL must be given")
+		}
+
+		switch webhook.Format {
+		case "", WebhookFormatJSON, WebhookFormatSlack:
+		default:
+			return fmt.Errorf("Unknown webhook format %s", webhook.Format)
+		}
+
+		// file_transfer_added is raised for every file close, so no event type is delivered unless asked
+		if len(webhook.Events) == 0 {
+			return fmt.Errorf("Events of webhook %s must be given", webhookName(&webhook))
+		}
+
+		for _, eventType := range webhook.Events {
+			found := false
+			for _, knownType := range types.EventTypes {
+				if eventType == knownType {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("Unknown event type %s of webhook %s", eventType, webhookName(&webhook))
+			}
+		}
+	}
+
+	if len(config.Webhooks) > 0 && len(config.WebhookQueuePath) == 0 {
+		return fmt.Errorf("Webhook queue path must be given")
+	}
+
+	if err := config.validateAlerts(); err != nil {
+		return err
+	}
+
+	if err := ValidateConfigBaseline(config.ConfigBaseline); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateAlerts validates alert rules and notifiers
+func (config *Config) validateAlerts() error {
+	if len(config.AlertRules) > 0 && config.AlertInterval <= 0 {
+		return fmt.Errorf("Alert interval must be positive")
+	}
+
+	notifiers := map[string]bool{}
+	for _, notifier := range config.AlertNotifiers {
+		if len(notifier.Name) == 0 {
+			return fmt.Errorf("Alert notifier name must be given")
+		}
+
+		if notifiers[notifier.Name] {
+			return fmt.Errorf("Duplicate alert notifier %s", notifier.Name)
+		}
+		notifiers[notifier.Name] = true
+
+		switch notifier.Type {
+		case AlertNotifierWebhook:
+			if len(notifier.URL) == 0 {
+				return fmt.Errorf("URL of alert notifier %s must be given", notifier.Name)
+			}
+
+			switch notifier.Format {
+			case "", WebhookFormatJSON, WebhookFormatSlack:
+			default:
+				return fmt.Errorf("Unknown webhook format %s of alert notifier %s", notifier.Format, notifier.Name)
+			}
+		case AlertNotifierSMTP:
+			if len(notifier.SMTPAddress) == 0 || len(notifier.From) == 0 || len(notifier.To) == 0 {
+				return fmt.Errorf("SMTP address, from and to of alert notifier %s must be given", notifier.Name)
+			}
+		case AlertNotifierLog:
+		default:
+			return fmt.Errorf("Unknown type %s of alert notifier %s", notifier.Type, notifier.Name)
+		}
+	}
+
+	rules := map[string]bool{}
+	for _, rule := range config.AlertRules {
+		if len(rule.Name) == 0 {
+			return fmt.Errorf("Alert rule name must be given")
+		}
+
+		if rules[rule.Name] {
+			return fmt.Errorf("Duplicate alert rule %s", rule.Name)
+		}
+		rules[rule.Name] = true
+
+		switch rule.Type {
+		case types.AlertRuleInstanceInactive:
+			if rule.Duration <= 0 {
+				return fmt.Errorf("Duration of alert rule %s must be positive", rule.Name)
+			}
+		case types.AlertRuleInstancesPerUser:
+			if rule.Threshold <= 0 {
+				return fmt.Errorf("Threshold of alert rule %s must be positive", rule.Name)
+			}
+		case types.AlertRuleHostTransferSize, types.AlertRuleTerminatedInstances:
+			if rule.Threshold <= 0 || rule.Window <= 0 {
+				return fmt.Errorf("Threshold and window of alert rule %s must be positive", rule.Name)
+			}
+		default:
+			return fmt.Errorf("Unknown type %s of alert rule %s", rule.Type, rule.Name)
+		}
+
+		switch rule.Severity {
+		case "", types.AlertSeverityInfo, types.AlertSeverityWarning, types.AlertSeverityCritical:
+		default:
+			return fmt.Errorf("Unknown severity %s of alert rule %s", rule.Severity, rule.Name)
+		}
+
+		for _, notifier := range rule.Notifiers {
+			if !notifiers[notifier] {
+				return fmt.Errorf("Unknown alert notifier %s of alert rule %s", notifier, rule.Name)
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// baselineQueryPrefix is a prefix of query parameters that declare baseline fields, such as baseline.read_ahead_max
+const baselineQueryPrefix string = "baseline."
+
+// instanceConfig returns configuration fields of the instance
+func instanceConfig(instance *types.ReportInstance) map[string]string {
+	return map[string]string{
+		types.ConfigFieldOperationTimeout:         instance.OperationTimeout,
+		types.ConfigFieldConnectionIdleTimeout:    instance.ConnectionIdleTimeout,
+		types.ConfigFieldConnectionMax:            strconv.Itoa(instance.ConnectionMax),
+		types.ConfigFieldMetadataCacheTimeout:     instance.MetadataCacheTimeout,
+		types.ConfigFieldMetadataCacheCleanupTime: instance.MetadataCacheCleanupTime,
+		types.ConfigFieldBufferSizeMax:            strconv.FormatInt(instance.BufferSizeMax, 10),
+		types.ConfigFieldReadAheadMax:             strconv.Itoa(instance.ReadAheadMax),
+		types.ConfigFieldAuthScheme:               instance.AuthScheme,
+		types.ConfigFieldPoolAddress:              instance.PoolAddress,
+	}
+}
+
+// normalizeConfigValue formats durations the same way, so 5m and 5m0s are the same value
+func normalizeConfigValue(value string) string {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.String()
+	}
+	return value
+}
+
+// configFingerprint makes a short hash of all configuration fields
+func configFingerprint(config map[string]string) string {
+	hash := sha256.New()
+	for _, field := range types.ConfigFields {
+		fmt.Fprintf(hash, "%s=%s\n", field, normalizeConfigValue(config[field]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// ValidateConfigBaseline checks if all fields of the baseline are configuration fields
+func ValidateConfigBaseline(baseline map[string]string) error {
+	for field := range baseline {
+		known := false
+		for _, configField := range types.ConfigFields {
+			if field == configField {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown configuration field %s in baseline", field)
+		}
+	}
+
+	return nil
+}
+
+// configDrift returns fields of the config that differ from the baseline, in the order of types.ConfigFields
+func configDrift(config map[string]string, baseline map[string]string) []types.ConfigFieldDrift {
+	drift := []types.ConfigFieldDrift{}
+	for _, field := range types.ConfigFields {
+		baselineValue, ok := baseline[field]
+		if !ok {
+			continue
+		}
+
+		if normalizeConfigValue(baselineValue) != normalizeConfigValue(config[field]) {
+			drift = append(drift, types.ConfigFieldDrift{
+				Field:    field,
+				Baseline: baselineValue,
+				Actual:   config[field],
+			})
+		}
+	}
+
+	return drift
+}
+
+// ComputeConfigDrift groups instances by their configurations and compares them with the baseline
+// The configuration of the largest group is the baseline if no baseline is declared
+func ComputeConfigDrift(instances []types.ReportInstance, baseline map[string]string) *types.ConfigDriftReport {
+	report := &types.ConfigDriftReport{
+		Baseline:       map[string]string{},
+		BaselineSource: types.BaselineSourceDeclared,
+		InstanceCount:  len(instances),
+		Groups:         []types.ConfigGroup{},
+		Outliers:       []types.ConfigOutlier{},
+	}
+
+	groups := map[string]*types.ConfigGroup{}
+	hosts := map[string]map[string]bool{}
+	for idx := range instances {
+		instance := &instances[idx]
+		config := instanceConfig(instance)
+		fingerprint := configFingerprint(config)
+
+		group, ok := groups[fingerprint]
+		if !ok {
+			group = &types.ConfigGroup{
+				Fingerprint: fingerprint,
+				Config:      config,
+				InstanceIDs: []string{},
+				Hosts:       []string{},
+			}
+			groups[fingerprint] = group
+			hosts[fingerprint] = map[string]bool{}
+		}
+
+		group.InstanceCount++
+		group.InstanceIDs = append(group.InstanceIDs, instance.InstanceID)
+
+		host := instance.ClientHostname
+		if len(host) == 0 {
+			host = instance.ClientHostIP
+		}
+
+		if len(host) > 0 && !hosts[fingerprint][host] {
+			hosts[fingerprint][host] = true
+			group.Hosts = append(group.Hosts, host)
+		}
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.InstanceIDs)
+		sort.Strings(group.Hosts)
+		report.Groups = append(report.Groups, *group)
+	}
+
+	sort.Slice(report.Groups, func(i int, j int) bool {
+		if report.Groups[i].InstanceCount != report.Groups[j].InstanceCount {
+			return report.Groups[i].InstanceCount > report.Groups[j].InstanceCount
+		}
+		return report.Groups[i].Fingerprint < report.Groups[j].Fingerprint
+	})
+
+	if len(baseline) > 0 {
+		for field, value := range baseline {
+			report.Baseline[field] = value
+		}
+	} else {
+		report.BaselineSource = types.BaselineSourceMajority
+		if len(report.Groups) > 0 {
+			for field, value := range report.Groups[0].Config {
+				report.Baseline[field] = value
+			}
+		}
+	}
+
+	driftFields := map[string][]string{}
+	for i := range report.Groups {
+		group := &report.Groups[i]
+		group.Drift = configDrift(group.Config, report.Baseline)
+		if len(group.Drift) == 0 {
+			report.CompliantCount += group.InstanceCount
+			continue
+		}
+
+		fields := []string{}
+		for _, drift := range group.Drift {
+			fields = append(fields, drift.Field)
+		}
+		driftFields[group.Fingerprint] = fields
+	}
+
+	for idx := range instances {
+		instance := &instances[idx]
+		fingerprint := configFingerprint(instanceConfig(instance))
+		fields, ok := driftFields[fingerprint]
+		if !ok {
+			continue
+		}
+
+		report.Outliers = append(report.Outliers, types.ConfigOutlier{
+			InstanceID:     instance.InstanceID,
+			ClientHostname: instance.ClientHostname,
+			ClientHostIP:   instance.ClientHostIP,
+			Fingerprint:    fingerprint,
+			Fields:         fields,
+		})
+	}
+
+	sort.Slice(report.Outliers, func(i int, j int) bool {
+		if report.Outliers[i].ClientHostname != report.Outliers[j].ClientHostname {
+			return report.Outliers[i].ClientHostname < report.Outliers[j].ClientHostname
+		}
+		return report.Outliers[i].InstanceID < report.Outliers[j].InstanceID
+	})
+
+	return report
+}
+
+func (svc *MonitorService) getConfigDrift(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getConfigDrift",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	values := r.URL.Query()
+	query, err := types.NewInstanceQueryFromValues(values)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// only active instances run with their configurations, unless asked otherwise
+	if query.State == "all" {
+		query.State = ""
+	} else if len(query.State) == 0 && query.Terminated == nil {
+		query.State = types.InstanceStateActive
+	}
+
+	baseline := map[string]string{}
+	for field, value := range svc.Config.ConfigBaseline {
+		baseline[field] = value
+	}
+
+	for key := range values {
+		if strings.HasPrefix(key, baselineQueryPrefix) {
+			baseline[strings.TrimPrefix(key, baselineQueryPrefix)] = values.Get(key)
+		}
+	}
+
+	err = ValidateConfigBaseline(baseline)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	instances := []types.ReportInstance{}
+	for _, instance := range svc.Storage.ListInstances() {
+		if matchInstance(query, &instance) {
+			instances = append(instances, instance)
+		}
+	}
+
+	report := ComputeConfigDrift(instances, baseline)
+
+	responseJSON, err := json.Marshal(report)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
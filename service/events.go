@@ -0,0 +1,303 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EventSubscriberBufferSize int           = 256
+	EventStreamKeepAlive      time.Duration = 15 * time.Second
+)
+
+// EventFilter is a struct used to select events for a subscriber
+type EventFilter struct {
+	Types      []string
+	InstanceID string
+	Zone       string
+	ClientUser string
+}
+
+// NewEventFilterFromRequest creates EventFilter from URL query values
+func NewEventFilterFromRequest(r *http.Request) *EventFilter {
+	values := r.URL.Query()
+	filter := &EventFilter{
+		Types:      []string{},
+		InstanceID: values.Get("instance_id"),
+		Zone:       values.Get("zone"),
+		ClientUser: values.Get("client_user"),
+	}
+
+	for _, eventTypes := range values["type"] {
+		for _, eventType := range strings.Split(eventTypes, ",") {
+			if len(eventType) > 0 {
+				filter.Types = append(filter.Types, eventType)
+			}
+		}
+	}
+
+	return filter
+}
+
+// Match checks if the event satisfies the filter
+func (filter *EventFilter) Match(event *types.Event) bool {
+	if len(filter.Types) > 0 {
+		found := false
+		for _, eventType := range filter.Types {
+			if eventType == event.Type {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if event.Instance == nil {
+		return len(filter.InstanceID) == 0 && len(filter.Zone) == 0 && len(filter.ClientUser) == 0
+	}
+
+	if len(filter.InstanceID) > 0 && filter.InstanceID != event.Instance.InstanceID {
+		return false
+	}
+
+	if len(filter.Zone) > 0 && filter.Zone != event.Instance.Zone {
+		return false
+	}
+
+	if len(filter.ClientUser) > 0 && filter.ClientUser != event.Instance.ClientUser {
+		return false
+	}
+
+	return true
+}
+
+// EventSubscriber receives events that match its filter
+type EventSubscriber struct {
+	Filter  *EventFilter
+	Events  chan types.Event
+	Dropped uint64
+}
+
+// EventHandler is called for every published event before publishing returns, so it never misses events
+type EventHandler struct {
+	Handle func(event *types.Event)
+}
+
+// EventBroker delivers accepted events to subscribers and handlers
+type EventBroker struct {
+	subscribers map[*EventSubscriber]bool
+	handlers    map[*EventHandler]bool
+	lastID      uint64
+	mutex       sync.Mutex
+}
+
+// NewEventBroker creates an event broker
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: map[*EventSubscriber]bool{},
+		handlers:    map[*EventHandler]bool{},
+		lastID:      0,
+		mutex:       sync.Mutex{},
+	}
+}
+
+// Subscribe registers a subscriber with the filter
+func (broker *EventBroker) Subscribe(filter *EventFilter) *EventSubscriber {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	subscriber := &EventSubscriber{
+		Filter:  filter,
+		Events:  make(chan types.Event, EventSubscriberBufferSize),
+		Dropped: 0,
+	}
+
+	broker.subscribers[subscriber] = true
+	return subscriber
+}
+
+// Unsubscribe unregisters the subscriber and closes its channel
+func (broker *EventBroker) Unsubscribe(subscriber *EventSubscriber) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	if _, ok := broker.subscribers[subscriber]; ok {
+		delete(broker.subscribers, subscriber)
+		close(subscriber.Events)
+	}
+}
+
+// AddHandler registers a handler
+func (broker *EventBroker) AddHandler(handler *EventHandler) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.handlers[handler] = true
+}
+
+// RemoveHandler unregisters the handler
+func (broker *EventBroker) RemoveHandler(handler *EventHandler) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	delete(broker.handlers, handler)
+}
+
+// Publish calls handlers with the event and sends it to subscribers that match
+// A slow subscriber never blocks publishing, events are dropped when its buffer is full
+func (broker *EventBroker) Publish(event types.Event) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "EventBroker.Publish",
+	})
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.lastID++
+	event.ID = broker.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	for handler := range broker.handlers {
+		handler.Handle(&event)
+	}
+
+	for subscriber := range broker.subscribers {
+		if !subscriber.Filter.Match(&event) {
+			continue
+		}
+
+		select {
+		case subscriber.Events <- event:
+		default:
+			subscriber.Dropped++
+			logger.Warnf("Dropped event %d for a slow subscriber", event.ID)
+		}
+	}
+}
+
+// Close unregisters all subscribers
+func (broker *EventBroker) Close() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for subscriber := range broker.subscribers {
+		close(subscriber.Events)
+	}
+
+	broker.subscribers = map[*EventSubscriber]bool{}
+	broker.handlers = map[*EventHandler]bool{}
+}
+
+// publishInstanceEvent publishes an event about the instance stored
+// Events go to readers and webhooks, so reporter IDs are not included
+func (svc *MonitorService) publishInstanceEvent(eventType string, instanceID string) {
+	instance, ok := svc.Storage.GetInstance(instanceID)
+	if !ok {
+		return
+	}
+	instance.ReporterID = ""
+
+	svc.Events.Publish(types.Event{
+		Type:     eventType,
+		Instance: &instance,
+	})
+}
+
+// publishTransferEvent publishes an event about the transfer stored
+func (svc *MonitorService) publishTransferEvent(transfer *types.ReportFileTransfer) {
+	event := types.Event{
+		Type:     types.EventTypeFileTransferAdded,
+		Transfer: transfer,
+	}
+
+	if instance, ok := svc.Storage.GetInstance(transfer.InstanceID); ok {
+		instance.ReporterID = ""
+		event.Instance = &instance
+	}
+
+	svc.Events.Publish(event)
+
+	if svc.Config.LargeTransferSize > 0 && transfer.TransferSize >= svc.Config.LargeTransferSize {
+		event.Type = types.EventTypeLargeTransfer
+		svc.Events.Publish(event)
+	}
+
+	if len(transfer.Error) > 0 {
+		event.Type = types.EventTypeTransferError
+		svc.Events.Publish(event)
+	}
+}
+
+func (svc *MonitorService) streamEvents(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.streamEvents",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+
+	subscriber := svc.Events.Subscribe(NewEventFilterFromRequest(r))
+	defer svc.Events.Unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAliveTicker := time.NewTicker(EventStreamKeepAlive)
+	defer keepAliveTicker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Infof("Event stream to %s closed", r.RemoteAddr)
+			return
+		case <-keepAliveTicker.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				logger.Error(err)
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				// broker closed
+				return
+			}
+
+			eventJSON, err := json.Marshal(event)
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, eventJSON)
+			if err != nil {
+				logger.Error(err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
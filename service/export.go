@@ -0,0 +1,419 @@
+package service
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+type exportColumnType int
+
+const (
+	exportColumnString exportColumnType = iota
+	exportColumnInt64
+	exportColumnBool
+	// exportColumnTime is written in RFC3339 with nanoseconds, or as a Parquet timestamp in nanoseconds
+	// zero times are written as empty values or nulls
+	exportColumnTime
+)
+
+type exportColumn struct {
+	Name string
+	Type exportColumnType
+}
+
+var exportInstanceColumns = []exportColumn{
+	{"instance_id", exportColumnString},
+	{"reporter_id", exportColumnString},
+	{"state", exportColumnString},
+	{"host", exportColumnString},
+	{"port", exportColumnInt64},
+	{"zone", exportColumnString},
+	{"client_user", exportColumnString},
+	{"proxy_user", exportColumnString},
+	{"auth_scheme", exportColumnString},
+	{"read_ahead_max", exportColumnInt64},
+	{"operation_timeout", exportColumnString},
+	{"connection_idle_timeout", exportColumnString},
+	{"connection_max", exportColumnInt64},
+	{"metadata_cache_timeout", exportColumnString},
+	{"metadata_cache_cleanup_time", exportColumnString},
+	{"buffer_size_max", exportColumnInt64},
+	{"pool_address", exportColumnString},
+	{"client_hostname", exportColumnString},
+	{"client_host_ip", exportColumnString},
+	{"creation_time", exportColumnTime},
+	{"last_activity_time", exportColumnTime},
+	{"termination_time", exportColumnTime},
+}
+
+var exportTransferColumns = []exportColumn{
+	{"transfer_id", exportColumnString},
+	{"instance_id", exportColumnString},
+	{"file_path", exportColumnString},
+	{"file_size", exportColumnInt64},
+	{"file_open_mode", exportColumnString},
+	{"transfer_size", exportColumnInt64},
+	{"largest_block_size", exportColumnInt64},
+	{"smallest_block_size", exportColumnInt64},
+	{"transfer_block_count", exportColumnInt64},
+	{"sequential_access", exportColumnBool},
+	{"file_open_time", exportColumnTime},
+	{"file_close_time", exportColumnTime},
+	{"error", exportColumnString},
+}
+
+var exportBlockColumns = []exportColumn{
+	{"transfer_id", exportColumnString},
+	{"instance_id", exportColumnString},
+	{"block_index", exportColumnInt64},
+	{"offset", exportColumnInt64},
+	{"length", exportColumnInt64},
+	{"access_time", exportColumnTime},
+}
+
+func instanceExportRow(instance *types.ReportInstance) []interface{} {
+	return []interface{}{
+		instance.InstanceID,
+		instance.ReporterID,
+		instance.GetState(),
+		instance.Host,
+		int64(instance.Port),
+		instance.Zone,
+		instance.ClientUser,
+		instance.ProxyUser,
+		instance.AuthScheme,
+		int64(instance.ReadAheadMax),
+		instance.OperationTimeout,
+		instance.ConnectionIdleTimeout,
+		int64(instance.ConnectionMax),
+		instance.MetadataCacheTimeout,
+		instance.MetadataCacheCleanupTime,
+		instance.BufferSizeMax,
+		instance.PoolAddress,
+		instance.ClientHostname,
+		instance.ClientHostIP,
+		instance.CreationTime,
+		instance.LastActivityTime,
+		instance.TerminationTime,
+	}
+}
+
+func transferExportRow(id string, transfer *types.ReportFileTransfer) []interface{} {
+	return []interface{}{
+		id,
+		transfer.InstanceID,
+		transfer.FilePath,
+		transfer.FileSize,
+		transfer.FileOpenMode,
+		transfer.TransferSize,
+		transfer.LargestBlockSize,
+		transfer.SmallestBlockSize,
+		transfer.TransferBlockCount,
+		transfer.SequentialAccess,
+		transfer.FileOpenTime,
+		transfer.FileCloseTime,
+		transfer.Error,
+	}
+}
+
+func blockExportRow(id string, instanceID string, index int, block *types.FileBlock) []interface{} {
+	return []interface{}{
+		id,
+		instanceID,
+		int64(index),
+		block.Offset,
+		block.Length,
+		block.AccessTime,
+	}
+}
+
+// exportWriter writes rows of a table in a file format
+type exportWriter interface {
+	WriteRow(row []interface{}) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer, columns []exportColumn) (exportWriter, error) {
+	switch format {
+	case types.ExportFormatCSV:
+		return newCSVExportWriter(w, columns)
+	case types.ExportFormatNDJSON:
+		return newNDJSONExportWriter(w, columns), nil
+	case types.ExportFormatParquet:
+		return newParquetWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// csvExportWriter writes rows in CSV with a header line
+type csvExportWriter struct {
+	writer  *csv.Writer
+	columns []exportColumn
+	record  []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []exportColumn) (*csvExportWriter, error) {
+	writer := &csvExportWriter{
+		writer:  csv.NewWriter(w),
+		columns: columns,
+		record:  make([]string, len(columns)),
+	}
+
+	for i, column := range columns {
+		writer.record[i] = column.Name
+	}
+
+	err := writer.writer.Write(writer.record)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// WriteRow writes a row
+func (writer *csvExportWriter) WriteRow(row []interface{}) error {
+	for i, column := range writer.columns {
+		switch column.Type {
+		case exportColumnString:
+			writer.record[i] = row[i].(string)
+		case exportColumnInt64:
+			writer.record[i] = strconv.FormatInt(row[i].(int64), 10)
+		case exportColumnBool:
+			writer.record[i] = strconv.FormatBool(row[i].(bool))
+		case exportColumnTime:
+			writer.record[i] = formatExportTime(row[i].(time.Time))
+		}
+	}
+
+	return writer.writer.Write(writer.record)
+}
+
+// Close flushes buffered rows
+func (writer *csvExportWriter) Close() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+// ndjsonExportWriter writes rows as JSON objects, one per line, with keys in column order
+type ndjsonExportWriter struct {
+	writer  *bufio.Writer
+	columns []exportColumn
+	keys    [][]byte
+}
+
+func newNDJSONExportWriter(w io.Writer, columns []exportColumn) *ndjsonExportWriter {
+	writer := &ndjsonExportWriter{
+		writer:  bufio.NewWriter(w),
+		columns: columns,
+		keys:    make([][]byte, len(columns)),
+	}
+
+	for i, column := range columns {
+		key, _ := json.Marshal(column.Name)
+		writer.keys[i] = append(key, ':')
+	}
+
+	return writer
+}
+
+// WriteRow writes a row
+func (writer *ndjsonExportWriter) WriteRow(row []interface{}) error {
+	line := []byte{'{'}
+	for i, column := range writer.columns {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(line, writer.keys[i]...)
+
+		switch column.Type {
+		case exportColumnString:
+			value, err := json.Marshal(row[i].(string))
+			if err != nil {
+				return err
+			}
+			line = append(line, value...)
+		case exportColumnInt64:
+			line = strconv.AppendInt(line, row[i].(int64), 10)
+		case exportColumnBool:
+			line = strconv.AppendBool(line, row[i].(bool))
+		case exportColumnTime:
+			t := row[i].(time.Time)
+			if t.IsZero() {
+				line = append(line, "null"...)
+			} else {
+				line = strconv.AppendQuote(line, formatExportTime(t))
+			}
+		}
+	}
+	line = append(line, '}', '\n')
+
+	_, err := writer.writer.Write(line)
+	return err
+}
+
+// Close flushes buffered rows
+func (writer *ndjsonExportWriter) Close() error {
+	return writer.writer.Flush()
+}
+
+// exportInstanceInRange checks if the instance was alive at some point in the time range
+func exportInstanceInRange(query *types.ExportQuery, instance *types.ReportInstance) bool {
+	if !query.EndTime.IsZero() && !instance.CreationTime.Before(query.EndTime) {
+		return false
+	}
+
+	if !query.StartTime.IsZero() && instance.Terminated && !instance.TerminationTime.IsZero() && instance.TerminationTime.Before(query.StartTime) {
+		return false
+	}
+
+	return true
+}
+
+// exportTransferInRange checks if the transfer is accounted in the time range
+func exportTransferInRange(query *types.ExportQuery, transfer *types.ReportFileTransfer) bool {
+	t := transferTime(transfer)
+	if !query.StartTime.IsZero() && t.Before(query.StartTime) {
+		return false
+	}
+
+	if !query.EndTime.IsZero() && !t.Before(query.EndTime) {
+		return false
+	}
+
+	return true
+}
+
+// ExportTable writes the table of instances, transfers or transfer blocks in the time range
+// Transfers are identified by transfer IDs, that transfer blocks refer to
+// Reporter IDs of instances are left empty unless withReporterIDs is set
+func ExportTable(query *types.ExportQuery, storage Storage, w io.Writer, withReporterIDs bool) error {
+	var columns []exportColumn
+	switch query.Table {
+	case types.ExportTableInstances:
+		columns = exportInstanceColumns
+	case types.ExportTableTransfers:
+		columns = exportTransferColumns
+	case types.ExportTableBlocks:
+		columns = exportBlockColumns
+	default:
+		return fmt.Errorf("unknown table %s", query.Table)
+	}
+
+	writer, err := newExportWriter(query.Format, w, columns)
+	if err != nil {
+		return err
+	}
+
+	instances := storage.ListInstances()
+	if !withReporterIDs {
+		for idx := range instances {
+			instances[idx].ReporterID = ""
+		}
+	}
+
+	sort.Slice(instances, func(i int, j int) bool {
+		if !instances[i].CreationTime.Equal(instances[j].CreationTime) {
+			return instances[i].CreationTime.Before(instances[j].CreationTime)
+		}
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+
+	for idx := range instances {
+		instance := &instances[idx]
+
+		if query.Table == types.ExportTableInstances {
+			if !exportInstanceInRange(query, instance) {
+				continue
+			}
+
+			err = writer.WriteRow(instanceExportRow(instance))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+			if !exportTransferInRange(query, &transfer) {
+				continue
+			}
+
+			id := transferID(instance.InstanceID, transfer.Sequence)
+			if query.Table == types.ExportTableTransfers {
+				err = writer.WriteRow(transferExportRow(id, &transfer))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			for blockIdx := range transfer.TransferBlocks {
+				err = writer.WriteRow(blockExportRow(id, instance.InstanceID, blockIdx, &transfer.TransferBlocks[blockIdx]))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return writer.Close()
+}
+
+func exportContentType(format string) string {
+	switch format {
+	case types.ExportFormatCSV:
+		return "text/csv; charset=UTF-8"
+	case types.ExportFormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+func (svc *MonitorService) export(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.export",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	query, err := types.NewExportQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentType(query.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", query.Table, query.Format))
+	w.WriteHeader(http.StatusOK)
+
+	// the response is streamed, errors after this point can only be logged
+	// reporter IDs are exported for admins, so imports keep the owners of instances
+	err = ExportTable(query, svc.Storage, w, showReporterIDs(r))
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// importRecord is an instance or a file transfer in an import, at its index in the dump
+type importRecord struct {
+	Index    int
+	Instance *types.ReportInstance
+	Transfer *types.ReportFileTransfer
+}
+
+// importBlock is a row of transfer blocks in an export, that refers to a transfer in the same dump by transfer ID
+type importBlock struct {
+	TransferID string `json:"transfer_id"`
+	BlockIndex int64  `json:"block_index"`
+	types.FileBlock
+}
+
+// hasImportKeys checks if the record has all the keys
+func hasImportKeys(keys map[string]json.RawMessage, names ...string) bool {
+	for _, name := range names {
+		if _, ok := keys[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseImportRecords parses a JSON array or NDJSON of instances, file transfers and transfer blocks
+// Records with a file path are file transfers, records with a transfer ID and a block index are transfer blocks,
+// and records with an instance ID, a host and a creation time are instances, so outputs of the list APIs and NDJSON exports can be imported
+// Transfer blocks are joined to file transfers of the same transfer ID in the dump, other records are rejected
+func parseImportRecords(body []byte) ([]importRecord, []types.ReportImportError, error) {
+	rawRecords, err := splitJSONRecords(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read a JSON array of records - %v", err)
+	}
+
+	records := []importRecord{}
+	errs := []types.ReportImportError{}
+	dumpTransfers := map[string]*types.ReportFileTransfer{}
+	blockIndices := map[string][]int{}
+	blocks := map[int]*importBlock{}
+	for i, rawRecord := range rawRecords {
+		keys := map[string]json.RawMessage{}
+		err := json.Unmarshal(rawRecord, &keys)
+		if err != nil {
+			errs = append(errs, types.ReportImportError{Index: i, Error: err.Error()})
+			continue
+		}
+
+		switch {
+		case hasImportKeys(keys, "file_path"):
+			transfer := types.ReportFileTransfer{}
+			err = json.Unmarshal(rawRecord, &transfer)
+			if err == nil && len(transfer.InstanceID) == 0 {
+				err = fmt.Errorf("instance_id is not given")
+			}
+
+			dumpTransferID := ""
+			if err == nil && hasImportKeys(keys, "transfer_id") {
+				err = json.Unmarshal(keys["transfer_id"], &dumpTransferID)
+			}
+
+			if err != nil {
+				errs = append(errs, types.ReportImportError{Index: i, Error: err.Error()})
+				continue
+			}
+
+			if len(dumpTransferID) > 0 {
+				dumpTransfers[dumpTransferID] = &transfer
+			}
+
+			records = append(records, importRecord{Index: i, Transfer: &transfer})
+		case hasImportKeys(keys, "transfer_id", "block_index"):
+			block := importBlock{}
+			err = json.Unmarshal(rawRecord, &block)
+			if err != nil {
+				errs = append(errs, types.ReportImportError{Index: i, Error: err.Error()})
+				continue
+			}
+
+			blocks[i] = &block
+			blockIndices[block.TransferID] = append(blockIndices[block.TransferID], i)
+		case hasImportKeys(keys, "instance_id", "host", "creation_time"):
+			instance := types.ReportInstance{}
+			err = json.Unmarshal(rawRecord, &instance)
+			if err == nil && len(instance.InstanceID) == 0 {
+				err = fmt.Errorf("instance_id is not given")
+			}
+
+			if err != nil {
+				errs = append(errs, types.ReportImportError{Index: i, Error: err.Error()})
+				continue
+			}
+
+			// exports carry the state only
+			if instance.State == types.InstanceStateTerminated {
+				instance.Terminated = true
+			}
+
+			records = append(records, importRecord{Index: i, Instance: &instance})
+		default:
+			errs = append(errs, types.ReportImportError{Index: i, Error: "unknown record, instances need instance_id, host and creation_time, transfers need file_path, and transfer blocks need transfer_id and block_index"})
+		}
+	}
+
+	for dumpTransferID, indices := range blockIndices {
+		transfer, ok := dumpTransfers[dumpTransferID]
+		if ok && len(transfer.TransferBlocks) > 0 {
+			for _, i := range indices {
+				errs = append(errs, types.ReportImportError{Index: i, Error: fmt.Sprintf("transfer %s already has transfer blocks", dumpTransferID)})
+			}
+			continue
+		}
+
+		if !ok {
+			for _, i := range indices {
+				errs = append(errs, types.ReportImportError{Index: i, Error: fmt.Sprintf("unable to find a transfer for ID %s in the dump", dumpTransferID)})
+			}
+			continue
+		}
+
+		sort.SliceStable(indices, func(a int, b int) bool {
+			return blocks[indices[a]].BlockIndex < blocks[indices[b]].BlockIndex
+		})
+
+		for _, i := range indices {
+			transfer.TransferBlocks = append(transfer.TransferBlocks, blocks[i].FileBlock)
+		}
+	}
+
+	return records, errs, nil
+}
+
+// importTransferKey identifies a file transfer of an instance for deduplication
+func importTransferKey(transfer *types.ReportFileTransfer) string {
+	return strings.Join([]string{
+		transfer.InstanceID,
+		transfer.FilePath,
+		sortableTime(transfer.FileOpenTime),
+		sortableTime(transfer.FileCloseTime),
+		sortableInt64(transfer.TransferSize),
+	}, "\x00")
+}
+
+// planImport decides which records are imported
+// With deduplication, instances that already exist in storage or appear earlier in the dump are skipped,
+// and so are file transfers of the same instance, file path, open and close times and transfer size
+func planImport(records []importRecord, storage Storage, dedup bool, result *types.ReportImportResult) ([]types.ReportInstance, []types.ReportFileTransfer, []int) {
+	instances := []types.ReportInstance{}
+	seenInstances := map[string]bool{}
+	for _, record := range records {
+		if record.Instance == nil {
+			continue
+		}
+
+		instanceID := record.Instance.InstanceID
+		if dedup {
+			if _, ok := storage.GetInstance(instanceID); ok || seenInstances[instanceID] {
+				result.InstancesSkipped++
+				continue
+			}
+		}
+
+		seenInstances[instanceID] = true
+		instances = append(instances, *record.Instance)
+	}
+
+	transfers := []types.ReportFileTransfer{}
+	transferIndices := []int{}
+	seenTransfers := map[string]bool{}
+	loadedInstances := map[string]bool{}
+	for _, record := range records {
+		if record.Transfer == nil {
+			continue
+		}
+
+		if dedup {
+			instanceID := record.Transfer.InstanceID
+			if !loadedInstances[instanceID] {
+				loadedInstances[instanceID] = true
+				for _, existingTransfer := range storage.ListFileTransfersForInstance(instanceID) {
+					seenTransfers[importTransferKey(&existingTransfer)] = true
+				}
+			}
+
+			key := importTransferKey(record.Transfer)
+			if seenTransfers[key] {
+				result.TransfersSkipped++
+				continue
+			}
+			seenTransfers[key] = true
+		}
+
+		transfers = append(transfers, *record.Transfer)
+		transferIndices = append(transferIndices, record.Index)
+	}
+
+	return instances, transfers, transferIndices
+}
+
+// importToStorage adds imported instances and file transfers as they are, keeping their timestamps and client IPs
+// It returns an error for each transfer, nil if added
+func importToStorage(storage Storage, instances []types.ReportInstance, transfers []types.ReportFileTransfer) ([]error, error) {
+	for _, instance := range instances {
+		err := storage.AddInstance(instance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return storage.AddFileTransfers(transfers), nil
+}
+
+func (svc *MonitorService) importData(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.importData",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	dedup := true
+	if dedupValue := r.URL.Query().Get("dedup"); len(dedupValue) > 0 {
+		var err error
+		dedup, err = strconv.ParseBool(dedupValue)
+		if err != nil {
+			logger.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("dedup is not a boolean"))
+			return
+		}
+	}
+
+	requestBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	records, parseErrs, err := parseImportRecords(requestBytes)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	result := types.ReportImportResult{
+		Errors: parseErrs,
+	}
+
+	instances, transfers, transferIndices := planImport(records, svc.Storage, dedup, &result)
+
+	if len(instances) > 0 || len(transfers) > 0 {
+		entry := &JournalEntry{
+			Operation: JournalOperationImport,
+			Instances: instances,
+			Transfers: transfers,
+		}
+
+		var addErrs []error
+		err = svc.commit(entry, func() error {
+			var err error
+			addErrs, err = importToStorage(svc.Storage, instances, transfers)
+			return err
+		})
+		if err != nil {
+			logger.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		for i, addErr := range addErrs {
+			if addErr != nil {
+				result.Errors = append(result.Errors, types.ReportImportError{Index: transferIndices[i], Error: addErr.Error()})
+				continue
+			}
+
+			result.TransfersImported++
+		}
+	}
+
+	sort.Slice(result.Errors, func(i int, j int) bool {
+		return result.Errors[i].Index < result.Errors[j].Index
+	})
+
+	result.InstancesImported = len(instances)
+	result.Rejected = len(result.Errors)
+
+	logger.Infof("Imported %d instances and %d transfers, skipped %d instances and %d transfers, rejected %d records", result.InstancesImported, result.TransfersImported, result.InstancesSkipped, result.TransfersSkipped, result.Rejected)
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultJSON)
+}
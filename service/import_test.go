@@ -0,0 +1,146 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImportRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// expected records as instance:<id> or transfer:<instance id>:<path>:<block offsets>
+		expected []string
+		// expected errors as <index>:<part of message>
+		expectedErrs []string
+	}{
+		{
+			name: "instances and transfers",
+			body: `{"instance_id":"a","host":"data.cyverse.org","creation_time":"2026-01-01T00:00:00Z","state":"active"}
+{"instance_id":"a","file_path":"/zone/home/user/a.txt","file_size":10}`,
+			expected: []string{"instance:a", "transfer:a:/zone/home/user/a.txt:[]"},
+		},
+		{
+			name:     "JSON array",
+			body:     `[{"instance_id":"a","host":"h","creation_time":"2026-01-01T00:00:00Z"}, {"instance_id":"a","file_path":"/p"}]`,
+			expected: []string{"instance:a", "transfer:a:/p:[]"},
+		},
+		{
+			name:     "terminated instance",
+			body:     `{"instance_id":"a","host":"h","creation_time":"2026-01-01T00:00:00Z","state":"terminated"}`,
+			expected: []string{"instance:a(terminated)"},
+		},
+		{
+			name: "blocks are joined in block order",
+			body: `{"transfer_id":"a/1","instance_id":"a","file_path":"/p"}
+{"transfer_id":"a/1","block_index":1,"offset":100,"length":100}
+{"transfer_id":"a/1","block_index":0,"offset":0,"length":100}
+{"transfer_id":"a/2","instance_id":"a","file_path":"/q"}`,
+			expected: []string{"transfer:a:/p:[0 100]", "transfer:a:/q:[]"},
+		},
+		{
+			name: "blocks before the transfer",
+			body: `{"transfer_id":"a/1","block_index":0,"offset":5,"length":1}
+{"transfer_id":"a/1","instance_id":"a","file_path":"/p"}`,
+			expected: []string{"transfer:a:/p:[5]"},
+		},
+		{
+			name:         "blocks without the transfer",
+			body:         `{"transfer_id":"a/1","block_index":0,"offset":0,"length":100}`,
+			expected:     []string{},
+			expectedErrs: []string{"0:unable to find a transfer for ID a/1"},
+		},
+		{
+			name: "blocks of a transfer that has blocks",
+			body: `{"transfer_id":"a/1","instance_id":"a","file_path":"/p","transfer_blocks":[{"offset":0,"length":1}]}
+{"transfer_id":"a/1","block_index":0,"offset":0,"length":100}`,
+			expected:     []string{"transfer:a:/p:[0]"},
+			expectedErrs: []string{"1:transfer a/1 already has transfer blocks"},
+		},
+		{
+			name: "instance without host or creation time",
+			body: `{"instance_id":"a","creation_time":"2026-01-01T00:00:00Z"}
+{"instance_id":"a","host":"h"}
+{"instance_id":"a"}`,
+			expected:     []string{},
+			expectedErrs: []string{"0:unknown record", "1:unknown record", "2:unknown record"},
+		},
+		{
+			name: "rollups and other shapes",
+			body: `{"resolution":"hour","bucket_start":"2026-01-01T00:00:00Z","file_count":1}
+{"group":"user","file_count":1}
+{}`,
+			expected:     []string{},
+			expectedErrs: []string{"0:unknown record", "1:unknown record", "2:unknown record"},
+		},
+		{
+			name: "invalid records",
+			body: `{"instance_id":"","host":"h","creation_time":"2026-01-01T00:00:00Z"}
+{"file_path":"/p"}
+{"instance_id":"a","file_path":"/p","file_size":"big"}
+[1, 2]
+{"instance_id":"a","host":"h","creation_time":"2026-01-01T00:00:00Z"}`,
+			expected:     []string{"instance:a"},
+			expectedErrs: []string{"0:instance_id is not given", "1:instance_id is not given", "2:cannot unmarshal", "3:cannot unmarshal"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, errs, err := parseImportRecords([]byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			described := []string{}
+			for _, record := range records {
+				if record.Instance != nil {
+					description := "instance:" + record.Instance.InstanceID
+					if record.Instance.Terminated {
+						description += "(terminated)"
+					}
+					described = append(described, description)
+					continue
+				}
+
+				offsets := []int64{}
+				for _, block := range record.Transfer.TransferBlocks {
+					offsets = append(offsets, block.Offset)
+				}
+				described = append(described, fmt.Sprintf("transfer:%s:%s:%v", record.Transfer.InstanceID, record.Transfer.FilePath, offsets))
+			}
+
+			if !reflect.DeepEqual(described, test.expected) {
+				t.Errorf("expected records %v, got %v", test.expected, described)
+			}
+
+			if len(errs) != len(test.expectedErrs) {
+				t.Fatalf("expected errors %v, got %v", test.expectedErrs, errs)
+			}
+
+			for _, expectedErr := range test.expectedErrs {
+				parts := strings.SplitN(expectedErr, ":", 2)
+
+				found := false
+				for _, importErr := range errs {
+					if fmt.Sprint(importErr.Index) == parts[0] && strings.Contains(importErr.Error, parts[1]) {
+						found = true
+					}
+				}
+
+				if !found {
+					t.Errorf("expected an error %s, got %v", expectedErr, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestParseImportRecordsInvalidArray(t *testing.T) {
+	_, _, err := parseImportRecords([]byte(`[{"instance_id":"a"`))
+	if err == nil {
+		t.Error("expected an error for a broken JSON array")
+	}
+}
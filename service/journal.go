@@ -0,0 +1,585 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	JournalLogFilename         string = "journal.log"
+	JournalPreviousLogFilename string = "journal.previous.log"
+	JournalSnapshotFilename    string = "snapshot.json"
+
+	JournalOperationAddInstance       string = "add_instance"
+	JournalOperationTerminateInstance string = "terminate_instance"
+	JournalOperationHeartbeat         string = "heartbeat"
+	JournalOperationSetInstanceState  string = "set_instance_state"
+	JournalOperationAddFileTransfer   string = "add_file_transfer"
+	JournalOperationAddFileTransfers  string = "add_file_transfers"
+	JournalOperationCleanUp           string = "cleanup"
+	JournalOperationClearOld          string = "clear_old"
+	JournalOperationApplyRetention    string = "apply_retention"
+	JournalOperationImport            string = "import"
+)
+
+// JournalEntry is an operation recorded in the journal
+type JournalEntry struct {
+	Sequence   uint64                     `json:"sequence"`
+	Operation  string                     `json:"operation"`
+	Time       time.Time                  `json:"time"`
+	InstanceID string                     `json:"instance_id,omitempty"`
+	Instance   *types.ReportInstance      `json:"instance,omitempty"`
+	Instances  []types.ReportInstance     `json:"instances,omitempty"`
+	Transfer   *types.ReportFileTransfer  `json:"transfer,omitempty"`
+	Transfers  []types.ReportFileTransfer `json:"transfers,omitempty"`
+	Days       int                        `json:"days,omitempty"`
+	State      string                     `json:"state,omitempty"`
+	Retention  *RetentionPolicy           `json:"retention,omitempty"`
+	TakeOver   bool                       `json:"take_over,omitempty"`
+	// LastActivityTime is the last activity time that a state change was decided on
+	LastActivityTime *time.Time `json:"last_activity_time,omitempty"`
+}
+
+// JournalSnapshot is a full copy of storage data at a point of the journal
+type JournalSnapshot struct {
+	Sequence      uint64                     `json:"sequence"`
+	Time          time.Time                  `json:"time"`
+	Instances     []types.ReportInstance     `json:"instances"`
+	FileTransfers []types.ReportFileTransfer `json:"file_transfers"`
+	Rollups       []types.TransferRollup     `json:"rollups,omitempty"`
+	// TransferSequences are sequence numbers of the last transfers added to instances, so removed transfers are not renumbered
+	TransferSequences map[string]int64 `json:"transfer_sequences,omitempty"`
+}
+
+// Journal is an append-only write-ahead log of storage operations
+// A snapshot rotates the log to a previous segment, which is removed once the snapshot is written
+type Journal struct {
+	DirPath          string
+	SnapshotEntries  int
+	Storage          Storage
+	logFile          *os.File
+	sequence         uint64
+	entriesSinceSnap int
+	snapshotting     bool
+	mutex            sync.Mutex
+	// snapshotMutex is held while a snapshot is taken, it is locked before mutex
+	snapshotMutex sync.Mutex
+}
+
+// NewJournal creates a journal
+func NewJournal(dirPath string, snapshotEntries int, storage Storage) *Journal {
+	return &Journal{
+		DirPath:          dirPath,
+		SnapshotEntries:  snapshotEntries,
+		Storage:          storage,
+		logFile:          nil,
+		sequence:         0,
+		entriesSinceSnap: 0,
+		snapshotting:     false,
+		mutex:            sync.Mutex{},
+		snapshotMutex:    sync.Mutex{},
+	}
+}
+
+func (journal *Journal) logPath() string {
+	return filepath.Join(journal.DirPath, JournalLogFilename)
+}
+
+func (journal *Journal) previousLogPath() string {
+	return filepath.Join(journal.DirPath, JournalPreviousLogFilename)
+}
+
+func (journal *Journal) snapshotPath() string {
+	return filepath.Join(journal.DirPath, JournalSnapshotFilename)
+}
+
+// Init replays the journal to rebuild storage and opens the log for appending
+func (journal *Journal) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.Init",
+	})
+
+	logger.Infof("Initializing the journal at %s", journal.DirPath)
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	err := os.MkdirAll(journal.DirPath, 0700)
+	if err != nil {
+		logger.WithError(err).Errorf("Could not create a journal directory %s", journal.DirPath)
+		return err
+	}
+
+	err = journal.replay()
+	if err != nil {
+		logger.WithError(err).Error("Could not replay the journal")
+		return err
+	}
+
+	logFile, err := os.OpenFile(journal.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logger.WithError(err).Errorf("Could not open a journal log %s", journal.logPath())
+		return err
+	}
+
+	journal.logFile = logFile
+
+	// compact what was replayed so the next start begins from a snapshot, nothing is written yet
+	err = journal.saveSnapshot(journal.copySnapshot())
+	if err != nil {
+		logger.WithError(err).Error("Could not take a journal snapshot")
+		return err
+	}
+
+	return journal.logFile.Truncate(0)
+}
+
+// Destroy takes a final snapshot and closes the journal
+func (journal *Journal) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.Destroy",
+	})
+
+	logger.Info("Destroying the journal")
+
+	journal.mutex.Lock()
+	initialized := journal.logFile != nil
+	journal.mutex.Unlock()
+
+	if !initialized {
+		return
+	}
+
+	// waits for a snapshot being taken
+	err := journal.takeSnapshot()
+	if err != nil {
+		logger.Error(err)
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	err = journal.logFile.Close()
+	if err != nil {
+		logger.Error(err)
+	}
+
+	journal.logFile = nil
+}
+
+// Write records the entry durably and then applies it to storage
+// A snapshot due after the entry is written by the caller, while other writes go on
+func (journal *Journal) Write(entry *JournalEntry, apply func() error) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.Write",
+	})
+
+	snapshotDue, err := journal.append(entry, apply)
+	if err != nil {
+		return err
+	}
+
+	if snapshotDue {
+		// the entry is applied already, a failed snapshot is taken again later
+		err = journal.takeSnapshot()
+		if err != nil {
+			logger.WithError(err).Error("Could not take a journal snapshot")
+		}
+	}
+
+	return nil
+}
+
+// append writes the entry to the log and applies it, it returns true if a snapshot is due
+// The journal lock is held across both steps so a snapshot never misses an entry that was written
+func (journal *Journal) append(entry *JournalEntry, apply func() error) (bool, error) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	if journal.logFile == nil {
+		return false, fmt.Errorf("journal is not initialized")
+	}
+
+	journal.sequence++
+	entry.Sequence = journal.sequence
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	entryBytes = append(entryBytes, '\n')
+	_, err = journal.logFile.Write(entryBytes)
+	if err != nil {
+		return false, err
+	}
+
+	err = journal.logFile.Sync()
+	if err != nil {
+		return false, err
+	}
+
+	err = apply()
+	if err != nil {
+		return false, err
+	}
+
+	journal.entriesSinceSnap++
+	if journal.SnapshotEntries > 0 && journal.entriesSinceSnap >= journal.SnapshotEntries && !journal.snapshotting {
+		journal.snapshotting = true
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// takeSnapshot copies storage data and rotates the log under the journal lock, and writes the snapshot without it
+func (journal *Journal) takeSnapshot() error {
+	journal.snapshotMutex.Lock()
+	defer journal.snapshotMutex.Unlock()
+
+	journal.mutex.Lock()
+	snapshot, err := journal.rotate()
+	journal.mutex.Unlock()
+
+	if err == nil {
+		err = journal.saveSnapshot(snapshot)
+	}
+
+	journal.mutex.Lock()
+	journal.snapshotting = false
+	journal.mutex.Unlock()
+
+	return err
+}
+
+// copySnapshot copies storage data at the current sequence, the journal lock must be held
+func (journal *Journal) copySnapshot() *JournalSnapshot {
+	snapshot := &JournalSnapshot{
+		Sequence:          journal.sequence,
+		Time:              time.Now().UTC(),
+		Instances:         journal.Storage.ListInstances(),
+		FileTransfers:     []types.ReportFileTransfer{},
+		Rollups:           journal.Storage.ListRollups(""),
+		TransferSequences: map[string]int64{},
+	}
+
+	for _, instance := range snapshot.Instances {
+		transfers := journal.Storage.ListFileTransfersForInstance(instance.InstanceID)
+		snapshot.FileTransfers = append(snapshot.FileTransfers, transfers...)
+
+		if sequence := journal.Storage.LastTransferSequence(instance.InstanceID); sequence > 0 {
+			snapshot.TransferSequences[instance.InstanceID] = sequence
+		}
+	}
+
+	return snapshot
+}
+
+// rotate copies storage data and moves entries in the log to the previous segment, the journal lock must be held
+// Entries written after the copy go to a new log, so the log is never truncated over entries that the snapshot misses
+func (journal *Journal) rotate() (*JournalSnapshot, error) {
+	if journal.logFile == nil {
+		return nil, fmt.Errorf("journal is not initialized")
+	}
+
+	snapshot := journal.copySnapshot()
+
+	err := journal.logFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	journal.logFile = nil
+
+	_, err = os.Stat(journal.previousLogPath())
+	if os.IsNotExist(err) {
+		err = os.Rename(journal.logPath(), journal.previousLogPath())
+	} else if err == nil {
+		// a previous snapshot failed, its segment is kept until a snapshot is written
+		err = appendFile(journal.previousLogPath(), journal.logPath())
+	}
+
+	logFile, openErr := os.OpenFile(journal.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if openErr != nil {
+		return nil, openErr
+	}
+	journal.logFile = logFile
+
+	if err != nil {
+		return nil, err
+	}
+
+	journal.entriesSinceSnap = 0
+	return snapshot, nil
+}
+
+// appendFile appends the source file to the target file and truncates the source
+func appendFile(targetPath string, sourcePath string) error {
+	sourceBytes, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	targetFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = targetFile.Write(sourceBytes)
+	if err == nil {
+		err = targetFile.Sync()
+	}
+
+	closeErr := targetFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Truncate(sourcePath, 0)
+}
+
+// saveSnapshot writes the snapshot to a snapshot file and removes the previous log segment
+func (journal *Journal) saveSnapshot(snapshot *JournalSnapshot) error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.saveSnapshot",
+	})
+
+	snapshotBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first, so a crash never leaves a partial snapshot
+	tempPath := journal.snapshotPath() + ".tmp"
+	tempFile, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = tempFile.Write(snapshotBytes)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tempPath, journal.snapshotPath())
+	if err != nil {
+		return err
+	}
+
+	// entries up to the snapshot sequence are skipped on replay,
+	// so a crash before the removal is safe
+	err = os.Remove(journal.previousLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	logger.Infof("Took a journal snapshot at sequence %d (%d instances, %d transfers)", snapshot.Sequence, len(snapshot.Instances), len(snapshot.FileTransfers))
+	return nil
+}
+
+// replay loads the latest snapshot and applies log entries written after it
+func (journal *Journal) replay() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.replay",
+	})
+
+	journal.Storage.CleanUp()
+
+	snapshotBytes, err := ioutil.ReadFile(journal.snapshotPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		var snapshot JournalSnapshot
+		err = json.Unmarshal(snapshotBytes, &snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to read journal snapshot - %v", err)
+		}
+
+		for _, instance := range snapshot.Instances {
+			err = journal.Storage.AddInstance(instance)
+			if err != nil {
+				return err
+			}
+		}
+
+		instanceTransfers := map[string][]types.ReportFileTransfer{}
+		for _, transfer := range snapshot.FileTransfers {
+			transfers := instanceTransfers[transfer.InstanceID]
+			if transfer.Sequence == 0 {
+				// snapshots taken before sequence numbers were recorded keep transfers in insertion order
+				transfer.Sequence = int64(len(transfers) + 1)
+			}
+			instanceTransfers[transfer.InstanceID] = append(transfers, transfer)
+		}
+
+		for _, instance := range snapshot.Instances {
+			transfers := instanceTransfers[instance.InstanceID]
+			lastSequence := snapshot.TransferSequences[instance.InstanceID]
+			if len(transfers) == 0 && lastSequence == 0 {
+				continue
+			}
+
+			err = journal.Storage.RestoreFileTransfers(instance.InstanceID, transfers, lastSequence)
+			if err != nil {
+				logger.WithError(err).Warnf("Could not restore transfers of instance %s from snapshot", instance.InstanceID)
+			}
+		}
+
+		err = journal.Storage.AddRollups(snapshot.Rollups)
+		if err != nil {
+			return err
+		}
+
+		journal.sequence = snapshot.Sequence
+		logger.Infof("Loaded a journal snapshot at sequence %d", snapshot.Sequence)
+	}
+
+	// the previous segment is left when the service stopped while a snapshot was written
+	replayed := 0
+	for _, logPath := range []string{journal.previousLogPath(), journal.logPath()} {
+		count, err := journal.replayLog(logPath)
+		if err != nil {
+			return err
+		}
+		replayed += count
+	}
+
+	logger.Infof("Replayed %d journal entries", replayed)
+	return nil
+}
+
+// replayLog applies entries in the log file written after the current sequence
+func (journal *Journal) replayLog(logPath string) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.replayLog",
+	})
+
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer logFile.Close()
+
+	replayed := 0
+	reader := bufio.NewReader(logFile)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				// torn write at the tail, the request was never acknowledged
+				logger.Warnf("Ignoring an incomplete journal entry at the end of %s", logPath)
+				break
+			}
+
+			var entry JournalEntry
+			err = json.Unmarshal(line, &entry)
+			if err != nil {
+				return replayed, fmt.Errorf("failed to read journal entry - %v", err)
+			}
+
+			if entry.Sequence > journal.sequence {
+				journal.apply(&entry)
+				journal.sequence = entry.Sequence
+				replayed++
+			}
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	return replayed, nil
+}
+
+// apply applies a replayed entry to storage, restoring the original timestamps
+func (journal *Journal) apply(entry *JournalEntry) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "Journal.apply",
+	})
+
+	var err error
+	switch entry.Operation {
+	case JournalOperationAddInstance:
+		if entry.Instance != nil {
+			err = journal.Storage.ClaimInstance(*entry.Instance, entry.TakeOver)
+		}
+	case JournalOperationTerminateInstance:
+		err = journal.Storage.TerminateInstance(entry.InstanceID, entry.Time)
+	case JournalOperationHeartbeat:
+		err = journal.Storage.UpdateInstanceLastActivityTime(entry.InstanceID, entry.Time)
+	case JournalOperationSetInstanceState:
+		// entries written before the last activity time was recorded set the state anyway
+		var lastActivityTime time.Time
+		if entry.LastActivityTime != nil {
+			lastActivityTime = *entry.LastActivityTime
+		}
+		err = journal.Storage.SetInstanceState(entry.InstanceID, entry.State, lastActivityTime)
+	case JournalOperationAddFileTransfer:
+		if entry.Transfer != nil {
+			err = journal.Storage.AddFileTransfer(*entry.Transfer)
+			if err == nil {
+				err = journal.Storage.UpdateInstanceLastActivityTime(entry.Transfer.InstanceID, entry.Time)
+			}
+		}
+	case JournalOperationAddFileTransfers:
+		journal.Storage.AddFileTransfers(entry.Transfers)
+		for _, instanceID := range transferInstanceIDs(entry.Transfers) {
+			err = journal.Storage.UpdateInstanceLastActivityTime(instanceID, entry.Time)
+		}
+	case JournalOperationCleanUp:
+		journal.Storage.CleanUp()
+	case JournalOperationClearOld:
+		journal.Storage.ClearOld(entry.Days, entry.Time)
+	case JournalOperationApplyRetention:
+		if entry.Retention != nil {
+			journal.Storage.ApplyRetention(entry.Retention, entry.Time)
+		}
+	case JournalOperationImport:
+		_, err = importToStorage(journal.Storage, entry.Instances, entry.Transfers)
+	default:
+		err = fmt.Errorf("unknown journal operation %s", entry.Operation)
+	}
+
+	if err != nil {
+		// the same error was returned to the client when the entry was written
+		logger.WithError(err).Debugf("Could not replay journal entry %d", entry.Sequence)
+	}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+// entries of the tests are written long before the tests run, so replaying them at the current time would give other results
+var journalTestTime = time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+func journalTestInstance(instanceID string, created time.Time) *JournalEntry {
+	return &JournalEntry{
+		Operation: JournalOperationAddInstance,
+		Time:      created,
+		Instance: &types.ReportInstance{
+			InstanceID:       instanceID,
+			ClientUser:       "user_" + instanceID,
+			CreationTime:     created,
+			LastActivityTime: created,
+			State:            types.InstanceStateActive,
+		},
+		TakeOver: true,
+	}
+}
+
+func journalTestTransfer(instanceID string, filePath string, opened time.Time) *JournalEntry {
+	return &JournalEntry{
+		Operation: JournalOperationAddFileTransfer,
+		Time:      opened.Add(time.Minute),
+		Transfer: &types.ReportFileTransfer{
+			InstanceID:    instanceID,
+			FilePath:      filePath,
+			FileSize:      1024,
+			TransferSize:  1024,
+			FileOpenMode:  "r",
+			FileOpenTime:  opened,
+			FileCloseTime: opened.Add(time.Minute),
+		},
+	}
+}
+
+// journalTestState is storage data in a comparable form
+type journalTestState struct {
+	Instances []types.ReportInstance
+	Transfers map[string][]types.ReportFileTransfer
+	Sequences map[string]int64
+	Rollups   []string
+}
+
+func newJournalTestState(t *testing.T, storage Storage) string {
+	state := journalTestState{
+		Instances: storage.ListInstances(),
+		Transfers: map[string][]types.ReportFileTransfer{},
+		Sequences: map[string]int64{},
+		Rollups:   []string{},
+	}
+
+	sort.Slice(state.Instances, func(i int, j int) bool {
+		return state.Instances[i].InstanceID < state.Instances[j].InstanceID
+	})
+
+	for _, instance := range state.Instances {
+		state.Transfers[instance.InstanceID] = storage.ListFileTransfersForInstance(instance.InstanceID)
+		state.Sequences[instance.InstanceID] = storage.LastTransferSequence(instance.InstanceID)
+	}
+
+	for _, rollup := range storage.ListRollups("") {
+		rollupBytes, err := json.Marshal(rollup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Rollups = append(state.Rollups, string(rollupBytes))
+	}
+	sort.Strings(state.Rollups)
+
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(stateBytes)
+}
+
+func journalTestSequences(storage Storage, instanceID string) []int64 {
+	sequences := []int64{}
+	for _, transfer := range storage.ListFileTransfersForInstance(instanceID) {
+		sequences = append(sequences, transfer.Sequence)
+	}
+	return sequences
+}
+
+func TestJournalReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*JournalEntry
+		// check checks storage after the entries are applied, and again after they are replayed
+		check func(t *testing.T, storage Storage)
+	}{
+		{
+			name: "transfers",
+			entries: []*JournalEntry{
+				journalTestInstance("a", journalTestTime),
+				journalTestTransfer("a", "/zone/home/user/a.txt", journalTestTime.Add(time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/b.txt", journalTestTime.Add(2*time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/c.txt", journalTestTime.Add(3*time.Minute)),
+			},
+			check: func(t *testing.T, storage Storage) {
+				if sequences := journalTestSequences(storage, "a"); !reflect.DeepEqual(sequences, []int64{1, 2, 3}) {
+					t.Errorf("expected sequences [1 2 3], got %v", sequences)
+				}
+
+				instance, _ := storage.GetInstance("a")
+				if !instance.LastActivityTime.Equal(journalTestTime.Add(4 * time.Minute)) {
+					t.Errorf("expected the last activity at the last transfer, got %s", instance.LastActivityTime)
+				}
+			},
+		},
+		{
+			name: "terminate at the time it ran",
+			entries: []*JournalEntry{
+				journalTestInstance("a", journalTestTime),
+				{Operation: JournalOperationTerminateInstance, Time: journalTestTime.Add(time.Hour), InstanceID: "a"},
+			},
+			check: func(t *testing.T, storage Storage) {
+				instance, _ := storage.GetInstance("a")
+				if !instance.Terminated || !instance.TerminationTime.Equal(journalTestTime.Add(time.Hour)) {
+					t.Errorf("expected the instance terminated at the entry time, got %t at %s", instance.Terminated, instance.TerminationTime)
+				}
+			},
+		},
+		{
+			name: "clear old at the time it ran",
+			entries: []*JournalEntry{
+				journalTestInstance("old", journalTestTime.AddDate(0, 0, -10)),
+				journalTestTransfer("old", "/zone/home/user/old.txt", journalTestTime.AddDate(0, 0, -10)),
+				journalTestInstance("new", journalTestTime.AddDate(0, 0, -1)),
+				journalTestTransfer("new", "/zone/home/user/new.txt", journalTestTime.AddDate(0, 0, -1)),
+				{Operation: JournalOperationClearOld, Time: journalTestTime, Days: 7},
+			},
+			check: func(t *testing.T, storage Storage) {
+				if _, ok := storage.GetInstance("old"); ok {
+					t.Error("expected instance old to be cleared")
+				}
+
+				if _, ok := storage.GetInstance("new"); !ok {
+					t.Error("expected instance new to be kept, it was not 7 days old when the entry was written")
+				}
+
+				if len(storage.ListRollups(types.StatsBucketHour)) != 1 {
+					t.Errorf("expected the transfer of instance old in a rollup, got %v", storage.ListRollups(types.StatsBucketHour))
+				}
+			},
+		},
+		{
+			name: "retention keeps sequence numbers",
+			entries: []*JournalEntry{
+				journalTestInstance("a", journalTestTime),
+				journalTestTransfer("a", "/zone/home/user/a.txt", journalTestTime.Add(time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/b.txt", journalTestTime.Add(2*time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/c.txt", journalTestTime.Add(3*time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/d.txt", journalTestTime.Add(4*time.Minute)),
+				{Operation: JournalOperationApplyRetention, Time: journalTestTime.Add(time.Hour), Retention: &RetentionPolicy{MaxTransfersPerInstance: 2}},
+				journalTestTransfer("a", "/zone/home/user/e.txt", journalTestTime.Add(2*time.Hour)),
+			},
+			check: func(t *testing.T, storage Storage) {
+				if sequences := journalTestSequences(storage, "a"); !reflect.DeepEqual(sequences, []int64{3, 4, 5}) {
+					t.Errorf("expected sequences [3 4 5], got %v", sequences)
+				}
+
+				if transfer, ok := storage.GetFileTransfer("a", 4); !ok || transfer.FilePath != "/zone/home/user/d.txt" {
+					t.Errorf("expected transfer a/4 to be d.txt, got %v", transfer)
+				}
+			},
+		},
+		{
+			name: "retention removes all transfers",
+			entries: []*JournalEntry{
+				journalTestInstance("a", journalTestTime),
+				journalTestTransfer("a", "/zone/home/user/a.txt", journalTestTime.Add(time.Minute)),
+				journalTestTransfer("a", "/zone/home/user/b.txt", journalTestTime.Add(2*time.Minute)),
+				{Operation: JournalOperationApplyRetention, Time: journalTestTime.AddDate(0, 0, 2), Retention: &RetentionPolicy{TransferTTL: 24 * time.Hour}},
+			},
+			check: func(t *testing.T, storage Storage) {
+				if sequences := journalTestSequences(storage, "a"); len(sequences) != 0 {
+					t.Errorf("expected no transfers, got %v", sequences)
+				}
+
+				// a new transfer must not get a sequence number of a removed transfer
+				if sequence := storage.LastTransferSequence("a"); sequence != 2 {
+					t.Errorf("expected the last sequence 2, got %d", sequence)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		// without snapshots all entries are replayed from the log, with snapshots some are loaded from a snapshot
+		for _, snapshotEntries := range []int{0, 2} {
+			t.Run(test.name, func(t *testing.T) {
+				dirPath := t.TempDir()
+
+				storage := NewMemoryStorage()
+				journal := NewJournal(dirPath, snapshotEntries, storage)
+				err := journal.Init()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, entry := range test.entries {
+					entry := entry
+					err = journal.Write(entry, func() error {
+						journal.apply(entry)
+						return nil
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				test.check(t, storage)
+				expected := newJournalTestState(t, storage)
+
+				// stop without a final snapshot, as if the service crashed
+				journal.logFile.Close()
+
+				replayedStorage := NewMemoryStorage()
+				replayedJournal := NewJournal(dirPath, snapshotEntries, replayedStorage)
+				err = replayedJournal.Init()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer replayedJournal.Destroy()
+
+				test.check(t, replayedStorage)
+				if replayed := newJournalTestState(t, replayedStorage); replayed != expected {
+					t.Errorf("expected replayed storage\n%s\ngot\n%s", expected, replayed)
+				}
+			})
+		}
+	}
+}
+
+// TestJournalReplayPreviousSegment checks that entries in the previous log segment are replayed
+// when the service stops after the log is rotated and before the snapshot is written
+func TestJournalReplayPreviousSegment(t *testing.T) {
+	// one rotation leaves the previous segment, two rotations append the log to it
+	for _, rotations := range []int{1, 2} {
+		dirPath := t.TempDir()
+
+		storage := NewMemoryStorage()
+		journal := NewJournal(dirPath, 0, storage)
+		err := journal.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries := []*JournalEntry{
+			journalTestInstance("a", journalTestTime),
+			journalTestTransfer("a", "/zone/home/user/a.txt", journalTestTime.Add(time.Minute)),
+			journalTestTransfer("a", "/zone/home/user/b.txt", journalTestTime.Add(2*time.Minute)),
+			journalTestTransfer("a", "/zone/home/user/c.txt", journalTestTime.Add(3*time.Minute)),
+		}
+
+		for i, entry := range entries {
+			entry := entry
+			err = journal.Write(entry, func() error {
+				journal.apply(entry)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if i < rotations {
+				journal.mutex.Lock()
+				_, err = journal.rotate()
+				journal.mutex.Unlock()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		expected := newJournalTestState(t, storage)
+		journal.logFile.Close()
+
+		replayedStorage := NewMemoryStorage()
+		replayedJournal := NewJournal(dirPath, 0, replayedStorage)
+		err = replayedJournal.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if replayed := newJournalTestState(t, replayedStorage); replayed != expected {
+			t.Errorf("expected replayed storage after %d rotations\n%s\ngot\n%s", rotations, expected, replayed)
+		}
+
+		if _, err := os.Stat(replayedJournal.previousLogPath()); !os.IsNotExist(err) {
+			t.Errorf("expected the previous segment to be removed by the snapshot, got %v", err)
+		}
+
+		replayedJournal.Destroy()
+	}
+}
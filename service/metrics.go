@@ -0,0 +1,357 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	MetricsContentType string = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// metricsLatencyBuckets are upper bounds of request latency histogram buckets in seconds
+var metricsLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies a series of request metrics
+type requestKey struct {
+	Route  string
+	Method string
+}
+
+// requestLatency is a latency histogram of a request series
+type requestLatency struct {
+	BucketCounts []uint64
+	Count        uint64
+	Sum          float64
+}
+
+// Metrics collects metrics of the monitor service itself
+type Metrics struct {
+	requestCounts    map[requestKey]map[int]uint64
+	requestLatencies map[requestKey]*requestLatency
+	cleanupRuns      map[string]uint64
+	retentionRuns    uint64
+	retentionRemoved map[string]uint64
+	retentionTime    time.Time
+	mutex            sync.Mutex
+}
+
+// NewMetrics creates a metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requestCounts:    map[requestKey]map[int]uint64{},
+		requestLatencies: map[requestKey]*requestLatency{},
+		cleanupRuns:      map[string]uint64{},
+		retentionRuns:    0,
+		retentionRemoved: map[string]uint64{"instance": 0, "transfer": 0, "rollup": 0},
+		retentionTime:    time.Time{},
+		mutex:            sync.Mutex{},
+	}
+}
+
+// statusRecorder is a http.ResponseWriter that records the status code
+type statusRecorder struct {
+	http.ResponseWriter
+	StatusCode int
+}
+
+// WriteHeader records the status code and writes it
+func (recorder *statusRecorder) WriteHeader(statusCode int) {
+	recorder.StatusCode = statusCode
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush sends buffered data to the client, used by streaming handlers
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Middleware returns a mux middleware that counts requests and measures their latencies per route
+func (metrics *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{
+			ResponseWriter: w,
+			StatusCode:     http.StatusOK,
+		}
+
+		startTime := time.Now()
+		next.ServeHTTP(recorder, r)
+		elapsed := time.Since(startTime)
+
+		route := "unknown"
+		if currentRoute := mux.CurrentRoute(r); currentRoute != nil {
+			if template, err := currentRoute.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		metrics.ObserveRequest(route, r.Method, recorder.StatusCode, elapsed)
+	})
+}
+
+// ObserveRequest records a handled request
+func (metrics *Metrics) ObserveRequest(route string, method string, statusCode int, elapsed time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	key := requestKey{
+		Route:  route,
+		Method: method,
+	}
+
+	codes, ok := metrics.requestCounts[key]
+	if !ok {
+		codes = map[int]uint64{}
+		metrics.requestCounts[key] = codes
+	}
+	codes[statusCode]++
+
+	latency, ok := metrics.requestLatencies[key]
+	if !ok {
+		latency = &requestLatency{
+			BucketCounts: make([]uint64, len(metricsLatencyBuckets)),
+		}
+		metrics.requestLatencies[key] = latency
+	}
+
+	seconds := elapsed.Seconds()
+	for idx, bound := range metricsLatencyBuckets {
+		if seconds <= bound {
+			latency.BucketCounts[idx]++
+		}
+	}
+	latency.Count++
+	latency.Sum += seconds
+}
+
+// IncCleanupRuns counts a cleanup run of the given type
+func (metrics *Metrics) IncCleanupRuns(cleanupType string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.cleanupRuns[cleanupType]++
+}
+
+// ObserveRetention records a retention run
+func (metrics *Metrics) ObserveRetention(result RetentionResult, runTime time.Time) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.retentionRuns++
+	metrics.retentionRemoved["instance"] += uint64(result.InstancesRemoved)
+	metrics.retentionRemoved["transfer"] += uint64(result.TransfersRemoved)
+	metrics.retentionRemoved["rollup"] += uint64(result.RollupsRemoved)
+	metrics.retentionTime = runTime
+}
+
+// WriteTo writes metrics of the monitor service in Prometheus text format
+func (metrics *Metrics) WriteTo(writer *MetricsWriter) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	keys := []requestKey{}
+	for key := range metrics.requestCounts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i int, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		return keys[i].Method < keys[j].Method
+	})
+
+	writer.Header("irodsfs_monitor_http_requests_total", "counter", "Number of HTTP requests handled per route, method and status code.")
+	for _, key := range keys {
+		codes := []int{}
+		for code := range metrics.requestCounts[key] {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			writer.Sample("irodsfs_monitor_http_requests_total", float64(metrics.requestCounts[key][code]), "route", key.Route, "method", key.Method, "code", strconv.Itoa(code))
+		}
+	}
+
+	writer.Header("irodsfs_monitor_http_request_duration_seconds", "histogram", "Latency of HTTP requests per route and method.")
+	for _, key := range keys {
+		latency := metrics.requestLatencies[key]
+		for idx, bound := range metricsLatencyBuckets {
+			writer.Sample("irodsfs_monitor_http_request_duration_seconds_bucket", float64(latency.BucketCounts[idx]), "route", key.Route, "method", key.Method, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		writer.Sample("irodsfs_monitor_http_request_duration_seconds_bucket", float64(latency.Count), "route", key.Route, "method", key.Method, "le", "+Inf")
+		writer.Sample("irodsfs_monitor_http_request_duration_seconds_sum", latency.Sum, "route", key.Route, "method", key.Method)
+		writer.Sample("irodsfs_monitor_http_request_duration_seconds_count", float64(latency.Count), "route", key.Route, "method", key.Method)
+	}
+
+	cleanupTypes := []string{}
+	for cleanupType := range metrics.cleanupRuns {
+		cleanupTypes = append(cleanupTypes, cleanupType)
+	}
+	sort.Strings(cleanupTypes)
+
+	writer.Header("irodsfs_monitor_cleanup_runs_total", "counter", "Number of storage cleanup runs per type.")
+	for _, cleanupType := range cleanupTypes {
+		writer.Sample("irodsfs_monitor_cleanup_runs_total", float64(metrics.cleanupRuns[cleanupType]), "type", cleanupType)
+	}
+
+	writer.Header("irodsfs_monitor_retention_runs_total", "counter", "Number of retention policy runs.")
+	writer.Sample("irodsfs_monitor_retention_runs_total", float64(metrics.retentionRuns))
+
+	writer.Header("irodsfs_monitor_retention_removed_total", "counter", "Number of records removed by the retention policy per kind.")
+	for _, kind := range []string{"instance", "transfer", "rollup"} {
+		writer.Sample("irodsfs_monitor_retention_removed_total", float64(metrics.retentionRemoved[kind]), "kind", kind)
+	}
+
+	if !metrics.retentionTime.IsZero() {
+		writer.Header("irodsfs_monitor_retention_last_run_timestamp_seconds", "gauge", "Time of the last retention policy run.")
+		writer.Sample("irodsfs_monitor_retention_last_run_timestamp_seconds", float64(metrics.retentionTime.Unix()))
+	}
+}
+
+// WriteStorageMetrics writes storage sizes and fleet metrics derived from storage data
+func WriteStorageMetrics(writer *MetricsWriter, storage Storage) {
+	type instanceKey struct {
+		Zone       string
+		AuthScheme string
+		State      string
+	}
+
+	instanceCounts := map[instanceKey]int{}
+	transferCount := 0
+	transferredBytes := map[string]int64{}
+	accessCounts := map[string]int{
+		"sequential": 0,
+		"random":     0,
+	}
+
+	for _, instance := range storage.ListInstances() {
+		instanceCounts[instanceKey{
+			Zone:       instance.Zone,
+			AuthScheme: instance.AuthScheme,
+			State:      instance.GetState(),
+		}]++
+
+		for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+			transferCount++
+			transferredBytes[transfer.FileOpenMode] += transfer.TransferSize
+			if transfer.SequentialAccess {
+				accessCounts["sequential"]++
+			} else {
+				accessCounts["random"]++
+			}
+		}
+	}
+
+	instanceTotal := 0
+	keys := []instanceKey{}
+	for key, count := range instanceCounts {
+		keys = append(keys, key)
+		instanceTotal += count
+	}
+
+	sort.Slice(keys, func(i int, j int) bool {
+		if keys[i].Zone != keys[j].Zone {
+			return keys[i].Zone < keys[j].Zone
+		}
+		if keys[i].AuthScheme != keys[j].AuthScheme {
+			return keys[i].AuthScheme < keys[j].AuthScheme
+		}
+		return keys[i].State < keys[j].State
+	})
+
+	writer.Header("irodsfs_monitor_storage_instances", "gauge", "Number of instances kept in storage.")
+	writer.Sample("irodsfs_monitor_storage_instances", float64(instanceTotal))
+
+	writer.Header("irodsfs_monitor_storage_transfers", "gauge", "Number of file transfers kept in storage.")
+	writer.Sample("irodsfs_monitor_storage_transfers", float64(transferCount))
+
+	writer.Header("irodsfs_fleet_instances", "gauge", "Number of iRODS FUSE Lite instances per zone, auth scheme and state.")
+	for _, key := range keys {
+		writer.Sample("irodsfs_fleet_instances", float64(instanceCounts[key]), "zone", key.Zone, "auth_scheme", key.AuthScheme, "state", key.State)
+	}
+
+	openModes := []string{}
+	for openMode := range transferredBytes {
+		openModes = append(openModes, openMode)
+	}
+	sort.Strings(openModes)
+
+	writer.Header("irodsfs_fleet_transferred_bytes", "gauge", "Bytes transferred by file transfers kept in storage per file open mode.")
+	for _, openMode := range openModes {
+		writer.Sample("irodsfs_fleet_transferred_bytes", float64(transferredBytes[openMode]), "file_open_mode", openMode)
+	}
+
+	writer.Header("irodsfs_fleet_transfers", "gauge", "Number of file transfers kept in storage per access pattern.")
+	writer.Sample("irodsfs_fleet_transfers", float64(accessCounts["random"]), "access", "random")
+	writer.Sample("irodsfs_fleet_transfers", float64(accessCounts["sequential"]), "access", "sequential")
+}
+
+// MetricsWriter writes metrics in Prometheus text exposition format
+type MetricsWriter struct {
+	Writer io.Writer
+	Err    error
+}
+
+// NewMetricsWriter creates a metrics writer
+func NewMetricsWriter(writer io.Writer) *MetricsWriter {
+	return &MetricsWriter{
+		Writer: writer,
+		Err:    nil,
+	}
+}
+
+func (writer *MetricsWriter) write(line string) {
+	if writer.Err != nil {
+		return
+	}
+
+	_, writer.Err = io.WriteString(writer.Writer, line)
+}
+
+// Header writes HELP and TYPE lines of a metric
+func (writer *MetricsWriter) Header(name string, metricType string, help string) {
+	writer.write(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType))
+}
+
+// Sample writes a sample of a metric with label name and value pairs
+func (writer *MetricsWriter) Sample(name string, value float64, labelPairs ...string) {
+	var sb strings.Builder
+	sb.WriteString(name)
+
+	if len(labelPairs) > 0 {
+		sb.WriteString("{")
+		for idx := 0; idx+1 < len(labelPairs); idx += 2 {
+			if idx > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(labelPairs[idx])
+			sb.WriteString("=\"")
+			sb.WriteString(escapeMetricLabelValue(labelPairs[idx+1]))
+			sb.WriteString("\"")
+		}
+		sb.WriteString("}")
+	}
+
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	sb.WriteString("\n")
+	writer.write(sb.String())
+}
+
+func escapeMetricLabelValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
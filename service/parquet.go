@@ -0,0 +1,444 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// A minimal Parquet writer for exports, so the service does not need a Parquet library
+// It writes flat schemas of required and optional columns, one uncompressed PLAIN encoded data page per column chunk
+// See https://github.com/apache/parquet-format for the file layout
+
+const (
+	parquetMagic string = "PAR1"
+	// parquetRowGroupSize is the number of rows buffered before a row group is written
+	parquetRowGroupSize int = 10000
+
+	parquetTypeBoolean   int32 = 0
+	parquetTypeInt64     int32 = 2
+	parquetTypeByteArray int32 = 6
+
+	parquetRepetitionRequired int32 = 0
+	parquetRepetitionOptional int32 = 1
+
+	parquetConvertedTypeUTF8 int32 = 0
+
+	parquetEncodingPlain     int32 = 0
+	parquetEncodingRLE       int32 = 3
+	parquetCodecUncompressed int32 = 0
+	parquetPageTypeData      int32 = 0
+)
+
+// element types of the thrift compact protocol, that Parquet uses for its metadata
+const (
+	thriftTypeBoolTrue  byte = 1
+	thriftTypeBoolFalse byte = 2
+	thriftTypeI32       byte = 5
+	thriftTypeI64       byte = 6
+	thriftTypeBinary    byte = 8
+	thriftTypeList      byte = 9
+	thriftTypeStruct    byte = 12
+)
+
+// thriftWriter encodes structs in the thrift compact protocol
+type thriftWriter struct {
+	buffer       bytes.Buffer
+	lastFieldID  int16
+	parentFields []int16
+}
+
+func (w *thriftWriter) writeVarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buffer.Write(b[:n])
+}
+
+func (w *thriftWriter) writeZigzag(v int64) {
+	w.writeVarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) writeString(v string) {
+	w.writeVarint(uint64(len(v)))
+	w.buffer.WriteString(v)
+}
+
+func (w *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	delta := id - w.lastFieldID
+	if delta > 0 && delta <= 15 {
+		w.buffer.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buffer.WriteByte(fieldType)
+		w.writeZigzag(int64(id))
+	}
+	w.lastFieldID = id
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, thriftTypeI32)
+	w.writeZigzag(int64(v))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, thriftTypeI64)
+	w.writeZigzag(v)
+}
+
+func (w *thriftWriter) stringField(id int16, v string) {
+	w.fieldHeader(id, thriftTypeBinary)
+	w.writeString(v)
+}
+
+func (w *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		w.fieldHeader(id, thriftTypeBoolTrue)
+	} else {
+		w.fieldHeader(id, thriftTypeBoolFalse)
+	}
+}
+
+func (w *thriftWriter) listField(id int16, elementType byte, size int) {
+	w.fieldHeader(id, thriftTypeList)
+	if size < 15 {
+		w.buffer.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.buffer.WriteByte(0xf0 | elementType)
+		w.writeVarint(uint64(size))
+	}
+}
+
+// beginStruct starts a struct that is a list element or the top level struct
+func (w *thriftWriter) beginStruct() {
+	w.parentFields = append(w.parentFields, w.lastFieldID)
+	w.lastFieldID = 0
+}
+
+func (w *thriftWriter) beginStructField(id int16) {
+	w.fieldHeader(id, thriftTypeStruct)
+	w.beginStruct()
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buffer.WriteByte(0)
+	w.lastFieldID = w.parentFields[len(w.parentFields)-1]
+	w.parentFields = w.parentFields[:len(w.parentFields)-1]
+}
+
+// parquetColumnBuffer holds values of a column in the current row group
+type parquetColumnBuffer struct {
+	values    bytes.Buffer
+	bools     []bool
+	defLevels []byte
+	numValues int64
+}
+
+type parquetColumnChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetColumnChunk
+	size    int64
+	numRows int64
+}
+
+// parquetWriter writes rows to a Parquet file
+type parquetWriter struct {
+	writer    io.Writer
+	offset    int64
+	columns   []exportColumn
+	buffers   []*parquetColumnBuffer
+	rowCount  int
+	numRows   int64
+	rowGroups []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, columns []exportColumn) (*parquetWriter, error) {
+	writer := &parquetWriter{
+		writer:    w,
+		columns:   columns,
+		rowGroups: []parquetRowGroup{},
+	}
+
+	writer.resetBuffers()
+
+	err := writer.write([]byte(parquetMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (writer *parquetWriter) resetBuffers() {
+	writer.buffers = make([]*parquetColumnBuffer, len(writer.columns))
+	for i := range writer.columns {
+		writer.buffers[i] = &parquetColumnBuffer{}
+	}
+	writer.rowCount = 0
+}
+
+func (writer *parquetWriter) write(b []byte) error {
+	n, err := writer.writer.Write(b)
+	writer.offset += int64(n)
+	return err
+}
+
+// WriteRow buffers a row, and writes a row group when enough rows are buffered
+func (writer *parquetWriter) WriteRow(row []interface{}) error {
+	for i, column := range writer.columns {
+		buffer := writer.buffers[i]
+		buffer.numValues++
+
+		switch column.Type {
+		case exportColumnString:
+			v := row[i].(string)
+			var length [4]byte
+			binary.LittleEndian.PutUint32(length[:], uint32(len(v)))
+			buffer.values.Write(length[:])
+			buffer.values.WriteString(v)
+		case exportColumnInt64:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(row[i].(int64)))
+			buffer.values.Write(b[:])
+		case exportColumnBool:
+			buffer.bools = append(buffer.bools, row[i].(bool))
+		case exportColumnTime:
+			// zero times are nulls
+			t := row[i].(time.Time)
+			if t.IsZero() {
+				buffer.defLevels = append(buffer.defLevels, 0)
+				continue
+			}
+
+			buffer.defLevels = append(buffer.defLevels, 1)
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(t.UnixNano()))
+			buffer.values.Write(b[:])
+		}
+	}
+
+	writer.rowCount++
+	if writer.rowCount >= parquetRowGroupSize {
+		return writer.flushRowGroup()
+	}
+
+	return nil
+}
+
+func (writer *parquetWriter) flushRowGroup() error {
+	if writer.rowCount == 0 {
+		return nil
+	}
+
+	rowGroup := parquetRowGroup{
+		chunks:  []parquetColumnChunk{},
+		numRows: int64(writer.rowCount),
+	}
+
+	for i, column := range writer.columns {
+		buffer := writer.buffers[i]
+
+		page := bytes.Buffer{}
+		if column.Type == exportColumnTime {
+			levels := encodeParquetLevels(buffer.defLevels)
+			var length [4]byte
+			binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+			page.Write(length[:])
+			page.Write(levels)
+		}
+
+		if column.Type == exportColumnBool {
+			page.Write(packParquetBools(buffer.bools))
+		} else {
+			page.Write(buffer.values.Bytes())
+		}
+
+		header := thriftWriter{}
+		header.beginStruct()
+		header.i32Field(1, parquetPageTypeData)
+		header.i32Field(2, int32(page.Len()))
+		header.i32Field(3, int32(page.Len()))
+		header.beginStructField(5)
+		header.i32Field(1, int32(buffer.numValues))
+		header.i32Field(2, parquetEncodingPlain)
+		header.i32Field(3, parquetEncodingRLE)
+		header.i32Field(4, parquetEncodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetColumnChunk{
+			offset:    writer.offset,
+			size:      int64(header.buffer.Len() + page.Len()),
+			numValues: buffer.numValues,
+		}
+
+		err := writer.write(header.buffer.Bytes())
+		if err != nil {
+			return err
+		}
+
+		err = writer.write(page.Bytes())
+		if err != nil {
+			return err
+		}
+
+		rowGroup.chunks = append(rowGroup.chunks, chunk)
+		rowGroup.size += chunk.size
+	}
+
+	writer.rowGroups = append(writer.rowGroups, rowGroup)
+	writer.numRows += rowGroup.numRows
+	writer.resetBuffers()
+	return nil
+}
+
+// Close writes buffered rows and the file footer
+func (writer *parquetWriter) Close() error {
+	err := writer.flushRowGroup()
+	if err != nil {
+		return err
+	}
+
+	footer := writer.encodeFileMetaData()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+
+	err = writer.write(footer)
+	if err != nil {
+		return err
+	}
+
+	err = writer.write(length[:])
+	if err != nil {
+		return err
+	}
+
+	return writer.write([]byte(parquetMagic))
+}
+
+func (writer *parquetWriter) encodeFileMetaData() []byte {
+	w := thriftWriter{}
+	w.beginStruct()
+	w.i32Field(1, 1)
+
+	// schema, the root element followed by the columns
+	w.listField(2, thriftTypeStruct, len(writer.columns)+1)
+	w.beginStruct()
+	w.stringField(4, "schema")
+	w.i32Field(5, int32(len(writer.columns)))
+	w.endStruct()
+
+	for _, column := range writer.columns {
+		w.beginStruct()
+		w.i32Field(1, parquetPhysicalType(column.Type))
+		if column.Type == exportColumnTime {
+			w.i32Field(3, parquetRepetitionOptional)
+		} else {
+			w.i32Field(3, parquetRepetitionRequired)
+		}
+		w.stringField(4, column.Name)
+
+		switch column.Type {
+		case exportColumnString:
+			w.i32Field(6, parquetConvertedTypeUTF8)
+			// logical type STRING
+			w.beginStructField(10)
+			w.beginStructField(1)
+			w.endStruct()
+			w.endStruct()
+		case exportColumnTime:
+			// logical type TIMESTAMP in nanoseconds, adjusted to UTC
+			w.beginStructField(10)
+			w.beginStructField(8)
+			w.boolField(1, true)
+			w.beginStructField(2)
+			w.beginStructField(3)
+			w.endStruct()
+			w.endStruct()
+			w.endStruct()
+			w.endStruct()
+		}
+		w.endStruct()
+	}
+
+	w.i64Field(3, writer.numRows)
+
+	w.listField(4, thriftTypeStruct, len(writer.rowGroups))
+	for _, rowGroup := range writer.rowGroups {
+		w.beginStruct()
+		w.listField(1, thriftTypeStruct, len(rowGroup.chunks))
+		for i, chunk := range rowGroup.chunks {
+			column := writer.columns[i]
+
+			w.beginStruct()
+			w.i64Field(2, chunk.offset)
+			w.beginStructField(3)
+			w.i32Field(1, parquetPhysicalType(column.Type))
+			w.listField(2, thriftTypeI32, 2)
+			w.writeZigzag(int64(parquetEncodingPlain))
+			w.writeZigzag(int64(parquetEncodingRLE))
+			w.listField(3, thriftTypeBinary, 1)
+			w.writeString(column.Name)
+			w.i32Field(4, parquetCodecUncompressed)
+			w.i64Field(5, chunk.numValues)
+			w.i64Field(6, chunk.size)
+			w.i64Field(7, chunk.size)
+			w.i64Field(9, chunk.offset)
+			w.endStruct()
+			w.endStruct()
+		}
+		w.i64Field(2, rowGroup.size)
+		w.i64Field(3, rowGroup.numRows)
+		w.endStruct()
+	}
+
+	w.stringField(6, "irodsfs-monitor")
+	w.endStruct()
+
+	return w.buffer.Bytes()
+}
+
+func parquetPhysicalType(columnType exportColumnType) int32 {
+	switch columnType {
+	case exportColumnString:
+		return parquetTypeByteArray
+	case exportColumnBool:
+		return parquetTypeBoolean
+	default:
+		return parquetTypeInt64
+	}
+}
+
+// encodeParquetLevels encodes definition levels of bit width 1 in RLE runs
+func encodeParquetLevels(levels []byte) []byte {
+	w := thriftWriter{}
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+
+		w.writeVarint(uint64(j-i) << 1)
+		w.buffer.WriteByte(levels[i])
+		i = j
+	}
+
+	return w.buffer.Bytes()
+}
+
+// packParquetBools packs booleans into bits, the least significant bit first
+func packParquetBools(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	return packed
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var parquetTestColumns = []exportColumn{
+	{"name", exportColumnString},
+	{"size", exportColumnInt64},
+	{"error", exportColumnBool},
+	{"time", exportColumnTime},
+}
+
+func parquetTestRows(count int) [][]interface{} {
+	base := time.Date(2026, 10, 16, 12, 30, 0, 123456789, time.UTC)
+
+	rows := [][]interface{}{}
+	for i := 0; i < count; i++ {
+		t := base.Add(time.Duration(i) * time.Second)
+		if i%3 == 2 {
+			// null
+			t = time.Time{}
+		}
+
+		rows = append(rows, []interface{}{
+			fmt.Sprintf("/iplant/home/user%d/file%d", i%7, i),
+			int64(i) * -1024,
+			i%5 == 0,
+			t,
+		})
+	}
+	return rows
+}
+
+// parquetTestReader reads Parquet files following the format spec, independent of the writer's code
+// Thrift structs are read into maps of field IDs to values, lists into slices
+type parquetTestReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *parquetTestReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("at %d, %s", r.pos, fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *parquetTestReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.fail("cannot read %d bytes", n)
+		return make([]byte, n)
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *parquetTestReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail("invalid varint")
+		return 0
+	}
+
+	r.pos += n
+	return v
+}
+
+func (r *parquetTestReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *parquetTestReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+// thriftValue reads a value of the thrift compact type
+func (r *parquetTestReader) thriftValue(valueType byte) interface{} {
+	switch valueType {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5, 6:
+		return r.zigzag()
+	case 8:
+		return string(r.bytes(int(r.varint())))
+	case 9:
+		header := r.bytes(1)[0]
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+
+		list := []interface{}{}
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.thriftValue(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.thriftStruct()
+	default:
+		r.fail("unexpected thrift type %d", valueType)
+		return nil
+	}
+}
+
+func (r *parquetTestReader) thriftStruct() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	fieldID := int16(0)
+	for r.err == nil {
+		header := r.bytes(1)[0]
+		if header == 0 {
+			break
+		}
+
+		if delta := int16(header >> 4); delta > 0 {
+			fieldID += delta
+		} else {
+			fieldID = int16(r.zigzag())
+		}
+		fields[fieldID] = r.thriftValue(header & 0x0f)
+	}
+	return fields
+}
+
+// levels reads definition levels of bit width 1 in the RLE and bit packing hybrid encoding
+func (r *parquetTestReader) levels(count int) []byte {
+	end := r.pos + int(r.uint32())
+
+	levels := []byte{}
+	for r.pos < end && r.err == nil {
+		header := r.varint()
+		if header&1 == 0 {
+			value := r.bytes(1)[0]
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, value)
+			}
+			continue
+		}
+
+		for _, b := range r.bytes(int(header >> 1)) {
+			for i := uint(0); i < 8; i++ {
+				levels = append(levels, b>>i&1)
+			}
+		}
+	}
+	return levels[:count]
+}
+
+// parquetTestFile is a read Parquet file, with values of columns in all row groups, nulls are nil
+type parquetTestFile struct {
+	metadata map[int16]interface{}
+	values   [][]interface{}
+}
+
+func readParquetTestFile(data []byte) (*parquetTestFile, error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, fmt.Errorf("no magic at the start and the end")
+	}
+
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &parquetTestReader{data: data, pos: len(data) - 8 - footerLength}
+	file := &parquetTestFile{metadata: r.thriftStruct()}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to read the footer - %v", r.err)
+	}
+
+	schema := file.metadata[2].([]interface{})
+	file.values = make([][]interface{}, len(schema)-1)
+	for _, rowGroup := range file.metadata[4].([]interface{}) {
+		for i, chunk := range rowGroup.(map[int16]interface{})[1].([]interface{}) {
+			element := schema[i+1].(map[int16]interface{})
+			columnMetadata := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+
+			r.pos = int(columnMetadata[9].(int64))
+			pageHeader := r.thriftStruct()
+			numValues := int(pageHeader[5].(map[int16]interface{})[1].(int64))
+			pageEnd := r.pos + int(pageHeader[3].(int64))
+
+			levels := []byte{}
+			for j := 0; j < numValues; j++ {
+				levels = append(levels, 1)
+			}
+			if element[3].(int64) == 1 {
+				levels = r.levels(numValues)
+			}
+
+			bools := []byte{}
+			for _, level := range levels {
+				if level == 0 {
+					file.values[i] = append(file.values[i], nil)
+					continue
+				}
+
+				switch element[1].(int64) {
+				case 0:
+					if len(bools) == 0 {
+						b := r.bytes(1)[0]
+						for k := uint(0); k < 8; k++ {
+							bools = append(bools, b>>k&1)
+						}
+					}
+					file.values[i] = append(file.values[i], bools[0] == 1)
+					bools = bools[1:]
+				case 2:
+					file.values[i] = append(file.values[i], int64(binary.LittleEndian.Uint64(r.bytes(8))))
+				case 6:
+					file.values[i] = append(file.values[i], string(r.bytes(int(r.uint32()))))
+				}
+			}
+
+			if r.err != nil {
+				return nil, fmt.Errorf("failed to read column %s - %v", element[4], r.err)
+			}
+
+			if r.pos != pageEnd {
+				return nil, fmt.Errorf("expected column %s to end at %d, ended at %d", element[4], pageEnd, r.pos)
+			}
+		}
+	}
+
+	return file, nil
+}
+
+// TestParquetWriterRoundTrip reads files of the Parquet writer with a reader written from the format spec
+func TestParquetWriterRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      [][]interface{}
+		rowGroups int
+	}{
+		{"empty", parquetTestRows(0), 0},
+		{"one row", parquetTestRows(1), 1},
+		{"nulls and bools", parquetTestRows(11), 1},
+		{"full row group", parquetTestRows(parquetRowGroupSize), 1},
+		{"several row groups", parquetTestRows(parquetRowGroupSize*2 + 17), 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			writer, err := newParquetWriter(&buffer, parquetTestColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, row := range test.rows {
+				err = writer.WriteRow(row)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = writer.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := readParquetTestFile(buffer.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if numRows := file.metadata[3].(int64); numRows != int64(len(test.rows)) {
+				t.Fatalf("expected %d rows, got %d", len(test.rows), numRows)
+			}
+
+			if rowGroups := file.metadata[4].([]interface{}); len(rowGroups) != test.rowGroups {
+				t.Fatalf("expected %d row groups, got %d", test.rowGroups, len(rowGroups))
+			}
+
+			// the first schema element is the root
+			schema := file.metadata[2].([]interface{})
+			if len(schema) != len(parquetTestColumns)+1 {
+				t.Fatalf("expected %d schema elements, got %d", len(parquetTestColumns)+1, len(schema))
+			}
+
+			for i, column := range parquetTestColumns {
+				element := schema[i+1].(map[int16]interface{})
+				if element[4] != column.Name {
+					t.Errorf("expected column %d to be %s, got %v", i, column.Name, element[4])
+				}
+
+				switch column.Type {
+				case exportColumnString:
+					// converted type UTF8 and logical type STRING
+					expected := map[int16]interface{}{1: map[int16]interface{}{}}
+					if element[6] != int64(0) || !reflect.DeepEqual(element[10], expected) {
+						t.Errorf("expected column %s to be a string, got %v", column.Name, element)
+					}
+				case exportColumnTime:
+					// logical type TIMESTAMP adjusted to UTC in nanoseconds
+					expected := map[int16]interface{}{8: map[int16]interface{}{1: true, 2: map[int16]interface{}{3: map[int16]interface{}{}}}}
+					if !reflect.DeepEqual(element[10], expected) {
+						t.Errorf("expected column %s to be a timestamp in nanoseconds, got %v", column.Name, element)
+					}
+				}
+			}
+
+			for i, column := range parquetTestColumns {
+				if len(file.values[i]) != len(test.rows) {
+					t.Fatalf("expected %d values of column %s, got %d", len(test.rows), column.Name, len(file.values[i]))
+				}
+
+				for j, row := range test.rows {
+					expected := row[i]
+					if column.Type == exportColumnTime {
+						expected = nil
+						if !row[i].(time.Time).IsZero() {
+							expected = row[i].(time.Time).UnixNano()
+						}
+					}
+
+					if file.values[i][j] != expected {
+						t.Fatalf("expected %v in row %d of column %s, got %v", expected, j, column.Name, file.values[i][j])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	InstanceSortByCreationTime     string = "creation_time"
+	InstanceSortByLastActivityTime string = "last_activity_time"
+	InstanceSortByTerminationTime  string = "termination_time"
+	InstanceSortByInstanceID       string = "instance_id"
+	InstanceSortByZone             string = "zone"
+	InstanceSortByClientUser       string = "client_user"
+	InstanceSortByClientHostname   string = "client_hostname"
+
+	TransferSortByFileOpenTime  string = "file_open_time"
+	TransferSortByFileCloseTime string = "file_close_time"
+	TransferSortByFilePath      string = "file_path"
+	TransferSortByFileSize      string = "file_size"
+	TransferSortByTransferSize  string = "transfer_size"
+)
+
+// sortableTimeFormat formats time so its string form sorts in time order
+const sortableTimeFormat string = "2006-01-02T15:04:05.000000000Z"
+
+func sortableTime(t time.Time) string {
+	return t.UTC().Format(sortableTimeFormat)
+}
+
+// sortableInt64 formats non-negative numbers so their string form sorts in numeric order
+func sortableInt64(v int64) string {
+	return fmt.Sprintf("%020d", v)
+}
+
+// pageItem is an item to be sorted and paginated with a sort key and a unique ID
+type pageItem struct {
+	Key   string
+	ID    string
+	Index int
+}
+
+// paginate sorts items by key and ID, and returns indices of items in the requested page
+// with a cursor for the next page, the cursor is empty if there are no more items
+func paginate(items []pageItem, order string, limit int, cursor string) ([]int, string, error) {
+	descending := false
+	switch order {
+	case "", types.SortOrderAscending:
+	case types.SortOrderDescending:
+		descending = true
+	default:
+		return nil, "", fmt.Errorf("unknown sort order %s", order)
+	}
+
+	less := func(a pageItem, b pageItem) bool {
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.ID < b.ID
+	}
+
+	sort.SliceStable(items, func(i int, j int) bool {
+		if descending {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+
+	start := 0
+	if len(cursor) > 0 {
+		cursorItem, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// the cursor is the last item of the previous page, so the page starts right after it
+		// even if that item was removed in the meantime
+		start = sort.Search(len(items), func(i int) bool {
+			if descending {
+				return less(items[i], cursorItem)
+			}
+			return less(cursorItem, items[i])
+		})
+	}
+
+	end := len(items)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	indices := []int{}
+	for i := start; i < end; i++ {
+		indices = append(indices, items[i].Index)
+	}
+
+	nextCursor := ""
+	if end < len(items) && end > start {
+		nextCursor = encodeCursor(items[end-1])
+	}
+
+	return indices, nextCursor, nil
+}
+
+func encodeCursor(item pageItem) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(item.Key + "\x00" + item.ID))
+}
+
+func decodeCursor(cursor string) (pageItem, error) {
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageItem{}, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(cursorBytes), "\x00", 2)
+	if len(parts) != 2 {
+		return pageItem{}, fmt.Errorf("invalid cursor")
+	}
+
+	return pageItem{
+		Key: parts[0],
+		ID:  parts[1],
+	}, nil
+}
+
+// matchInstance checks if the instance satisfies the query filters
+func matchInstance(query *types.InstanceQuery, instance *types.ReportInstance) bool {
+	if len(query.Zone) > 0 && query.Zone != instance.Zone {
+		return false
+	}
+
+	if len(query.ClientUser) > 0 && query.ClientUser != instance.ClientUser {
+		return false
+	}
+
+	if len(query.ProxyUser) > 0 && query.ProxyUser != instance.ProxyUser {
+		return false
+	}
+
+	if len(query.ClientHostname) > 0 && query.ClientHostname != instance.ClientHostname {
+		return false
+	}
+
+	if len(query.ClientHostIP) > 0 && query.ClientHostIP != instance.ClientHostIP {
+		return false
+	}
+
+	if len(query.PoolAddress) > 0 && query.PoolAddress != instance.PoolAddress {
+		return false
+	}
+
+	if query.Terminated != nil && *query.Terminated != instance.Terminated {
+		return false
+	}
+
+	if len(query.State) > 0 && query.State != instance.GetState() {
+		return false
+	}
+
+	if !query.CreatedAfter.IsZero() && instance.CreationTime.Before(query.CreatedAfter) {
+		return false
+	}
+
+	if !query.CreatedBefore.IsZero() && !instance.CreationTime.Before(query.CreatedBefore) {
+		return false
+	}
+
+	if !query.LastActivityAfter.IsZero() && instance.LastActivityTime.Before(query.LastActivityAfter) {
+		return false
+	}
+
+	if !query.LastActivityBefore.IsZero() && !instance.LastActivityTime.Before(query.LastActivityBefore) {
+		return false
+	}
+
+	return true
+}
+
+// instanceSortKey returns a sort key of the instance
+func instanceSortKey(sortBy string, instance *types.ReportInstance) (string, error) {
+	switch sortBy {
+	case "", InstanceSortByCreationTime:
+		return sortableTime(instance.CreationTime), nil
+	case InstanceSortByLastActivityTime:
+		return sortableTime(instance.LastActivityTime), nil
+	case InstanceSortByTerminationTime:
+		return sortableTime(instance.TerminationTime), nil
+	case InstanceSortByInstanceID:
+		return instance.InstanceID, nil
+	case InstanceSortByZone:
+		return instance.Zone, nil
+	case InstanceSortByClientUser:
+		return instance.ClientUser, nil
+	case InstanceSortByClientHostname:
+		return instance.ClientHostname, nil
+	default:
+		return "", fmt.Errorf("unknown sort key %s", sortBy)
+	}
+}
+
+// QueryInstances filters, sorts and paginates instances
+// It returns instances in the page and a cursor for the next page
+func QueryInstances(query *types.InstanceQuery, instances []types.ReportInstance) ([]types.ReportInstance, string, error) {
+	items := []pageItem{}
+	for idx := range instances {
+		instance := &instances[idx]
+		if !matchInstance(query, instance) {
+			continue
+		}
+
+		key, err := instanceSortKey(query.SortBy, instance)
+		if err != nil {
+			return nil, "", err
+		}
+
+		items = append(items, pageItem{
+			Key:   key,
+			ID:    instance.InstanceID,
+			Index: idx,
+		})
+	}
+
+	indices, nextCursor, err := paginate(items, query.SortOrder, query.Limit, query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := []types.ReportInstance{}
+	for _, idx := range indices {
+		result = append(result, instances[idx])
+	}
+
+	return result, nextCursor, nil
+}
+
+// matchTransfer checks if the transfer and the instance that made it satisfy the query filters
+func matchTransfer(query *types.TransferQuery, instance *types.ReportInstance, transfer *types.ReportFileTransfer) bool {
+	if len(query.InstanceID) > 0 && query.InstanceID != transfer.InstanceID {
+		return false
+	}
+
+	if len(query.Zone) > 0 && query.Zone != instance.Zone {
+		return false
+	}
+
+	if len(query.ClientUser) > 0 && query.ClientUser != instance.ClientUser {
+		return false
+	}
+
+	if len(query.ClientHostname) > 0 && query.ClientHostname != instance.ClientHostname {
+		return false
+	}
+
+	if len(query.ClientHostIP) > 0 && query.ClientHostIP != instance.ClientHostIP {
+		return false
+	}
+
+	if len(query.FilePath) > 0 && query.FilePath != transfer.FilePath {
+		return false
+	}
+
+	if len(query.FilePathPrefix) > 0 && !strings.HasPrefix(transfer.FilePath, query.FilePathPrefix) {
+		return false
+	}
+
+	if len(query.FilePathGlob) > 0 {
+		matched, err := path.Match(query.FilePathGlob, transfer.FilePath)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	if len(query.FileOpenMode) > 0 && query.FileOpenMode != transfer.FileOpenMode {
+		return false
+	}
+
+	if query.MinFileSize >= 0 && transfer.FileSize < query.MinFileSize {
+		return false
+	}
+
+	if query.MaxFileSize >= 0 && transfer.FileSize > query.MaxFileSize {
+		return false
+	}
+
+	if query.MinTransferSize >= 0 && transfer.TransferSize < query.MinTransferSize {
+		return false
+	}
+
+	if query.MaxTransferSize >= 0 && transfer.TransferSize > query.MaxTransferSize {
+		return false
+	}
+
+	if query.SequentialAccess != nil && *query.SequentialAccess != transfer.SequentialAccess {
+		return false
+	}
+
+	if !query.OpenedAfter.IsZero() && transfer.FileOpenTime.Before(query.OpenedAfter) {
+		return false
+	}
+
+	if !query.OpenedBefore.IsZero() && !transfer.FileOpenTime.Before(query.OpenedBefore) {
+		return false
+	}
+
+	if !query.ClosedAfter.IsZero() && transfer.FileCloseTime.Before(query.ClosedAfter) {
+		return false
+	}
+
+	if !query.ClosedBefore.IsZero() && !transfer.FileCloseTime.Before(query.ClosedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// transferSortKey returns a sort key of the transfer
+func transferSortKey(sortBy string, transfer *types.ReportFileTransfer) (string, error) {
+	switch sortBy {
+	case "", TransferSortByFileOpenTime:
+		return sortableTime(transfer.FileOpenTime), nil
+	case TransferSortByFileCloseTime:
+		return sortableTime(transfer.FileCloseTime), nil
+	case TransferSortByFilePath:
+		return transfer.FilePath, nil
+	case TransferSortByFileSize:
+		return sortableInt64(transfer.FileSize), nil
+	case TransferSortByTransferSize:
+		return sortableInt64(transfer.TransferSize), nil
+	default:
+		return "", fmt.Errorf("unknown sort key %s", sortBy)
+	}
+}
+
+// transferID makes an ID of a transfer from its instance and its sequence number
+// Sequence numbers are stored with transfers, so the ID stays the same when other transfers are removed
+func transferID(instanceID string, sequence int64) string {
+	return fmt.Sprintf("%s/%d", instanceID, sequence)
+}
+
+// QueryFileTransfers filters, sorts and paginates file transfers in storage
+// It returns transfers in the page and a cursor for the next page
+func QueryFileTransfers(query *types.TransferQuery, storage Storage) ([]types.ReportFileTransfer, string, error) {
+	if len(query.FilePathGlob) > 0 {
+		_, err := path.Match(query.FilePathGlob, "")
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid file path glob %s", query.FilePathGlob)
+		}
+	}
+
+	instances := storage.ListInstances()
+	if len(query.InstanceID) > 0 {
+		instance, ok := storage.GetInstance(query.InstanceID)
+		if !ok {
+			return []types.ReportFileTransfer{}, "", nil
+		}
+		instances = []types.ReportInstance{instance}
+	}
+
+	transfers := []types.ReportFileTransfer{}
+	items := []pageItem{}
+	for idx := range instances {
+		instance := &instances[idx]
+		for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+			if !matchTransfer(query, instance, &transfer) {
+				continue
+			}
+
+			key, err := transferSortKey(query.SortBy, &transfer)
+			if err != nil {
+				return nil, "", err
+			}
+
+			// sequence numbers are padded so ties are broken in sequence order
+			items = append(items, pageItem{
+				Key:   key,
+				ID:    instance.InstanceID + "/" + sortableInt64(transfer.Sequence),
+				Index: len(transfers),
+			})
+			transfers = append(transfers, transfer)
+		}
+	}
+
+	indices, nextCursor, err := paginate(items, query.SortOrder, query.Limit, query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := []types.ReportFileTransfer{}
+	for _, idx := range indices {
+		result = append(result, transfers[idx])
+	}
+
+	return result, nextCursor, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+var queryTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// newQueryTestStorage makes a storage with transfers of two instances
+// a/2 and b/1 are opened at the same time, so ties are broken by transfer IDs
+func newQueryTestStorage(t *testing.T) *MemoryStorage {
+	storage := NewMemoryStorage()
+
+	instances := []types.ReportInstance{
+		{InstanceID: "a", Zone: "z1", ClientUser: "alice", ClientHostname: "h1", CreationTime: queryTestTime},
+		{InstanceID: "b", Zone: "z2", ClientUser: "bob", ClientHostname: "h2", CreationTime: queryTestTime.Add(time.Hour), Terminated: true},
+	}
+
+	for _, instance := range instances {
+		err := storage.AddInstance(instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transfers := []types.ReportFileTransfer{
+		{InstanceID: "a", FilePath: "/z1/home/alice/data/x.txt", FileSize: 100, TransferSize: 100, FileOpenMode: "r", SequentialAccess: true, FileOpenTime: queryTestTime},
+		{InstanceID: "a", FilePath: "/z1/home/alice/data/y.bin", FileSize: 5000, TransferSize: 200, FileOpenMode: "w", FileOpenTime: queryTestTime.Add(10 * time.Minute)},
+		{InstanceID: "a", FilePath: "/z1/home/alice/z.txt", FileSize: 300, TransferSize: 300, FileOpenMode: "r", SequentialAccess: true, FileOpenTime: queryTestTime.Add(20 * time.Minute)},
+		{InstanceID: "b", FilePath: "/z2/home/bob/x.txt", FileSize: 100, TransferSize: 50, FileOpenMode: "r", SequentialAccess: true, FileOpenTime: queryTestTime.Add(10 * time.Minute)},
+		{InstanceID: "b", FilePath: "/z2/home/bob/w.txt", FileSize: 10, TransferSize: 0, FileOpenMode: "w", FileOpenTime: queryTestTime.Add(30 * time.Minute)},
+	}
+
+	for _, transfer := range transfers {
+		transfer.FileCloseTime = transfer.FileOpenTime.Add(time.Minute)
+		err := storage.AddFileTransfer(transfer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return storage
+}
+
+func queryTestTransferIDs(transfers []types.ReportFileTransfer) []string {
+	ids := []string{}
+	for _, transfer := range transfers {
+		ids = append(ids, transferID(transfer.InstanceID, transfer.Sequence))
+	}
+	return ids
+}
+
+func TestQueryFileTransfersFilter(t *testing.T) {
+	sequential := true
+
+	tests := []struct {
+		name     string
+		query    func(query *types.TransferQuery)
+		expected []string
+	}{
+		{"all", func(query *types.TransferQuery) {}, []string{"a/1", "a/2", "b/1", "a/3", "b/2"}},
+		{"instance", func(query *types.TransferQuery) { query.InstanceID = "b" }, []string{"b/1", "b/2"}},
+		{"unknown instance", func(query *types.TransferQuery) { query.InstanceID = "c" }, []string{}},
+		{"zone", func(query *types.TransferQuery) { query.Zone = "z1" }, []string{"a/1", "a/2", "a/3"}},
+		{"client user", func(query *types.TransferQuery) { query.ClientUser = "bob" }, []string{"b/1", "b/2"}},
+		{"client hostname", func(query *types.TransferQuery) { query.ClientHostname = "h1" }, []string{"a/1", "a/2", "a/3"}},
+		{"file path", func(query *types.TransferQuery) { query.FilePath = "/z1/home/alice/z.txt" }, []string{"a/3"}},
+		{"file path prefix", func(query *types.TransferQuery) { query.FilePathPrefix = "/z1/home/alice/data/" }, []string{"a/1", "a/2"}},
+		{"file path glob", func(query *types.TransferQuery) { query.FilePathGlob = "/*/home/*/*.txt" }, []string{"b/1", "a/3", "b/2"}},
+		{"open mode", func(query *types.TransferQuery) { query.FileOpenMode = "w" }, []string{"a/2", "b/2"}},
+		{"min file size", func(query *types.TransferQuery) { query.MinFileSize = 300 }, []string{"a/2", "a/3"}},
+		{"max file size", func(query *types.TransferQuery) { query.MaxFileSize = 100 }, []string{"a/1", "b/1", "b/2"}},
+		{"transfer size range", func(query *types.TransferQuery) {
+			query.MinTransferSize = 50
+			query.MaxTransferSize = 200
+		}, []string{"a/1", "a/2", "b/1"}},
+		{"zero transfer size", func(query *types.TransferQuery) { query.MaxTransferSize = 0 }, []string{"b/2"}},
+		{"sequential access", func(query *types.TransferQuery) { query.SequentialAccess = &sequential }, []string{"a/1", "b/1", "a/3"}},
+		{"opened after is inclusive", func(query *types.TransferQuery) { query.OpenedAfter = queryTestTime.Add(20 * time.Minute) }, []string{"a/3", "b/2"}},
+		{"opened before is exclusive", func(query *types.TransferQuery) { query.OpenedBefore = queryTestTime.Add(10 * time.Minute) }, []string{"a/1"}},
+		{"closed range", func(query *types.TransferQuery) {
+			query.ClosedAfter = queryTestTime.Add(11 * time.Minute)
+			query.ClosedBefore = queryTestTime.Add(31 * time.Minute)
+		}, []string{"a/2", "b/1", "a/3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newQueryTestStorage(t)
+
+			query := types.NewTransferQuery()
+			test.query(query)
+
+			transfers, cursor, err := QueryFileTransfers(query, storage)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cursor != "" {
+				t.Errorf("expected no cursor without a limit, got %s", cursor)
+			}
+
+			ids := queryTestTransferIDs(transfers)
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestQueryFileTransfersPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		sortBy   string
+		order    string
+		limit    int
+		expected []string
+	}{
+		{"open time", "", "", 2, []string{"a/1", "a/2", "b/1", "a/3", "b/2"}},
+		{"open time descending", TransferSortByFileOpenTime, types.SortOrderDescending, 2, []string{"b/2", "a/3", "b/1", "a/2", "a/1"}},
+		{"file size ties", TransferSortByFileSize, types.SortOrderAscending, 1, []string{"b/2", "a/1", "b/1", "a/3", "a/2"}},
+		{"file path", TransferSortByFilePath, "", 3, []string{"a/1", "a/2", "a/3", "b/2", "b/1"}},
+		{"transfer size descending", TransferSortByTransferSize, types.SortOrderDescending, 4, []string{"a/3", "a/2", "a/1", "b/1", "b/2"}},
+		{"one page", "", "", 5, []string{"a/1", "a/2", "b/1", "a/3", "b/2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newQueryTestStorage(t)
+
+			ids := []string{}
+			cursor := ""
+			for page := 0; ; page++ {
+				if page > len(test.expected) {
+					t.Fatalf("pagination does not end, got %v", ids)
+				}
+
+				query := types.NewTransferQuery()
+				query.SortBy = test.sortBy
+				query.SortOrder = test.order
+				query.Limit = test.limit
+				query.Cursor = cursor
+
+				transfers, nextCursor, err := QueryFileTransfers(query, storage)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(transfers) > test.limit {
+					t.Fatalf("expected at most %d transfers in a page, got %d", test.limit, len(transfers))
+				}
+
+				ids = append(ids, queryTestTransferIDs(transfers)...)
+				if nextCursor == "" {
+					break
+				}
+				cursor = nextCursor
+			}
+
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+// TestQueryFileTransfersCursorAfterRemoval checks that the next page starts right after the last transfer of the previous page
+// when that transfer was removed in the meantime, and that other transfers keep their IDs
+func TestQueryFileTransfersCursorAfterRemoval(t *testing.T) {
+	storage := newQueryTestStorage(t)
+
+	query := types.NewTransferQuery()
+	query.Limit = 2
+
+	transfers, cursor, err := QueryFileTransfers(query, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := queryTestTransferIDs(transfers); !reflect.DeepEqual(ids, []string{"a/1", "a/2"}) {
+		t.Fatalf("expected the first page [a/1 a/2], got %v", ids)
+	}
+
+	// remove the oldest transfers of instance a, like retention does
+	storage.FileTransfers["a"] = storage.FileTransfers["a"][2:]
+
+	query.Cursor = cursor
+	transfers, _, err = QueryFileTransfers(query, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := queryTestTransferIDs(transfers); !reflect.DeepEqual(ids, []string{"b/1", "a/3"}) {
+		t.Errorf("expected the second page [b/1 a/3], got %v", ids)
+	}
+}
+
+func TestQueryFileTransfersInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query func(query *types.TransferQuery)
+	}{
+		{"unknown sort key", func(query *types.TransferQuery) { query.SortBy = "size" }},
+		{"unknown sort order", func(query *types.TransferQuery) { query.SortOrder = "up" }},
+		{"invalid cursor", func(query *types.TransferQuery) { query.Cursor = "!!!" }},
+		{"cursor without an ID", func(query *types.TransferQuery) { query.Cursor = "YWJj" }},
+		{"invalid glob", func(query *types.TransferQuery) { query.FilePathGlob = "/z1/[" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newQueryTestStorage(t)
+
+			query := types.NewTransferQuery()
+			test.query(query)
+
+			_, _, err := QueryFileTransfers(query, storage)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestQueryInstances(t *testing.T) {
+	terminated := true
+	storage := newQueryTestStorage(t)
+	instances := storage.ListInstances()
+
+	tests := []struct {
+		name     string
+		query    types.InstanceQuery
+		expected []string
+	}{
+		{"all", types.InstanceQuery{}, []string{"a", "b"}},
+		{"descending", types.InstanceQuery{SortOrder: types.SortOrderDescending}, []string{"b", "a"}},
+		{"zone", types.InstanceQuery{Zone: "z2"}, []string{"b"}},
+		{"client user", types.InstanceQuery{ClientUser: "alice"}, []string{"a"}},
+		{"terminated", types.InstanceQuery{Terminated: &terminated}, []string{"b"}},
+		{"created after is inclusive", types.InstanceQuery{CreatedAfter: queryTestTime.Add(time.Hour)}, []string{"b"}},
+		{"created before is exclusive", types.InstanceQuery{CreatedBefore: queryTestTime.Add(time.Hour)}, []string{"a"}},
+		{"first page", types.InstanceQuery{SortBy: InstanceSortByClientHostname, Limit: 1}, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _, err := QueryInstances(&test.query, instances)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []string{}
+			for _, instance := range result {
+				ids = append(ids, instance.InstanceID)
+			}
+
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
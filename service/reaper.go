@@ -0,0 +1,160 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	InstanceReaperPeriodMax time.Duration = 30 * time.Second
+	InstanceReaperPeriodMin time.Duration = 1 * time.Second
+)
+
+// InstanceReaper periodically moves instances without recent heartbeats or transfers to stale or lost state
+type InstanceReaper struct {
+	service       *MonitorService
+	staleTimeout  time.Duration
+	lostTimeout   time.Duration
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// NewInstanceReaper creates an instance reaper
+func NewInstanceReaper(service *MonitorService) *InstanceReaper {
+	return &InstanceReaper{
+		service:       service,
+		staleTimeout:  service.Config.InstanceStaleTimeout,
+		lostTimeout:   service.Config.InstanceLostTimeout,
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+}
+
+// period returns how often instances are checked, a half of the shortest timeout
+func (reaper *InstanceReaper) period() time.Duration {
+	period := InstanceReaperPeriodMax
+	for _, timeout := range []time.Duration{reaper.staleTimeout, reaper.lostTimeout} {
+		if timeout > 0 && timeout/2 < period {
+			period = timeout / 2
+		}
+	}
+
+	if period < InstanceReaperPeriodMin {
+		period = InstanceReaperPeriodMin
+	}
+	return period
+}
+
+// Init starts the reaper
+func (reaper *InstanceReaper) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "InstanceReaper.Init",
+	})
+
+	logger.Infof("Starting the instance reaper (stale after %s, lost after %s)", reaper.staleTimeout, reaper.lostTimeout)
+
+	reaper.waitGroup.Add(1)
+	go reaper.reapLoop()
+
+	return nil
+}
+
+// Destroy stops the reaper
+func (reaper *InstanceReaper) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "InstanceReaper.Destroy",
+	})
+
+	logger.Info("Stopping the instance reaper")
+
+	close(reaper.terminateChan)
+	reaper.waitGroup.Wait()
+}
+
+func (reaper *InstanceReaper) reapLoop() {
+	defer reaper.waitGroup.Done()
+
+	ticker := time.NewTicker(reaper.period())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-reaper.terminateChan:
+			return
+		case <-ticker.C:
+			reaper.reap(time.Now().UTC())
+		}
+	}
+}
+
+// nextState returns the state the instance should be in at the given time
+func (reaper *InstanceReaper) nextState(instance *types.ReportInstance, now time.Time) string {
+	state := instance.GetState()
+	if state == types.InstanceStateTerminated {
+		return state
+	}
+
+	idle := now.Sub(instance.LastActivityTime)
+	if reaper.lostTimeout > 0 && idle >= reaper.lostTimeout {
+		return types.InstanceStateLost
+	}
+
+	if reaper.staleTimeout > 0 && idle >= reaper.staleTimeout && state == types.InstanceStateActive {
+		return types.InstanceStateStale
+	}
+
+	return state
+}
+
+// reap checks all instances and changes states of idle ones
+func (reaper *InstanceReaper) reap(now time.Time) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "InstanceReaper.reap",
+	})
+
+	svc := reaper.service
+	for _, instance := range svc.Storage.ListInstances() {
+		state := reaper.nextState(&instance, now)
+		if state == instance.GetState() {
+			continue
+		}
+
+		// a heartbeat or a transfer may come after the instance is listed, the state is not changed then
+		instanceID := instance.InstanceID
+		lastActivityTime := instance.LastActivityTime
+		entry := &JournalEntry{
+			Operation:        JournalOperationSetInstanceState,
+			InstanceID:       instanceID,
+			State:            state,
+			LastActivityTime: &lastActivityTime,
+		}
+
+		err := svc.commit(entry, func() error {
+			return svc.Storage.SetInstanceState(instanceID, state, lastActivityTime)
+		})
+		if err != nil {
+			if _, ok := err.(*InstanceActivityChangedError); ok {
+				logger.Debugf("Instance %s had activity, not setting it %s", instanceID, state)
+				continue
+			}
+
+			logger.WithError(err).Errorf("Could not set instance %s %s", instanceID, state)
+			continue
+		}
+
+		logger.Infof("Instance %s is %s, last activity at %s", instanceID, state, instance.LastActivityTime.Format(time.RFC3339))
+
+		switch state {
+		case types.InstanceStateStale:
+			svc.publishInstanceEvent(types.EventTypeInstanceStale, instanceID)
+		case types.InstanceStateLost:
+			svc.publishInstanceEvent(types.EventTypeInstanceLost, instanceID)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/gorilla/mux"
+)
+
+var reaperTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// reaperTestStorage calls listed after instances are listed, which is before the reaper changes their states
+type reaperTestStorage struct {
+	Storage
+	listed func()
+}
+
+func (storage *reaperTestStorage) ListInstances() []types.ReportInstance {
+	instances := storage.Storage.ListInstances()
+	if storage.listed != nil {
+		storage.listed()
+	}
+	return instances
+}
+
+func newReaperTestService(storage Storage) *MonitorService {
+	svc := &MonitorService{
+		Config: &Config{
+			InstanceStaleTimeout: 10 * time.Minute,
+			InstanceLostTimeout:  time.Hour,
+		},
+		Storage: storage,
+		Events:  NewEventBroker(),
+	}
+
+	svc.Router = mux.NewRouter()
+	svc.Router.HandleFunc("/instances/{instance_id}", svc.terminateInstance).Methods("DELETE")
+	svc.Router.HandleFunc("/instances/{instance_id}/heartbeat", svc.heartbeat).Methods("POST")
+	return svc
+}
+
+// reaperTestAddInstance adds an instance with the last activity at the given time
+func reaperTestAddInstance(t *testing.T, svc *MonitorService, lastActivityTime time.Time) {
+	instance := types.ReportInstance{
+		InstanceID:       "a",
+		CreationTime:     lastActivityTime,
+		LastActivityTime: lastActivityTime,
+		State:            types.InstanceStateActive,
+	}
+
+	entry := &JournalEntry{
+		Operation: JournalOperationAddInstance,
+		Time:      lastActivityTime,
+		Instance:  &instance,
+		TakeOver:  true,
+	}
+
+	err := svc.commit(entry, func() error {
+		return svc.Storage.ClaimInstance(instance, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func reaperTestRequest(t *testing.T, svc *MonitorService, method string, path string) {
+	recorder := httptest.NewRecorder()
+	svc.Router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("expected status %d for %s %s, got %d - %s", http.StatusAccepted, method, path, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestInstanceReaperReap(t *testing.T) {
+	now := reaperTestTime.Add(2 * time.Hour)
+
+	tests := []struct {
+		name             string
+		lastActivityTime time.Time
+		// during is called after the reaper lists instances
+		during   func(t *testing.T, svc *MonitorService)
+		state    string
+		expected []string
+	}{
+		{"active", now.Add(-5 * time.Minute), nil, types.InstanceStateActive, []string{}},
+		{"stale", now.Add(-20 * time.Minute), nil, types.InstanceStateStale, []string{types.EventTypeInstanceStale}},
+		{"lost", now.Add(-90 * time.Minute), nil, types.InstanceStateLost, []string{types.EventTypeInstanceLost}},
+		{
+			name:             "heartbeat while reaping",
+			lastActivityTime: now.Add(-20 * time.Minute),
+			during: func(t *testing.T, svc *MonitorService) {
+				reaperTestRequest(t, svc, "POST", "/instances/a/heartbeat")
+			},
+			state:    types.InstanceStateActive,
+			expected: []string{},
+		},
+		{
+			name:             "terminated while reaping",
+			lastActivityTime: now.Add(-90 * time.Minute),
+			during: func(t *testing.T, svc *MonitorService) {
+				reaperTestRequest(t, svc, "DELETE", "/instances/a")
+			},
+			state:    types.InstanceStateTerminated,
+			expected: []string{types.EventTypeInstanceTerminated},
+		},
+	}
+
+	for _, test := range tests {
+		for _, storageType := range []string{StorageTypeMemory, StorageTypeBolt} {
+			t.Run(test.name+" in "+storageType, func(t *testing.T) {
+				dirPath := t.TempDir()
+
+				var storage Storage = NewMemoryStorage()
+				if storageType == StorageTypeBolt {
+					storage = NewBoltStorage(filepath.Join(dirPath, "monitor.db"))
+				}
+
+				err := storage.Init()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer storage.Destroy()
+
+				testStorage := &reaperTestStorage{Storage: storage}
+				svc := newReaperTestService(testStorage)
+
+				// state changes are replayed from the journal as they were made
+				if storageType == StorageTypeMemory {
+					svc.Journal = NewJournal(filepath.Join(dirPath, "journal"), 0, storage)
+					err = svc.Journal.Init()
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				reaperTestAddInstance(t, svc, test.lastActivityTime)
+
+				subscriber := svc.Events.Subscribe(&EventFilter{})
+				if test.during != nil {
+					testStorage.listed = func() {
+						testStorage.listed = nil
+						test.during(t, svc)
+					}
+				}
+
+				NewInstanceReaper(svc).reap(now)
+
+				instance, _ := storage.GetInstance("a")
+				if instance.GetState() != test.state {
+					t.Errorf("expected state %s, got %s", test.state, instance.GetState())
+				}
+
+				published := []string{}
+				for len(subscriber.Events) > 0 {
+					event := <-subscriber.Events
+					published = append(published, event.Type)
+				}
+
+				if !reflect.DeepEqual(published, test.expected) {
+					t.Errorf("expected events %v, got %v", test.expected, published)
+				}
+
+				if svc.Journal == nil {
+					return
+				}
+
+				// stop without a final snapshot, as if the service crashed
+				svc.Journal.logFile.Close()
+
+				replayedStorage := NewMemoryStorage()
+				replayedJournal := NewJournal(filepath.Join(dirPath, "journal"), 0, replayedStorage)
+				err = replayedJournal.Init()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer replayedJournal.Destroy()
+
+				replayed, _ := replayedStorage.GetInstance("a")
+				if !reflect.DeepEqual(replayed, instance) {
+					t.Errorf("expected replayed instance %+v, got %+v", instance, replayed)
+				}
+			})
+		}
+	}
+}
+
+// TestInstanceReaperConcurrentHeartbeat races heartbeats against reaps, an instance with a heartbeat must end up active
+func TestInstanceReaperConcurrentHeartbeat(t *testing.T) {
+	svc := newReaperTestService(NewMemoryStorage())
+	reaper := NewInstanceReaper(svc)
+
+	for i := 0; i < 200; i++ {
+		// heartbeats are at the current time, so the instance is not idle after one
+		now := time.Now().UTC()
+		reaperTestAddInstance(t, svc, now.Add(-20*time.Minute))
+
+		waitGroup := sync.WaitGroup{}
+		waitGroup.Add(2)
+		go func() {
+			defer waitGroup.Done()
+			reaper.reap(now)
+		}()
+		go func() {
+			defer waitGroup.Done()
+			reaperTestRequest(t, svc, "POST", "/instances/a/heartbeat")
+		}()
+		waitGroup.Wait()
+
+		instance, _ := svc.Storage.GetInstance("a")
+		if instance.GetState() != types.InstanceStateActive {
+			t.Fatalf("expected the instance active after a heartbeat, got %s at iteration %d", instance.GetState(), i)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// recommendationMinTransfers is the min number of observed transfers to evaluate a setting from
+	recommendationMinTransfers int = 10
+
+	recommendationSequentialFraction float64 = 0.7
+	recommendationRandomFraction     float64 = 0.5
+
+	// read-ahead of sequential reads covers this many blocks
+	recommendationReadAheadBlocks int64 = 8
+	recommendationReadAheadLimit  int64 = 64 * 1024 * 1024
+	// blocks up to this size are small, read-ahead is wasted for small random reads
+	recommendationSmallBlockSize int64 = 64 * 1024
+	recommendationBufferLimit    int64 = 1024 * 1024 * 1024
+	// files reopened later than this do not benefit from metadata caching
+	recommendationReopenIntervalLimit time.Duration = time.Hour
+)
+
+// nextPowerOfTwo returns the smallest power of two not less than v
+func nextPowerOfTwo(v int64) int64 {
+	var p int64 = 1
+	for p < v {
+		p *= 2
+	}
+	return p
+}
+
+func medianInt64(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return sorted[len(sorted)/2]
+}
+
+// formatSize formats bytes in binary units for recommendation reasons
+func formatSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	value := float64(size)
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", size, units[unit])
+	}
+
+	if value == float64(int64(value)) {
+		return fmt.Sprintf("%d %s", int64(value), units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// peakConcurrentTransfers returns the max number of transfers open at the same time, transfers without a close time are ignored
+func peakConcurrentTransfers(transfers []types.ReportFileTransfer) int64 {
+	type transferEvent struct {
+		Time  time.Time
+		Delta int64
+	}
+
+	events := []transferEvent{}
+	for _, transfer := range transfers {
+		if transfer.FileOpenTime.IsZero() || transfer.FileCloseTime.IsZero() {
+			continue
+		}
+
+		events = append(events, transferEvent{Time: transfer.FileOpenTime, Delta: 1})
+		events = append(events, transferEvent{Time: transfer.FileCloseTime, Delta: -1})
+	}
+
+	// a file closed at the same time another is opened does not overlap it
+	sort.Slice(events, func(i int, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		return events[i].Delta < events[j].Delta
+	})
+
+	var open, peak int64
+	for _, event := range events {
+		open += event.Delta
+		if open > peak {
+			peak = open
+		}
+	}
+
+	return peak
+}
+
+// reopenIntervals returns how long files stay closed before they are opened again
+func reopenIntervals(transfers []types.ReportFileTransfer) []time.Duration {
+	byPath := map[string][]types.ReportFileTransfer{}
+	for _, transfer := range transfers {
+		if transfer.FileOpenTime.IsZero() || transfer.FileCloseTime.IsZero() {
+			continue
+		}
+		byPath[transfer.FilePath] = append(byPath[transfer.FilePath], transfer)
+	}
+
+	intervals := []time.Duration{}
+	for _, pathTransfers := range byPath {
+		sort.Slice(pathTransfers, func(i int, j int) bool {
+			return pathTransfers[i].FileOpenTime.Before(pathTransfers[j].FileOpenTime)
+		})
+
+		for i := 1; i < len(pathTransfers); i++ {
+			interval := pathTransfers[i].FileOpenTime.Sub(pathTransfers[i-1].FileCloseTime)
+			if interval > 0 {
+				intervals = append(intervals, interval)
+			}
+		}
+	}
+
+	return intervals
+}
+
+// summarizeWorkload summarizes observed file transfers of an instance
+func summarizeWorkload(transfers []types.ReportFileTransfer) types.InstanceWorkload {
+	workload := types.InstanceWorkload{
+		TransferCount: int64(len(transfers)),
+	}
+
+	readBlockSizes := []int64{}
+	readSizes := []int64{}
+	writeSizes := []int64{}
+	var analyzedReads, sequentialReads, randomReads int64
+	for idx := range transfers {
+		transfer := &transfers[idx]
+
+		if isWriteMode(transfer.FileOpenMode) {
+			workload.WriteTransferCount++
+			writeSizes = append(writeSizes, transfer.TransferSize)
+		}
+
+		if !isReadMode(transfer.FileOpenMode) {
+			continue
+		}
+
+		workload.ReadTransferCount++
+		readSizes = append(readSizes, transfer.TransferSize)
+
+		access := analyzeTransferAccess(transfer)
+		switch access.Pattern {
+		case types.AccessPatternSequential:
+			sequentialReads++
+		case types.AccessPatternStrided, types.AccessPatternRandom:
+			randomReads++
+		default:
+			continue
+		}
+
+		analyzedReads++
+		for _, block := range access.Blocks {
+			readBlockSizes = append(readBlockSizes, block.Length)
+		}
+	}
+
+	if analyzedReads > 0 {
+		workload.SequentialReadFraction = float64(sequentialReads) / float64(analyzedReads)
+		workload.RandomReadFraction = float64(randomReads) / float64(analyzedReads)
+	}
+
+	workload.MedianReadBlockSize = medianInt64(readBlockSizes)
+	workload.MedianReadSize = medianInt64(readSizes)
+	workload.MedianWriteSize = medianInt64(writeSizes)
+	workload.PeakConcurrentTransfers = peakConcurrentTransfers(transfers)
+
+	intervals := reopenIntervals(transfers)
+	if len(intervals) >= recommendationMinTransfers {
+		intervalValues := make([]int64, len(intervals))
+		for i, interval := range intervals {
+			intervalValues[i] = int64(interval)
+		}
+		workload.MedianReopenInterval = time.Duration(medianInt64(intervalValues)).String()
+	}
+
+	return workload
+}
+
+// RecommendSettings recommends settings of the instance from its observed file transfers
+// Settings that the instance did not report are not evaluated
+func RecommendSettings(instance *types.ReportInstance, transfers []types.ReportFileTransfer) *types.InstanceRecommendations {
+	result := &types.InstanceRecommendations{
+		InstanceID:      instance.InstanceID,
+		Workload:        summarizeWorkload(transfers),
+		Recommendations: []types.Recommendation{},
+	}
+
+	workload := &result.Workload
+	if len(transfers) < recommendationMinTransfers {
+		result.Note = fmt.Sprintf("only %d transfers are observed, at least %d are needed", len(transfers), recommendationMinTransfers)
+		return result
+	}
+
+	readAheadMax := int64(instance.ReadAheadMax)
+	if readAheadMax > 0 && workload.ReadTransferCount >= int64(recommendationMinTransfers) && workload.MedianReadBlockSize > 0 {
+		if workload.SequentialReadFraction >= recommendationSequentialFraction {
+			// prefetch several blocks, but not beyond a typical file
+			recommended := nextPowerOfTwo(workload.MedianReadBlockSize * recommendationReadAheadBlocks)
+			if medianReadSize := nextPowerOfTwo(workload.MedianReadSize); medianReadSize < recommended {
+				recommended = medianReadSize
+			}
+			if recommended > recommendationReadAheadLimit {
+				recommended = recommendationReadAheadLimit
+			}
+
+			if readAheadMax < recommended {
+				result.Recommendations = append(result.Recommendations, types.Recommendation{
+					Setting:     types.SettingReadAheadMax,
+					Current:     strconv.FormatInt(readAheadMax, 10),
+					Recommended: strconv.FormatInt(recommended, 10),
+					Reason:      fmt.Sprintf("%.0f%% of reads are sequential with a median read of %s in blocks of %s, a read-ahead of %s prefetches only a few blocks", workload.SequentialReadFraction*100, formatSize(workload.MedianReadSize), formatSize(workload.MedianReadBlockSize), formatSize(readAheadMax)),
+				})
+			}
+		} else if workload.RandomReadFraction >= recommendationRandomFraction && workload.MedianReadBlockSize <= recommendationSmallBlockSize {
+			recommended := nextPowerOfTwo(workload.MedianReadBlockSize)
+			if readAheadMax > recommended*4 {
+				result.Recommendations = append(result.Recommendations, types.Recommendation{
+					Setting:     types.SettingReadAheadMax,
+					Current:     strconv.FormatInt(readAheadMax, 10),
+					Recommended: strconv.FormatInt(recommended, 10),
+					Reason:      fmt.Sprintf("%.0f%% of reads are random in blocks of %s on median, most of a read-ahead of %s is read but not used", workload.RandomReadFraction*100, formatSize(workload.MedianReadBlockSize), formatSize(readAheadMax)),
+				})
+			}
+		}
+	}
+
+	if instance.BufferSizeMax > 0 && workload.WriteTransferCount >= int64(recommendationMinTransfers) && workload.MedianWriteSize > instance.BufferSizeMax {
+		recommended := nextPowerOfTwo(workload.MedianWriteSize)
+		if recommended > recommendationBufferLimit {
+			recommended = recommendationBufferLimit
+		}
+
+		if recommended > instance.BufferSizeMax {
+			result.Recommendations = append(result.Recommendations, types.Recommendation{
+				Setting:     types.SettingBufferSizeMax,
+				Current:     strconv.FormatInt(instance.BufferSizeMax, 10),
+				Recommended: strconv.FormatInt(recommended, 10),
+				Reason:      fmt.Sprintf("the median write is %s, larger than the buffer of %s", formatSize(workload.MedianWriteSize), formatSize(instance.BufferSizeMax)),
+			})
+		}
+	}
+
+	connectionMax := int64(instance.ConnectionMax)
+	if connectionMax > 0 && workload.PeakConcurrentTransfers > 0 {
+		if workload.PeakConcurrentTransfers > connectionMax {
+			result.Recommendations = append(result.Recommendations, types.Recommendation{
+				Setting:     types.SettingConnectionMax,
+				Current:     strconv.FormatInt(connectionMax, 10),
+				Recommended: strconv.FormatInt(workload.PeakConcurrentTransfers, 10),
+				Reason:      fmt.Sprintf("up to %d files are open at the same time, more than %d connections", workload.PeakConcurrentTransfers, connectionMax),
+			})
+		} else if workload.PeakConcurrentTransfers*4 < connectionMax {
+			recommended := workload.PeakConcurrentTransfers * 2
+			result.Recommendations = append(result.Recommendations, types.Recommendation{
+				Setting:     types.SettingConnectionMax,
+				Current:     strconv.FormatInt(connectionMax, 10),
+				Recommended: strconv.FormatInt(recommended, 10),
+				Reason:      fmt.Sprintf("at most %d files are open at the same time, idle connections hold resources of the iRODS server", workload.PeakConcurrentTransfers),
+			})
+		}
+	}
+
+	if len(workload.MedianReopenInterval) > 0 && len(instance.MetadataCacheTimeout) > 0 {
+		current, err := time.ParseDuration(instance.MetadataCacheTimeout)
+		reopenInterval, _ := time.ParseDuration(workload.MedianReopenInterval)
+		if err == nil && current > 0 && reopenInterval > current && reopenInterval <= recommendationReopenIntervalLimit {
+			recommended := reopenInterval.Truncate(time.Minute) + time.Minute
+			result.Recommendations = append(result.Recommendations, types.Recommendation{
+				Setting:     types.SettingMetadataCacheTimeout,
+				Current:     instance.MetadataCacheTimeout,
+				Recommended: recommended.String(),
+				Reason:      fmt.Sprintf("files are opened again %s after they are closed on median, after their cached metadata expires in %s", reopenInterval.Round(time.Second), current),
+			})
+		}
+	}
+
+	return result
+}
+
+func (svc *MonitorService) getRecommendations(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getRecommendations",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	instanceID := mux.Vars(r)["instance_id"]
+
+	instance, ok := svc.Storage.GetInstance(instanceID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unable to find an instance for ID %s", instanceID)))
+		return
+	}
+
+	recommendations := RecommendSettings(&instance, svc.Storage.ListFileTransfersForInstance(instanceID))
+
+	responseJSON, err := json.Marshal(recommendations)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
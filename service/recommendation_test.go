@@ -0,0 +1,298 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+var recommendationTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// recommendationTestReads makes read transfers of different files one after another,
+// sequential reads have 8 blocks in order, random reads have 4 blocks out of order
+func recommendationTestReads(count int, random bool, blockSize int64) []types.ReportFileTransfer {
+	order := []int64{0, 1, 2, 3, 4, 5, 6, 7}
+	if random {
+		order = []int64{3, 0, 2, 1}
+	}
+
+	transfers := []types.ReportFileTransfer{}
+	for i := 0; i < count; i++ {
+		opened := recommendationTestTime.Add(time.Duration(i*10) * time.Minute)
+		transfer := types.ReportFileTransfer{
+			FilePath:      fmt.Sprintf("/zone/home/user/read_%t_%d", random, i),
+			FileSize:      8 * blockSize,
+			FileOpenMode:  "r",
+			FileOpenTime:  opened,
+			FileCloseTime: opened.Add(time.Second),
+		}
+
+		for j, block := range order {
+			transfer.TransferBlocks = append(transfer.TransferBlocks, types.FileBlock{
+				Offset:     block * blockSize,
+				Length:     blockSize,
+				AccessTime: opened.Add(time.Duration(j) * time.Millisecond),
+			})
+			transfer.TransferSize += blockSize
+		}
+
+		transfers = append(transfers, transfer)
+	}
+	return transfers
+}
+
+// recommendationTestWrites makes write transfers of different files one after another
+func recommendationTestWrites(count int, size int64) []types.ReportFileTransfer {
+	transfers := []types.ReportFileTransfer{}
+	for i := 0; i < count; i++ {
+		opened := recommendationTestTime.Add(time.Duration(i) * time.Hour).Add(time.Second)
+		transfers = append(transfers, types.ReportFileTransfer{
+			FilePath:      fmt.Sprintf("/zone/home/user/write_%d", i),
+			FileSize:      size,
+			FileOpenMode:  "w",
+			TransferSize:  size,
+			FileOpenTime:  opened,
+			FileCloseTime: opened.Add(time.Second),
+		})
+	}
+	return transfers
+}
+
+// recommendationTestConcurrent makes transfers without blocks that are open at the same time
+func recommendationTestConcurrent(count int) []types.ReportFileTransfer {
+	transfers := []types.ReportFileTransfer{}
+	for i := 0; i < count; i++ {
+		transfers = append(transfers, types.ReportFileTransfer{
+			FilePath:      fmt.Sprintf("/zone/home/user/concurrent_%d", i),
+			FileOpenMode:  "r",
+			FileOpenTime:  recommendationTestTime.Add(-time.Hour),
+			FileCloseTime: recommendationTestTime.Add(-time.Hour + time.Minute),
+		})
+	}
+	return transfers
+}
+
+// recommendationTestReopens makes transfers of a file that is opened again the interval after it is closed
+func recommendationTestReopens(count int, interval time.Duration) []types.ReportFileTransfer {
+	transfers := []types.ReportFileTransfer{}
+	opened := recommendationTestTime.Add(-48 * time.Hour)
+	for i := 0; i < count; i++ {
+		transfers = append(transfers, types.ReportFileTransfer{
+			FilePath:      "/zone/home/user/reopened",
+			FileOpenMode:  "r",
+			FileOpenTime:  opened,
+			FileCloseTime: opened.Add(time.Second),
+		})
+		opened = opened.Add(time.Second + interval)
+	}
+	return transfers
+}
+
+func recommendationTestJoin(transferLists ...[]types.ReportFileTransfer) []types.ReportFileTransfer {
+	transfers := []types.ReportFileTransfer{}
+	for _, transferList := range transferLists {
+		transfers = append(transfers, transferList...)
+	}
+	return transfers
+}
+
+func TestRecommendSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		instance  types.ReportInstance
+		transfers []types.ReportFileTransfer
+		// expected recommended values by setting
+		expected map[string]string
+	}{
+		{
+			name:      "too few transfers",
+			instance:  types.ReportInstance{ReadAheadMax: 4096, ConnectionMax: 100},
+			transfers: recommendationTestReads(recommendationMinTransfers-1, false, 64*1024),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "sequential reads with a small read-ahead",
+			instance:  types.ReportInstance{ReadAheadMax: 64 * 1024},
+			transfers: recommendationTestReads(10, false, 64*1024),
+			expected:  map[string]string{types.SettingReadAheadMax: "524288"},
+		},
+		{
+			name:      "read-ahead is limited",
+			instance:  types.ReportInstance{ReadAheadMax: 64 * 1024},
+			transfers: recommendationTestReads(10, false, 16*1024*1024),
+			expected:  map[string]string{types.SettingReadAheadMax: "67108864"},
+		},
+		{
+			name:      "sequential reads with a large read-ahead",
+			instance:  types.ReportInstance{ReadAheadMax: 512 * 1024},
+			transfers: recommendationTestReads(10, false, 64*1024),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "sequential reads at the threshold",
+			instance:  types.ReportInstance{ReadAheadMax: 64 * 1024},
+			transfers: recommendationTestJoin(recommendationTestReads(7, false, 64*1024), recommendationTestReads(3, true, 64*1024)),
+			expected:  map[string]string{types.SettingReadAheadMax: "524288"},
+		},
+		{
+			name:      "sequential reads below the threshold",
+			instance:  types.ReportInstance{ReadAheadMax: 64 * 1024},
+			transfers: recommendationTestJoin(recommendationTestReads(6, false, 64*1024), recommendationTestReads(4, true, 64*1024)),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "read-ahead is not reported",
+			instance:  types.ReportInstance{},
+			transfers: recommendationTestReads(10, false, 64*1024),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "small random reads with a large read-ahead",
+			instance:  types.ReportInstance{ReadAheadMax: 1024 * 1024},
+			transfers: recommendationTestReads(10, true, 4096),
+			expected:  map[string]string{types.SettingReadAheadMax: "4096"},
+		},
+		{
+			name:      "small random reads with a read-ahead of four blocks",
+			instance:  types.ReportInstance{ReadAheadMax: 4 * 4096},
+			transfers: recommendationTestReads(10, true, 4096),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "large random reads",
+			instance:  types.ReportInstance{ReadAheadMax: 1024 * 1024},
+			transfers: recommendationTestReads(10, true, 128*1024),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "writes larger than the buffer",
+			instance:  types.ReportInstance{BufferSizeMax: 1024 * 1024},
+			transfers: recommendationTestWrites(10, 3*1024*1024),
+			expected:  map[string]string{types.SettingBufferSizeMax: "4194304"},
+		},
+		{
+			name:      "buffer is limited",
+			instance:  types.ReportInstance{BufferSizeMax: 1024 * 1024 * 1024},
+			transfers: recommendationTestWrites(10, 3*1024*1024*1024),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "too few writes",
+			instance:  types.ReportInstance{BufferSizeMax: 1024 * 1024},
+			transfers: recommendationTestJoin(recommendationTestWrites(9, 3*1024*1024), recommendationTestReads(1, false, 4096)),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "more open files than connections",
+			instance:  types.ReportInstance{ConnectionMax: 2},
+			transfers: recommendationTestJoin(recommendationTestConcurrent(3), recommendationTestWrites(7, 1024)),
+			expected:  map[string]string{types.SettingConnectionMax: "3"},
+		},
+		{
+			name:      "idle connections",
+			instance:  types.ReportInstance{ConnectionMax: 20},
+			transfers: recommendationTestJoin(recommendationTestConcurrent(3), recommendationTestWrites(7, 1024)),
+			expected:  map[string]string{types.SettingConnectionMax: "6"},
+		},
+		{
+			name:      "connections at four times the open files",
+			instance:  types.ReportInstance{ConnectionMax: 12},
+			transfers: recommendationTestJoin(recommendationTestConcurrent(3), recommendationTestWrites(7, 1024)),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "files reopened after metadata expires",
+			instance:  types.ReportInstance{MetadataCacheTimeout: "1m"},
+			transfers: recommendationTestReopens(11, 5*time.Minute+30*time.Second),
+			expected:  map[string]string{types.SettingMetadataCacheTimeout: "6m0s"},
+		},
+		{
+			name:      "files reopened before metadata expires",
+			instance:  types.ReportInstance{MetadataCacheTimeout: "10m"},
+			transfers: recommendationTestReopens(11, 5*time.Minute),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "files reopened much later",
+			instance:  types.ReportInstance{MetadataCacheTimeout: "1m"},
+			transfers: recommendationTestReopens(11, 2*time.Hour),
+			expected:  map[string]string{},
+		},
+		{
+			name:      "too few reopens",
+			instance:  types.ReportInstance{MetadataCacheTimeout: "1m"},
+			transfers: recommendationTestReopens(10, 5*time.Minute),
+			expected:  map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for idx := range test.transfers {
+				test.transfers[idx].InstanceID = "a"
+			}
+			test.instance.InstanceID = "a"
+
+			result := RecommendSettings(&test.instance, test.transfers)
+
+			recommended := map[string]string{}
+			for _, recommendation := range result.Recommendations {
+				if _, ok := recommended[recommendation.Setting]; ok {
+					t.Errorf("expected one recommendation for %s", recommendation.Setting)
+				}
+
+				if len(recommendation.Reason) == 0 {
+					t.Errorf("expected a reason for %s", recommendation.Setting)
+				}
+				recommended[recommendation.Setting] = recommendation.Recommended
+			}
+
+			if !reflect.DeepEqual(recommended, test.expected) {
+				t.Errorf("expected %v, got %v (workload %+v)", test.expected, recommended, result.Workload)
+			}
+
+			if len(test.transfers) < recommendationMinTransfers && len(result.Note) == 0 {
+				t.Error("expected a note for too few transfers")
+			}
+		})
+	}
+}
+
+func TestPeakConcurrentTransfers(t *testing.T) {
+	at := func(minutes int) time.Time {
+		return recommendationTestTime.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	tests := []struct {
+		name      string
+		intervals [][2]int
+		expected  int64
+	}{
+		{"none", [][2]int{}, 0},
+		{"one after another", [][2]int{{0, 1}, {1, 2}, {2, 3}}, 1},
+		{"overlapping", [][2]int{{0, 10}, {1, 2}, {3, 4}, {5, 11}}, 2},
+		{"nested", [][2]int{{0, 10}, {1, 9}, {2, 8}}, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transfers := []types.ReportFileTransfer{}
+			for _, interval := range test.intervals {
+				transfers = append(transfers, types.ReportFileTransfer{
+					FileOpenTime:  at(interval[0]),
+					FileCloseTime: at(interval[1]),
+				})
+			}
+
+			// transfers still open are not counted
+			transfers = append(transfers, types.ReportFileTransfer{FileOpenTime: at(0)})
+
+			if peak := peakConcurrentTransfers(transfers); peak != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, peak)
+			}
+		})
+	}
+}
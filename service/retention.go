@@ -0,0 +1,205 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetentionPolicy decides which instance and transfer data are removed
+type RetentionPolicy struct {
+	// InstanceTTL is how long terminated or lost instances are kept after their last activity, kept forever if zero
+	InstanceTTL time.Duration `json:"instance_ttl"`
+	// TransferTTL is how long transfers are kept after they are closed, kept forever if zero
+	TransferTTL time.Duration `json:"transfer_ttl"`
+	// MaxTransfersPerInstance is the max number of the latest transfers kept per instance, unlimited if zero
+	MaxTransfersPerInstance int `json:"max_transfers_per_instance"`
+	// rollups of removed transfers are kept for these durations from their time buckets, kept forever if zero
+	HourlyRollupTTL time.Duration `json:"hourly_rollup_ttl"`
+	DailyRollupTTL  time.Duration `json:"daily_rollup_ttl"`
+}
+
+// RetentionResult is the number of records removed by a retention run
+type RetentionResult struct {
+	InstancesRemoved int
+	TransfersRemoved int
+	RollupsRemoved   int
+}
+
+// NewRetentionPolicy creates a retention policy from config
+func NewRetentionPolicy(config *Config) *RetentionPolicy {
+	return &RetentionPolicy{
+		InstanceTTL:             config.RetentionInstanceTTL,
+		TransferTTL:             config.RetentionTransferTTL,
+		MaxTransfersPerInstance: config.RetentionMaxTransfersPerInstance,
+		HourlyRollupTTL:         config.RetentionHourlyRollupTTL,
+		DailyRollupTTL:          config.RetentionDailyRollupTTL,
+	}
+}
+
+// InstanceExpired checks if the instance and its transfers should be removed
+// Instances that are not terminated or lost are always kept, however old they are
+func (policy *RetentionPolicy) InstanceExpired(instance *types.ReportInstance, now time.Time) bool {
+	if policy.InstanceTTL <= 0 {
+		return false
+	}
+
+	state := instance.GetState()
+	if state != types.InstanceStateTerminated && state != types.InstanceStateLost {
+		return false
+	}
+
+	endTime := instance.LastActivityTime
+	if instance.TerminationTime.After(endTime) {
+		endTime = instance.TerminationTime
+	}
+
+	if endTime.IsZero() {
+		endTime = instance.CreationTime
+	}
+
+	return now.Sub(endTime) >= policy.InstanceTTL
+}
+
+// RetainTransfers returns which transfers of an instance, in insertion order, should be kept
+func (policy *RetentionPolicy) RetainTransfers(transfers []types.ReportFileTransfer, now time.Time) []bool {
+	retain := make([]bool, len(transfers))
+	retainCount := 0
+	for i := range transfers {
+		t := transferTime(&transfers[i])
+		if policy.TransferTTL > 0 && !t.IsZero() && now.Sub(t) >= policy.TransferTTL {
+			continue
+		}
+
+		retain[i] = true
+		retainCount++
+	}
+
+	if policy.MaxTransfersPerInstance > 0 {
+		// drop the oldest over the cap
+		for i := 0; i < len(transfers) && retainCount > policy.MaxTransfersPerInstance; i++ {
+			if retain[i] {
+				retain[i] = false
+				retainCount--
+			}
+		}
+	}
+
+	return retain
+}
+
+// RollupExpired checks if the rollup should be removed
+func (policy *RetentionPolicy) RollupExpired(rollup *types.TransferRollup, now time.Time) bool {
+	ttl := policy.DailyRollupTTL
+	if rollup.Resolution == types.StatsBucketHour {
+		ttl = policy.HourlyRollupTTL
+	}
+
+	if ttl <= 0 {
+		return false
+	}
+
+	return now.Sub(rollup.BucketStart) >= ttl
+}
+
+// RetentionManager applies the retention policy to storage periodically
+type RetentionManager struct {
+	service       *MonitorService
+	policy        *RetentionPolicy
+	interval      time.Duration
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// NewRetentionManager creates a retention manager
+func NewRetentionManager(service *MonitorService) *RetentionManager {
+	return &RetentionManager{
+		service:       service,
+		policy:        NewRetentionPolicy(service.Config),
+		interval:      service.Config.RetentionInterval,
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+}
+
+// Init starts the retention manager
+func (manager *RetentionManager) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "RetentionManager.Init",
+	})
+
+	logger.Infof("Starting the retention manager (instance ttl %s, transfer ttl %s, max %d transfers per instance, every %s)", manager.policy.InstanceTTL, manager.policy.TransferTTL, manager.policy.MaxTransfersPerInstance, manager.interval)
+
+	manager.waitGroup.Add(1)
+	go manager.runLoop()
+
+	return nil
+}
+
+// Destroy stops the retention manager
+func (manager *RetentionManager) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "RetentionManager.Destroy",
+	})
+
+	logger.Info("Stopping the retention manager")
+
+	close(manager.terminateChan)
+	manager.waitGroup.Wait()
+}
+
+func (manager *RetentionManager) runLoop() {
+	defer manager.waitGroup.Done()
+
+	// run once at start, data may have expired while the service was down
+	manager.Run(time.Now().UTC())
+
+	ticker := time.NewTicker(manager.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-manager.terminateChan:
+			return
+		case <-ticker.C:
+			manager.Run(time.Now().UTC())
+		}
+	}
+}
+
+// Run applies the retention policy once
+func (manager *RetentionManager) Run(now time.Time) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "RetentionManager.Run",
+	})
+
+	svc := manager.service
+	entry := &JournalEntry{
+		Operation: JournalOperationApplyRetention,
+		Time:      now,
+		Retention: manager.policy,
+	}
+
+	var result RetentionResult
+	err := svc.commit(entry, func() error {
+		result = svc.Storage.ApplyRetention(manager.policy, now)
+		return nil
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not apply the retention policy")
+		return
+	}
+
+	svc.Metrics.ObserveRetention(result, now)
+
+	if result.InstancesRemoved > 0 || result.TransfersRemoved > 0 || result.RollupsRemoved > 0 {
+		logger.Infof("Removed %d instances, %d transfers and %d rollups by the retention policy", result.InstancesRemoved, result.TransfersRemoved, result.RollupsRemoved)
+	} else {
+		logger.Debug("Nothing to remove by the retention policy")
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+const (
+	// RollupPathDepth is the number of path components kept in rollups
+	RollupPathDepth int = types.StatsPathDepthDefault
+)
+
+// rollupResolutions are resolutions of rollups made from a file transfer
+var rollupResolutions = []string{types.StatsBucketHour, types.StatsBucketDay}
+
+// rollupKey returns a key that identifies the rollup's resolution, time bucket and dimensions
+func rollupKey(rollup *types.TransferRollup) string {
+	return strings.Join([]string{
+		rollup.Resolution,
+		rollup.BucketStart.UTC().Format(time.RFC3339),
+		rollup.Zone,
+		rollup.ClientUser,
+		rollup.ClientHostname,
+		rollup.PathPrefix,
+	}, "\x00")
+}
+
+// rollupTransfers summarizes file transfers of an instance into hourly and daily rollups
+func rollupTransfers(instance *types.ReportInstance, transfers []types.ReportFileTransfer) []types.TransferRollup {
+	rollups := map[string]*types.TransferRollup{}
+	for i := range transfers {
+		t := transferTime(&transfers[i])
+		if t.IsZero() {
+			// cannot be placed in a time bucket
+			continue
+		}
+
+		for _, resolution := range rollupResolutions {
+			start, _ := bucketStart(resolution, t)
+			rollup := types.TransferRollup{
+				Resolution:        resolution,
+				BucketStart:       start,
+				Zone:              instance.Zone,
+				ClientUser:        instance.ClientUser,
+				ClientHostname:    instance.ClientHostname,
+				PathPrefix:        pathPrefix(transfers[i].FilePath, RollupPathDepth),
+				FileCount:         1,
+				TotalTransferSize: transfers[i].TransferSize,
+			}
+
+			key := rollupKey(&rollup)
+			if existing, ok := rollups[key]; ok {
+				existing.FileCount += rollup.FileCount
+				existing.TotalTransferSize += rollup.TotalTransferSize
+			} else {
+				rollups[key] = &rollup
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range rollups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []types.TransferRollup{}
+	for _, key := range keys {
+		result = append(result, *rollups[key])
+	}
+	return result
+}
+
+// sortRollups sorts rollups by resolution, time bucket and dimensions
+func sortRollups(rollups []types.TransferRollup) {
+	sort.Slice(rollups, func(i int, j int) bool {
+		return rollupKey(&rollups[i]) < rollupKey(&rollups[j])
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cyverse/irodsfs-monitor/types"
@@ -19,11 +20,27 @@ type MonitorService struct {
 	Config    *Config
 	WebServer *http.Server
 	Router    *mux.Router
-	Storage   *Storage
+	Storage   Storage
+	Journal   *Journal
+	Metrics   *Metrics
+	Events    *EventBroker
+	Webhooks  *WebhookDispatcher
+	Reaper    *InstanceReaper
+	Retention *RetentionManager
+	Alerts    *AlertEngine
+
+	Authenticator *Authenticator
+
+	// Destroy is called by the signal handler and again when Start returns after the web server is closed
+	destroyOnce sync.Once
 }
 
 // NewMonitorService creates a new monitor service
-func NewMonitorService(config *Config) *MonitorService {
+func NewMonitorService(config *Config) (*MonitorService, error) {
+	storage, err := NewStorage(config)
+	if err != nil {
+		return nil, err
+	}
 
 	webServerRouter := mux.NewRouter()
 	webServer := &http.Server{
@@ -31,34 +48,139 @@ func NewMonitorService(config *Config) *MonitorService {
 		Handler: webServerRouter,
 	}
 
+	if config.TLSEnabled() {
+		tlsReloader, err := NewTLSReloader(config.TLSCertPath, config.TLSKeyPath, config.TLSClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		webServer.TLSConfig = tlsReloader.TLSConfig()
+	}
+
 	service := &MonitorService{
 		Config:    config,
 		WebServer: webServer,
 		Router:    webServerRouter,
-		Storage:   NewStorage(),
+		Storage:   storage,
+		Journal:   nil,
+		Metrics:   NewMetrics(),
+		Events:    NewEventBroker(),
+		Webhooks:  nil,
+		Reaper:    nil,
+		Retention: nil,
+		Alerts:    nil,
+
+		Authenticator: NewAuthenticator(config),
+	}
+
+	if len(config.JournalPath) > 0 {
+		service.Journal = NewJournal(config.JournalPath, config.JournalSnapshotEntries, storage)
+	}
+
+	if len(config.Webhooks) > 0 {
+		service.Webhooks = NewWebhookDispatcher(config, service.Events)
+	}
+
+	if config.InstanceStaleTimeout > 0 || config.InstanceLostTimeout > 0 {
+		service.Reaper = NewInstanceReaper(service)
+	}
+
+	if config.RetentionInterval > 0 {
+		service.Retention = NewRetentionManager(service)
+	}
+
+	if len(config.AlertRules) > 0 {
+		service.Alerts, err = NewAlertEngine(config, storage)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	service.addHandlers()
 
-	return service
+	return service, nil
 }
 
 // addHandlers adds web server handlers
 func (svc *MonitorService) addHandlers() {
-	svc.Router.HandleFunc("/instances", svc.addInstance).Methods("POST")
-	svc.Router.HandleFunc("/instances", svc.listInstances).Methods("GET")
-	svc.Router.HandleFunc("/instances/{instance_id}", svc.getInstance).Methods("GET")
-	svc.Router.HandleFunc("/instances/{instance_id}", svc.terminateInstance).Methods("DELETE")
-
-	svc.Router.HandleFunc("/transfers", svc.addTransfer).Methods("POST")
-	svc.Router.HandleFunc("/transfers", svc.listTransfers).Methods("GET")
-	svc.Router.HandleFunc("/transfers/{instance_id}", svc.listTransfersForInstance).Methods("GET")
-	svc.Router.HandleFunc("/cleanup", svc.cleanUp).Methods("DELETE")
-	svc.Router.HandleFunc("/cleanup/{days}", svc.cleanUpDaysOld).Methods("DELETE")
+	svc.Router.HandleFunc("/instances", svc.requireRole(RoleReporter, svc.addInstance)).Methods("POST")
+	svc.Router.HandleFunc("/instances", svc.requireRole(RoleReader, svc.listInstances)).Methods("GET")
+	svc.Router.HandleFunc("/instances/{instance_id}", svc.requireRole(RoleReader, svc.getInstance)).Methods("GET")
+	svc.Router.HandleFunc("/instances/{instance_id}", svc.requireRole(RoleReporter, svc.terminateInstance)).Methods("DELETE")
+	svc.Router.HandleFunc("/instances/{instance_id}/heartbeat", svc.requireRole(RoleReporter, svc.heartbeat)).Methods("POST")
+	svc.Router.HandleFunc("/instances/{instance_id}/recommendations", svc.requireRole(RoleReader, svc.getRecommendations)).Methods("GET")
+
+	svc.Router.HandleFunc("/transfers", svc.requireRole(RoleReporter, svc.addTransfer)).Methods("POST")
+	svc.Router.HandleFunc("/transfers/batch", svc.requireRole(RoleReporter, svc.addTransfers)).Methods("POST")
+	svc.Router.HandleFunc("/transfers", svc.requireRole(RoleReader, svc.listTransfers)).Methods("GET")
+	svc.Router.HandleFunc("/transfers/{instance_id}", svc.requireRole(RoleReader, svc.listTransfersForInstance)).Methods("GET")
+	svc.Router.HandleFunc("/transfers/{instance_id}/{sequence}/access", svc.requireRole(RoleReader, svc.getTransferAccess)).Methods("GET")
+	svc.Router.HandleFunc("/cleanup", svc.requireRole(RoleAdmin, svc.cleanUp)).Methods("DELETE")
+	svc.Router.HandleFunc("/cleanup/{days}", svc.requireRole(RoleAdmin, svc.cleanUpDaysOld)).Methods("DELETE")
+	svc.Router.HandleFunc("/import", svc.requireRole(RoleAdmin, svc.importData)).Methods("POST")
+
+	svc.Router.HandleFunc("/stats/transfers", svc.requireRole(RoleReader, svc.getTransferStats)).Methods("GET")
+	svc.Router.HandleFunc("/stats/top", svc.requireRole(RoleReader, svc.getTop)).Methods("GET")
+	svc.Router.HandleFunc("/stats/access", svc.requireRole(RoleReader, svc.getPathAccess)).Methods("GET")
+
+	svc.Router.HandleFunc("/fleet/drift", svc.requireRole(RoleReader, svc.getConfigDrift)).Methods("GET")
+
+	svc.Router.HandleFunc("/export", svc.requireRole(RoleReader, svc.export)).Methods("GET")
+
+	svc.Router.HandleFunc("/alerts", svc.requireRole(RoleReader, svc.listAlerts)).Methods("GET")
+
+	svc.Router.HandleFunc("/events", svc.requireRole(RoleReader, svc.streamEvents)).Methods("GET")
+
+	svc.Router.HandleFunc("/metrics", svc.requireRole(RoleReader, svc.getMetrics)).Methods("GET")
+
+	svc.Router.HandleFunc("/ui", svc.redirectUI).Methods("GET")
+	svc.Router.PathPrefix("/ui/").Handler(svc.serveUI(newUIHandler())).Methods("GET")
+
+	svc.Router.Use(svc.Metrics.Middleware)
 }
 
 // Init initializes the service
 func (svc *MonitorService) Init() error {
+	err := svc.Storage.Init()
+	if err != nil {
+		return err
+	}
+
+	if svc.Journal != nil {
+		err = svc.Journal.Init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if svc.Webhooks != nil {
+		err = svc.Webhooks.Init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if svc.Reaper != nil {
+		err = svc.Reaper.Init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if svc.Retention != nil {
+		err = svc.Retention.Init()
+		if err != nil {
+			return err
+		}
+	}
+
+	if svc.Alerts != nil {
+		err = svc.Alerts.Init()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -71,7 +193,14 @@ func (svc *MonitorService) Start() error {
 
 	logger.Info("Starting the iRODS FUSE Lite Monitoring service")
 
-	err := svc.WebServer.ListenAndServe()
+	var err error
+	if svc.WebServer.TLSConfig != nil {
+		// certificates are provided by TLSConfig
+		err = svc.WebServer.ListenAndServeTLS("", "")
+	} else {
+		err = svc.WebServer.ListenAndServe()
+	}
+
 	if err != nil {
 		logger.Error(err)
 		return err
@@ -88,12 +217,47 @@ func (svc *MonitorService) Destroy() {
 		"function": "MonitorService.Destroy",
 	})
 
-	logger.Info("Destroying the iRODS FUSE Lite Monitoring service")
+	svc.destroyOnce.Do(func() {
+		logger.Info("Destroying the iRODS FUSE Lite Monitoring service")
 
-	err := svc.WebServer.Close()
-	if err != nil {
-		logger.Error(err)
+		if svc.Alerts != nil {
+			svc.Alerts.Destroy()
+		}
+
+		if svc.Retention != nil {
+			svc.Retention.Destroy()
+		}
+
+		if svc.Reaper != nil {
+			svc.Reaper.Destroy()
+		}
+
+		if svc.Webhooks != nil {
+			svc.Webhooks.Destroy()
+		}
+
+		svc.Events.Close()
+
+		err := svc.WebServer.Close()
+		if err != nil {
+			logger.Error(err)
+		}
+
+		if svc.Journal != nil {
+			svc.Journal.Destroy()
+		}
+
+		svc.Storage.Destroy()
+	})
+}
+
+// commit applies a storage operation, recording it in the journal first if journaling is enabled
+func (svc *MonitorService) commit(entry *JournalEntry, apply func() error) error {
+	if svc.Journal == nil {
+		return apply()
 	}
+
+	return svc.Journal.Write(entry, apply)
 }
 
 func (svc *MonitorService) getClientIP(r *http.Request) string {
@@ -147,9 +311,42 @@ func (svc *MonitorService) addInstance(w http.ResponseWriter, r *http.Request) {
 	}
 
 	instance.Terminated = false
+	instance.State = types.InstanceStateActive
 	instance.LastActivityTime = nowUTC
+	instance.ReporterID = ""
 
-	svc.Storage.AddInstance(instance)
+	// without authentication anyone may report the instance, admins may take over instances of other reporters
+	takeOver := true
+	if principal := getPrincipal(r); principal != nil {
+		takeOver = principal.Role == RoleAdmin
+		instance.ReporterID = principal.TokenID
+	}
+
+	entry := &JournalEntry{
+		Operation: JournalOperationAddInstance,
+		Time:      nowUTC,
+		Instance:  &instance,
+		TakeOver:  takeOver,
+	}
+
+	// the reporter is checked in the storage commit, so concurrent registrations cannot both claim the instance
+	err = svc.commit(entry, func() error {
+		return svc.Storage.ClaimInstance(instance, takeOver)
+	})
+	if err != nil {
+		logger.Error(err)
+		if _, ok := err.(*InstanceClaimedError); ok {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	svc.publishInstanceEvent(types.EventTypeInstanceCreated, instance.InstanceID)
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -161,7 +358,28 @@ func (svc *MonitorService) listInstances(w http.ResponseWriter, r *http.Request)
 
 	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
 
-	instances := svc.Storage.ListInstances()
+	query, err := types.NewInstanceQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	instances, nextCursor, err := QueryInstances(query, svc.Storage.ListInstances())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !showReporterIDs(r) {
+		for idx := range instances {
+			instances[idx].ReporterID = ""
+		}
+	}
+
 	responseJSON, err := json.Marshal(instances)
 	if err != nil {
 		logger.Error(err)
@@ -171,6 +389,9 @@ func (svc *MonitorService) listInstances(w http.ResponseWriter, r *http.Request)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if len(nextCursor) > 0 {
+		w.Header().Set(types.NextCursorHeader, nextCursor)
+	}
 	w.WriteHeader(http.StatusOK)
 
 	_, err = w.Write(responseJSON)
@@ -202,6 +423,10 @@ func (svc *MonitorService) getInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !showReporterIDs(r) {
+		instance.ReporterID = ""
+	}
+
 	responseJSON, err := json.Marshal(instance)
 	if err != nil {
 		logger.Error(err)
@@ -236,7 +461,81 @@ func (svc *MonitorService) terminateInstance(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err := svc.Storage.TerminateInstance(instanceID)
+	if principal := getPrincipal(r); principal != nil && principal.Role == RoleReporter {
+		// reporters can only terminate their own instances
+		instance, ok := svc.Storage.GetInstance(instanceID)
+		if ok && instance.ReporterID != principal.TokenID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("instance is reported by another reporter"))
+			return
+		}
+	}
+
+	// the same time is journaled, so a replay terminates the instance at the same time
+	nowUTC := time.Now().UTC()
+	entry := &JournalEntry{
+		Operation:  JournalOperationTerminateInstance,
+		Time:       nowUTC,
+		InstanceID: instanceID,
+	}
+
+	err := svc.commit(entry, func() error {
+		return svc.Storage.TerminateInstance(instanceID, nowUTC)
+	})
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	svc.publishInstanceEvent(types.EventTypeInstanceTerminated, instanceID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (svc *MonitorService) heartbeat(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.heartbeat",
+	})
+
+	logger.Debugf("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	varMap := mux.Vars(r)
+	instanceID, ok := varMap["instance_id"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("instance_id is not given"))
+		return
+	}
+
+	instance, ok := svc.Storage.GetInstance(instanceID)
+	if !ok {
+		// the instance may be cleared, the client should report it again
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("unable to find an instance for ID %s", instanceID)))
+		return
+	}
+
+	if principal := getPrincipal(r); principal != nil && principal.Role == RoleReporter {
+		// reporters can only send heartbeats of their own instances
+		if instance.ReporterID != principal.TokenID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("instance is reported by another reporter"))
+			return
+		}
+	}
+
+	nowUTC := time.Now().UTC()
+	entry := &JournalEntry{
+		Operation:  JournalOperationHeartbeat,
+		Time:       nowUTC,
+		InstanceID: instanceID,
+	}
+
+	err := svc.commit(entry, func() error {
+		return svc.Storage.UpdateInstanceLastActivityTime(instanceID, nowUTC)
+	})
 	if err != nil {
 		logger.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -272,7 +571,21 @@ func (svc *MonitorService) addTransfer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = svc.Storage.AddFileTransfer(transfer)
+	nowUTC := time.Now().UTC()
+	entry := &JournalEntry{
+		Operation: JournalOperationAddFileTransfer,
+		Time:      nowUTC,
+		Transfer:  &transfer,
+	}
+
+	err = svc.commit(entry, func() error {
+		err := svc.Storage.AddFileTransfer(transfer)
+		if err != nil {
+			return err
+		}
+
+		return svc.Storage.UpdateInstanceLastActivityTime(transfer.InstanceID, nowUTC)
+	})
 	if err != nil {
 		logger.Error(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -280,7 +593,27 @@ func (svc *MonitorService) addTransfer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = svc.Storage.UpdateInstanceLastActivityTime(transfer.InstanceID)
+	svc.publishTransferEvent(&transfer)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (svc *MonitorService) addTransfers(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.addTransfers",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	requestBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	transfers, parseErrs, err := parseTransferBatch(requestBytes)
 	if err != nil {
 		logger.Error(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -288,7 +621,102 @@ func (svc *MonitorService) addTransfer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	if len(transfers) > svc.Config.TransferBatchSizeMax {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("batch has %d transfers, max is %d", len(transfers), svc.Config.TransferBatchSizeMax)))
+		return
+	}
+
+	// only valid transfers go to storage
+	validTransfers := []types.ReportFileTransfer{}
+	validIndices := []int{}
+	errs := make([]error, len(transfers))
+	for i, transfer := range transfers {
+		if parseErrs[i] != nil {
+			errs[i] = parseErrs[i]
+			continue
+		}
+
+		if len(transfer.InstanceID) == 0 {
+			errs[i] = fmt.Errorf("instance_id is not given")
+			continue
+		}
+
+		validTransfers = append(validTransfers, transfer)
+		validIndices = append(validIndices, i)
+	}
+
+	if len(validTransfers) > 0 {
+		nowUTC := time.Now().UTC()
+		entry := &JournalEntry{
+			Operation: JournalOperationAddFileTransfers,
+			Time:      nowUTC,
+			Transfers: validTransfers,
+		}
+
+		err = svc.commit(entry, func() error {
+			addErrs := svc.Storage.AddFileTransfers(validTransfers)
+			for j, addErr := range addErrs {
+				errs[validIndices[j]] = addErr
+			}
+
+			added := []types.ReportFileTransfer{}
+			for j, transfer := range validTransfers {
+				if addErrs[j] == nil {
+					added = append(added, transfer)
+				}
+			}
+
+			for _, instanceID := range transferInstanceIDs(added) {
+				err := svc.Storage.UpdateInstanceLastActivityTime(instanceID, nowUTC)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	result := types.ReportFileTransferBatchResult{
+		Accepted: 0,
+		Rejected: 0,
+		Results:  make([]types.ReportFileTransferResult, len(transfers)),
+	}
+
+	for i := range transfers {
+		result.Results[i].Index = i
+		if errs[i] != nil {
+			result.Rejected++
+			result.Results[i].Error = errs[i].Error()
+			continue
+		}
+
+		result.Accepted++
+		result.Results[i].Accepted = true
+		svc.publishTransferEvent(&transfers[i])
+	}
+
+	if result.Rejected > 0 {
+		logger.Warnf("Rejected %d of %d transfers in a batch", result.Rejected, len(transfers))
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultJSON)
 }
 
 func (svc *MonitorService) listTransfers(w http.ResponseWriter, r *http.Request) {
@@ -299,7 +727,22 @@ func (svc *MonitorService) listTransfers(w http.ResponseWriter, r *http.Request)
 
 	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
 
-	transfers := svc.Storage.ListFileTransfers()
+	query, err := types.NewTransferQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	transfers, nextCursor, err := QueryFileTransfers(query, svc.Storage)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	responseJSON, err := json.Marshal(transfers)
 	if err != nil {
 		logger.Error(err)
@@ -309,6 +752,9 @@ func (svc *MonitorService) listTransfers(w http.ResponseWriter, r *http.Request)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if len(nextCursor) > 0 {
+		w.Header().Set(types.NextCursorHeader, nextCursor)
+	}
 	w.WriteHeader(http.StatusOK)
 
 	_, err = w.Write(responseJSON)
@@ -334,7 +780,24 @@ func (svc *MonitorService) listTransfersForInstance(w http.ResponseWriter, r *ht
 		return
 	}
 
-	transfers := svc.Storage.ListFileTransfersForInstance(instanceID)
+	query, err := types.NewTransferQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	query.InstanceID = instanceID
+
+	transfers, nextCursor, err := QueryFileTransfers(query, svc.Storage)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	responseJSON, err := json.Marshal(transfers)
 	if err != nil {
 		logger.Error(err)
@@ -344,6 +807,9 @@ func (svc *MonitorService) listTransfersForInstance(w http.ResponseWriter, r *ht
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if len(nextCursor) > 0 {
+		w.Header().Set(types.NextCursorHeader, nextCursor)
+	}
 	w.WriteHeader(http.StatusOK)
 
 	_, err = w.Write(responseJSON)
@@ -361,7 +827,23 @@ func (svc *MonitorService) cleanUp(w http.ResponseWriter, r *http.Request) {
 
 	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
 
-	svc.Storage.CleanUp()
+	svc.Metrics.IncCleanupRuns("all")
+
+	entry := &JournalEntry{
+		Operation: JournalOperationCleanUp,
+	}
+
+	err := svc.commit(entry, func() error {
+		svc.Storage.CleanUp()
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -388,6 +870,49 @@ func (svc *MonitorService) cleanUpDaysOld(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	svc.Storage.clearOld(days)
+	svc.Metrics.IncCleanupRuns("days_old")
+
+	// the same time is recorded in the journal, so a replay removes the same data
+	now := time.Now().UTC()
+	entry := &JournalEntry{
+		Operation: JournalOperationClearOld,
+		Time:      now,
+		Days:      days,
+	}
+
+	err = svc.commit(entry, func() error {
+		svc.Storage.ClearOld(days, now)
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 }
+
+func (svc *MonitorService) getMetrics(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getMetrics",
+	})
+
+	logger.Debugf("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	var sb strings.Builder
+	writer := NewMetricsWriter(&sb)
+	svc.Metrics.WriteTo(writer)
+	WriteStorageMetrics(writer, svc.Storage)
+
+	w.Header().Set("Content-Type", MetricsContentType)
+	w.WriteHeader(http.StatusOK)
+
+	_, err := w.Write([]byte(sb.String()))
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+}
@@ -0,0 +1,510 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// transferTime returns the time a transfer is accounted at, which is when the file is closed
+func transferTime(transfer *types.ReportFileTransfer) time.Time {
+	if !transfer.FileCloseTime.IsZero() {
+		return transfer.FileCloseTime
+	}
+	return transfer.FileOpenTime
+}
+
+// pathPrefix returns the first depth components of the path
+func pathPrefix(p string, depth int) string {
+	components := strings.Split(strings.Trim(p, "/"), "/")
+	if len(components) > depth {
+		components = components[:depth]
+	}
+	return "/" + strings.Join(components, "/")
+}
+
+// bucketStart returns the start of the time bucket that the time belongs to
+func bucketStart(bucket string, t time.Time) (time.Time, error) {
+	t = t.UTC()
+	switch bucket {
+	case types.StatsBucketMinute:
+		return t.Truncate(time.Minute), nil
+	case types.StatsBucketHour:
+		return t.Truncate(time.Hour), nil
+	case types.StatsBucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown bucket %s", bucket)
+	}
+}
+
+// statsGroup returns the group of a transfer made by the instance
+func statsGroup(query *types.StatsQuery, instance *types.ReportInstance, transfer *types.ReportFileTransfer) (string, error) {
+	switch query.GroupBy {
+	case "":
+		return "", nil
+	case types.StatsGroupByUser:
+		return instance.ClientUser, nil
+	case types.StatsGroupByZone:
+		return instance.Zone, nil
+	case types.StatsGroupByHost:
+		return instance.ClientHostname, nil
+	case types.StatsGroupByInstance:
+		return instance.InstanceID, nil
+	case types.StatsGroupByPathPrefix:
+		return pathPrefix(transfer.FilePath, query.PathDepth), nil
+	default:
+		return "", fmt.Errorf("unknown group %s", query.GroupBy)
+	}
+}
+
+// validateStatsQuery checks if the query has known group and bucket
+func validateStatsQuery(query *types.StatsQuery) error {
+	switch query.GroupBy {
+	case "", types.StatsGroupByUser, types.StatsGroupByZone, types.StatsGroupByHost, types.StatsGroupByInstance, types.StatsGroupByPathPrefix:
+	default:
+		return fmt.Errorf("unknown group %s", query.GroupBy)
+	}
+
+	if len(query.Bucket) > 0 {
+		_, err := bucketStart(query.Bucket, time.Time{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if query.PathDepth <= 0 {
+		return fmt.Errorf("path depth must be positive")
+	}
+
+	return nil
+}
+
+// inStatsWindow checks if the time is in the query's time window
+func inStatsWindow(query *types.StatsQuery, t time.Time) bool {
+	if !query.StartTime.IsZero() && t.Before(query.StartTime) {
+		return false
+	}
+
+	if !query.EndTime.IsZero() && !t.Before(query.EndTime) {
+		return false
+	}
+
+	return true
+}
+
+// statsKey identifies a group and a time bucket
+type statsKey struct {
+	Group       string
+	BucketStart time.Time
+}
+
+// statsAggregator sums file and byte counts per group and time bucket
+type statsAggregator struct {
+	Query *types.StatsQuery
+	Stats map[statsKey]*types.TransferStats
+}
+
+func newStatsAggregator(query *types.StatsQuery) *statsAggregator {
+	return &statsAggregator{
+		Query: query,
+		Stats: map[statsKey]*types.TransferStats{},
+	}
+}
+
+// Add adds file and byte counts of a group accounted at the given time
+func (aggregator *statsAggregator) Add(group string, t time.Time, fileCount int64, transferSize int64) error {
+	key := statsKey{
+		Group: group,
+	}
+
+	if len(aggregator.Query.Bucket) > 0 {
+		start, err := bucketStart(aggregator.Query.Bucket, t)
+		if err != nil {
+			return err
+		}
+		key.BucketStart = start
+	}
+
+	stats, ok := aggregator.Stats[key]
+	if !ok {
+		stats = &types.TransferStats{
+			Group: group,
+		}
+
+		if len(aggregator.Query.Bucket) > 0 {
+			start := key.BucketStart
+			stats.BucketStart = &start
+		}
+
+		aggregator.Stats[key] = stats
+	}
+
+	stats.FileCount += fileCount
+	stats.TotalTransferSize += transferSize
+	return nil
+}
+
+// Result returns stats sorted by group and time bucket
+func (aggregator *statsAggregator) Result() []types.TransferStats {
+	keys := []statsKey{}
+	for key := range aggregator.Stats {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i int, j int) bool {
+		if keys[i].Group != keys[j].Group {
+			return keys[i].Group < keys[j].Group
+		}
+		return keys[i].BucketStart.Before(keys[j].BucketStart)
+	})
+
+	result := []types.TransferStats{}
+	for _, key := range keys {
+		stats := *aggregator.Stats[key]
+		if stats.FileCount > 0 {
+			stats.AverageTransferSize = stats.TotalTransferSize / stats.FileCount
+		}
+		result = append(result, stats)
+	}
+
+	return result
+}
+
+// ComputeTransferStats aggregates file transfers in storage
+// Rollups that cannot answer the query exactly are not counted, the reason is returned if any is left out
+func ComputeTransferStats(query *types.StatsQuery, storage Storage) ([]types.TransferStats, string, error) {
+	err := validateStatsQuery(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	transferCount := 0
+	aggregator := newStatsAggregator(query)
+	for _, instance := range storage.ListInstances() {
+		for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+			t := transferTime(&transfer)
+			if !inStatsWindow(query, t) {
+				continue
+			}
+
+			group, err := statsGroup(query, &instance, &transfer)
+			if err != nil {
+				return nil, "", err
+			}
+
+			err = aggregator.Add(group, t, 1, transfer.TransferSize)
+			if err != nil {
+				return nil, "", err
+			}
+			transferCount++
+		}
+	}
+
+	// transfers removed by retention are counted from rollups
+	partial := ""
+	for _, rollup := range storage.ListRollups(rollupResolution(query)) {
+		if !rollupInStatsWindow(query, &rollup) {
+			continue
+		}
+
+		reason := rollupStatsLimit(query, &rollup)
+		if len(reason) > 0 {
+			partial = reason
+			continue
+		}
+
+		err = aggregator.Add(rollupStatsGroup(query, &rollup), rollup.BucketStart, rollup.FileCount, rollup.TotalTransferSize)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// with no transfers kept in the window, the whole answer would be missing
+	if len(partial) > 0 && transferCount == 0 {
+		return nil, "", fmt.Errorf("all transfers in the time window are removed by retention, %s", partial)
+	}
+
+	return aggregator.Result(), partial, nil
+}
+
+// rollupResolution returns the resolution of rollups used for the query, hourly rollups are used for finer buckets
+func rollupResolution(query *types.StatsQuery) string {
+	switch query.Bucket {
+	case types.StatsBucketMinute, types.StatsBucketHour:
+		return types.StatsBucketHour
+	default:
+		return types.StatsBucketDay
+	}
+}
+
+// rollupBucketEnd returns the end of the time bucket of the rollup
+func rollupBucketEnd(rollup *types.TransferRollup) time.Time {
+	if rollup.Resolution == types.StatsBucketHour {
+		return rollup.BucketStart.Add(time.Hour)
+	}
+	return rollup.BucketStart.AddDate(0, 0, 1)
+}
+
+// rollupInStatsWindow checks if the time bucket of the rollup overlaps the query's time window
+func rollupInStatsWindow(query *types.StatsQuery, rollup *types.TransferRollup) bool {
+	if !query.EndTime.IsZero() && !rollup.BucketStart.Before(query.EndTime) {
+		return false
+	}
+
+	if !query.StartTime.IsZero() && !rollupBucketEnd(rollup).After(query.StartTime) {
+		return false
+	}
+
+	return true
+}
+
+// rollupStatsLimit returns why the rollup cannot answer the query exactly, empty if it can
+// Rollups do not keep instances, minutes and path prefixes longer than RollupPathDepth, and cannot be split at window edges
+func rollupStatsLimit(query *types.StatsQuery, rollup *types.TransferRollup) string {
+	if query.Bucket == types.StatsBucketMinute {
+		return "minute buckets do not count transfers removed by retention, which are kept in hourly rollups"
+	}
+
+	if query.GroupBy == types.StatsGroupByInstance {
+		return "instance groups do not count transfers removed by retention, which are kept in rollups without instances"
+	}
+
+	if query.GroupBy == types.StatsGroupByPathPrefix && query.PathDepth > RollupPathDepth {
+		return fmt.Sprintf("path depth over %d does not count transfers removed by retention, which are kept in rollups of %d path components", RollupPathDepth, RollupPathDepth)
+	}
+
+	if !inStatsWindow(query, rollup.BucketStart) || (!query.EndTime.IsZero() && rollupBucketEnd(rollup).After(query.EndTime)) {
+		return fmt.Sprintf("transfers removed by retention are kept in rollups per %s, they are not counted in a %s that start or end splits in UTC", rollup.Resolution, rollup.Resolution)
+	}
+
+	return ""
+}
+
+// rollupStatsGroup returns the group of a rollup, rollups keep path prefixes up to RollupPathDepth
+func rollupStatsGroup(query *types.StatsQuery, rollup *types.TransferRollup) string {
+	switch query.GroupBy {
+	case types.StatsGroupByUser:
+		return rollup.ClientUser
+	case types.StatsGroupByZone:
+		return rollup.Zone
+	case types.StatsGroupByHost:
+		return rollup.ClientHostname
+	case types.StatsGroupByPathPrefix:
+		return pathPrefix(rollup.PathPrefix, query.PathDepth)
+	default:
+		return ""
+	}
+}
+
+func (svc *MonitorService) getTransferStats(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getTransferStats",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	query, err := types.NewStatsQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	stats, partial, err := ComputeTransferStats(query, svc.Storage)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	responseJSON, err := json.Marshal(stats)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(partial) > 0 {
+		w.Header().Set(types.StatsPartialHeader, partial)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(responseJSON)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+}
+
+// isReadMode checks if the file open mode reads data
+func isReadMode(openMode string) bool {
+	return strings.HasPrefix(openMode, "r") || strings.Contains(openMode, "+")
+}
+
+// isWriteMode checks if the file open mode writes data
+func isWriteMode(openMode string) bool {
+	return strings.HasPrefix(openMode, "w") || strings.HasPrefix(openMode, "a") || strings.Contains(openMode, "+")
+}
+
+// validateTopQuery checks if the query has known target, rank and access
+func validateTopQuery(query *types.TopQuery) error {
+	switch query.Target {
+	case types.TopTargetFile, types.TopTargetCollection:
+	default:
+		return fmt.Errorf("unknown target %s", query.Target)
+	}
+
+	switch query.RankBy {
+	case types.TopRankByBytes, types.TopRankByOpens, types.TopRankByInstances:
+	default:
+		return fmt.Errorf("unknown rank %s", query.RankBy)
+	}
+
+	switch query.Access {
+	case "", types.TopAccessRead, types.TopAccessWrite:
+	default:
+		return fmt.Errorf("unknown access %s", query.Access)
+	}
+
+	if query.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+
+	return nil
+}
+
+// ComputeTop ranks files or collections in storage by their access counts
+func ComputeTop(query *types.TopQuery, storage Storage) ([]types.TopEntry, error) {
+	err := validateTopQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	window := &types.StatsQuery{
+		StartTime: query.StartTime,
+		EndTime:   query.EndTime,
+	}
+
+	entries := map[string]*types.TopEntry{}
+	instanceSets := map[string]map[string]bool{}
+	for _, instance := range storage.ListInstances() {
+		for _, transfer := range storage.ListFileTransfersForInstance(instance.InstanceID) {
+			if !inStatsWindow(window, transferTime(&transfer)) {
+				continue
+			}
+
+			if query.Access == types.TopAccessRead && !isReadMode(transfer.FileOpenMode) {
+				continue
+			}
+
+			if query.Access == types.TopAccessWrite && !isWriteMode(transfer.FileOpenMode) {
+				continue
+			}
+
+			p := transfer.FilePath
+			if query.Target == types.TopTargetCollection {
+				p = path.Dir(p)
+			}
+
+			entry, ok := entries[p]
+			if !ok {
+				entry = &types.TopEntry{
+					Path: p,
+				}
+				entries[p] = entry
+				instanceSets[p] = map[string]bool{}
+			}
+
+			entry.TransferSize += transfer.TransferSize
+			entry.OpenCount++
+			instanceSets[p][instance.InstanceID] = true
+		}
+	}
+
+	result := []types.TopEntry{}
+	for p, entry := range entries {
+		entry.InstanceCount = int64(len(instanceSets[p]))
+		result = append(result, *entry)
+	}
+
+	rankValue := func(entry *types.TopEntry) int64 {
+		switch query.RankBy {
+		case types.TopRankByOpens:
+			return entry.OpenCount
+		case types.TopRankByInstances:
+			return entry.InstanceCount
+		default:
+			return entry.TransferSize
+		}
+	}
+
+	sort.Slice(result, func(i int, j int) bool {
+		vi := rankValue(&result[i])
+		vj := rankValue(&result[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return result[i].Path < result[j].Path
+	})
+
+	if len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+
+	return result, nil
+}
+
+func (svc *MonitorService) getTop(w http.ResponseWriter, r *http.Request) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MonitorService.getTop",
+	})
+
+	logger.Infof("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+	query, err := types.NewTopQueryFromValues(r.URL.Query())
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	top, err := ComputeTop(query, svc.Storage)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	responseJSON, err := json.Marshal(top)
+	if err != nil {
+		logger.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(responseJSON)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+var statsTestTime = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// newStatsTestStorage makes a storage with a transfer kept at statsTestTime and rollups of transfers removed before
+func newStatsTestStorage(t *testing.T) *MemoryStorage {
+	storage := NewMemoryStorage()
+
+	err := storage.AddInstance(types.ReportInstance{InstanceID: "a", Zone: "z1", ClientUser: "alice", ClientHostname: "h1", CreationTime: statsTestTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.AddFileTransfer(types.ReportFileTransfer{
+		InstanceID:    "a",
+		FilePath:      "/z1/home/alice/data/x.txt",
+		FileSize:      100,
+		TransferSize:  100,
+		FileOpenMode:  "r",
+		FileOpenTime:  statsTestTime,
+		FileCloseTime: statsTestTime.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollup := func(resolution string, start time.Time, count int64) types.TransferRollup {
+		return types.TransferRollup{
+			Resolution:        resolution,
+			BucketStart:       start,
+			Zone:              "z1",
+			ClientUser:        "alice",
+			ClientHostname:    "h1",
+			PathPrefix:        "/z1/home/alice",
+			FileCount:         count,
+			TotalTransferSize: count * 100,
+		}
+	}
+
+	err = storage.AddRollups([]types.TransferRollup{
+		rollup(types.StatsBucketHour, statsTestTime.Add(-2*time.Hour), 10),
+		rollup(types.StatsBucketHour, statsTestTime.Add(-time.Hour), 20),
+		rollup(types.StatsBucketDay, time.Date(2026, 9, 29, 0, 0, 0, 0, time.UTC), 3),
+		rollup(types.StatsBucketDay, time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC), 5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return storage
+}
+
+// statsTestResult formats stats as <group>@<bucket start hour>:<file count>
+func statsTestResult(stats []types.TransferStats) []string {
+	result := []string{}
+	for _, stat := range stats {
+		bucket := ""
+		if stat.BucketStart != nil {
+			bucket = stat.BucketStart.Format("02T15:04")
+		}
+		result = append(result, fmt.Sprintf("%s@%s:%d", stat.Group, bucket, stat.FileCount))
+	}
+	return result
+}
+
+func TestComputeTransferStatsRollups(t *testing.T) {
+	dayStart := time.Date(2026, 9, 29, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		query    types.StatsQuery
+		expected []string
+		partial  bool
+		err      bool
+	}{
+		{
+			name:     "daily rollups in the window",
+			query:    types.StatsQuery{StartTime: dayStart},
+			expected: []string{"@:9"},
+		},
+		{
+			name:     "hourly rollups in hour buckets",
+			query:    types.StatsQuery{Bucket: types.StatsBucketHour, StartTime: statsTestTime.Add(-2 * time.Hour)},
+			expected: []string{"@01T10:00:10", "@01T11:00:20", "@01T12:00:1"},
+		},
+		{
+			name:     "user groups",
+			query:    types.StatsQuery{GroupBy: types.StatsGroupByUser, StartTime: dayStart},
+			expected: []string{"alice@:9"},
+		},
+		{
+			name:     "minute buckets count kept transfers",
+			query:    types.StatsQuery{Bucket: types.StatsBucketMinute, StartTime: statsTestTime.Add(-2 * time.Hour)},
+			expected: []string{"@01T12:01:1"},
+			partial:  true,
+		},
+		{
+			name:     "instance groups count kept transfers",
+			query:    types.StatsQuery{GroupBy: types.StatsGroupByInstance, StartTime: dayStart},
+			expected: []string{"a@:1"},
+			partial:  true,
+		},
+		{
+			name:     "path depth over rollups counts kept transfers",
+			query:    types.StatsQuery{GroupBy: types.StatsGroupByPathPrefix, PathDepth: 4, StartTime: dayStart},
+			expected: []string{"/z1/home/alice/data@:1"},
+			partial:  true,
+		},
+		{
+			name:     "start splits a day",
+			query:    types.StatsQuery{StartTime: dayStart.Add(12 * time.Hour)},
+			expected: []string{"@:6"},
+			partial:  true,
+		},
+		{
+			name:  "only rollups that cannot be counted",
+			query: types.StatsQuery{Bucket: types.StatsBucketMinute, StartTime: statsTestTime.Add(-2 * time.Hour), EndTime: statsTestTime},
+			err:   true,
+		},
+		{
+			name:     "no rollups in the window",
+			query:    types.StatsQuery{Bucket: types.StatsBucketMinute, StartTime: statsTestTime},
+			expected: []string{"@01T12:01:1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := test.query
+			if query.PathDepth == 0 {
+				query.PathDepth = types.StatsPathDepthDefault
+			}
+
+			stats, partial, err := ComputeTransferStats(&query, newStatsTestStorage(t))
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", statsTestResult(stats))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result := statsTestResult(stats); !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("expected stats %v, got %v", test.expected, result)
+			}
+
+			if (len(partial) > 0) != test.partial {
+				t.Errorf("expected partial %t, got %q", test.partial, partial)
+			}
+		})
+	}
+}
@@ -2,216 +2,112 @@ package service
 
 import (
 	"fmt"
-	"sort"
-	"sync"
 	"time"
 
 	"github.com/cyverse/irodsfs-monitor/types"
-	log "github.com/sirupsen/logrus"
 )
 
-const (
-	DataLifeSpanDays = 7
-)
-
-// Storage is a storage object
-type Storage struct {
-	Instances     map[string]types.ReportInstance
-	FileTransfers map[string][]types.ReportFileTransfer
-	Mutex         sync.Mutex
-}
-
-// NewStorage creates a storage
-func NewStorage() *Storage {
-	return &Storage{
-		Instances:     map[string]types.ReportInstance{},
-		FileTransfers: map[string][]types.ReportFileTransfer{},
-		Mutex:         sync.Mutex{},
-	}
+// Storage is an interface for storage backends that keep instance and transfer data
+type Storage interface {
+	// Init initializes the storage
+	Init() error
+	// Destroy destroys the storage
+	Destroy()
+
+	// ListInstances lists instances
+	ListInstances() []types.ReportInstance
+	// GetInstance returns instance
+	GetInstance(instanceID string) (types.ReportInstance, bool)
+	// AddInstance adds an instance
+	AddInstance(instance types.ReportInstance) error
+	// ClaimInstance adds an instance unless the stored instance is reported by another reporter, takeOver replaces the reporter anyway
+	ClaimInstance(instance types.ReportInstance, takeOver bool) error
+	// UpdateInstanceLastActivityTime updates the instance's last activity time to the given time, a stale or lost instance becomes active
+	UpdateInstanceLastActivityTime(instanceID string, activityTime time.Time) error
+	// SetInstanceState sets the state of the instance unless it is terminated
+	// It returns InstanceActivityChangedError if the last activity time is not lastActivityTime, which is not checked if zero
+	SetInstanceState(instanceID string, state string, lastActivityTime time.Time) error
+	// TerminateInstance sets the instance terminated at the given time
+	TerminateInstance(instanceID string, terminationTime time.Time) error
+
+	// ListFileTransfers lists all file transfers
+	ListFileTransfers() []types.ReportFileTransfer
+	// ListFileTransfersForInstance lists file transfers of an instance in sequence order
+	ListFileTransfersForInstance(instanceID string) []types.ReportFileTransfer
+	// GetFileTransfer returns the file transfer of the instance with the sequence number
+	GetFileTransfer(instanceID string, sequence int64) (types.ReportFileTransfer, bool)
+	// AddFileTransfer adds a file transfer with the next sequence number of its instance
+	AddFileTransfer(transfer types.ReportFileTransfer) error
+	// AddFileTransfers adds file transfers at once, it returns an error for each transfer, nil if added
+	AddFileTransfers(transfers []types.ReportFileTransfer) []error
+	// LastTransferSequence returns the sequence number of the last transfer added to the instance, also if the transfer was removed
+	LastTransferSequence(instanceID string) int64
+	// RestoreFileTransfers adds file transfers of an instance keeping their sequence numbers, and sets the last sequence number
+	RestoreFileTransfers(instanceID string, transfers []types.ReportFileTransfer, lastSequence int64) error
+
+	// ListRollups lists rollups of the resolution, or all rollups if resolution is empty
+	ListRollups(resolution string) []types.TransferRollup
+	// AddRollups adds counts of the rollups to stored rollups of the same resolution, time bucket and dimensions
+	AddRollups(rollups []types.TransferRollup) error
+
+	// CleanUp clears all instance, transfer and rollup data
+	CleanUp()
+	// ClearOld clears instance and transfer data older than given days at the given time, transfers are summarized in rollups
+	ClearOld(daysOld int, now time.Time)
+	// ApplyRetention removes data that the policy does not retain at the given time, transfers are summarized in rollups
+	ApplyRetention(policy *RetentionPolicy, now time.Time) RetentionResult
 }
 
-// Init initializes the storage
-func (storage *Storage) Init() error {
-	logger := log.WithFields(log.Fields{
-		"package":  "service",
-		"function": "Storage.Destroy",
-	})
-
-	logger.Info("Initializing the storage")
-
-	return nil
+// InstanceClaimedError is returned when an instance is reported by another reporter
+type InstanceClaimedError struct {
+	InstanceID string
 }
 
-// Destroy destroys the storage
-func (storage *Storage) Destroy() {
-	logger := log.WithFields(log.Fields{
-		"package":  "service",
-		"function": "Storage.Destroy",
-	})
-
-	logger.Info("Destroying the storage")
+// Error returns the error message
+func (err *InstanceClaimedError) Error() string {
+	return fmt.Sprintf("instance %s is reported by another reporter", err.InstanceID)
 }
 
-// ListInstances lists instances
-func (storage *Storage) ListInstances() []types.ReportInstance {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	result := []types.ReportInstance{}
-	for _, v := range storage.Instances {
-		result = append(result, v)
-	}
-
-	sort.SliceStable(result, func(i int, j int) bool {
-		return result[i].CreationTime.Before(result[j].CreationTime)
-	})
-
-	return result
+// InstanceActivityChangedError is returned when an instance state is decided on an old last activity time
+type InstanceActivityChangedError struct {
+	InstanceID string
 }
 
-// GetInstance returns instance
-func (storage *Storage) GetInstance(instanceID string) (types.ReportInstance, bool) {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	if v, ok := storage.Instances[instanceID]; ok {
-		return v, true
-	}
-
-	return types.ReportInstance{}, false
-}
-
-// AddInstance adds an instance
-func (storage *Storage) AddInstance(instance types.ReportInstance) {
-	// clear old
-	storage.clearAWeekOld()
-
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	storage.Instances[instance.InstanceID] = instance
-}
-
-// UpdateInstanceLastActivityTime updates the instance's last activity time
-func (storage *Storage) UpdateInstanceLastActivityTime(instanceID string) error {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	if instance, ok := storage.Instances[instanceID]; ok {
-		instance.LastActivityTime = time.Now().UTC()
-		storage.Instances[instanceID] = instance
-		return nil
-	}
-
-	return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+// Error returns the error message
+func (err *InstanceActivityChangedError) Error() string {
+	return fmt.Sprintf("instance %s had activity after its state was decided", err.InstanceID)
 }
 
-// TerminateInstance sets the instance terminated
-func (storage *Storage) TerminateInstance(instanceID string) error {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	if instance, ok := storage.Instances[instanceID]; ok {
-		instance.Terminated = true
-		instance.LastActivityTime = time.Now().UTC()
-		instance.TerminationTime = time.Now().UTC()
-		storage.Instances[instanceID] = instance
+// checkInstanceActivity checks if the instance's last activity time is the expected one, zero expects any time
+func checkInstanceActivity(instance *types.ReportInstance, lastActivityTime time.Time) error {
+	if lastActivityTime.IsZero() || instance.LastActivityTime.Equal(lastActivityTime) {
 		return nil
 	}
 
-	return fmt.Errorf("unable to find an instance for ID %s", instanceID)
-}
-
-// ListInstances lists instances
-func (storage *Storage) ListFileTransfers() []types.ReportFileTransfer {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	result := []types.ReportFileTransfer{}
-	for _, v := range storage.FileTransfers {
-		result = append(result, v...)
-	}
-
-	return result
-}
-
-// ListInstances lists instances
-func (storage *Storage) ListFileTransfersForInstance(instanceID string) []types.ReportFileTransfer {
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	if v, ok := storage.FileTransfers[instanceID]; ok {
-		return v
+	return &InstanceActivityChangedError{
+		InstanceID: instance.InstanceID,
 	}
-
-	return []types.ReportFileTransfer{}
 }
 
-// AddInstance adds an instance
-func (storage *Storage) AddFileTransfer(transfer types.ReportFileTransfer) error {
-	// clear old
-	storage.clearAWeekOld()
-
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	if _, ok := storage.Instances[transfer.InstanceID]; ok {
-		if existingList, ok2 := storage.FileTransfers[transfer.InstanceID]; ok2 {
-			existingList = append(existingList, transfer)
-			storage.FileTransfers[transfer.InstanceID] = existingList
-		} else {
-			storage.FileTransfers[transfer.InstanceID] = []types.ReportFileTransfer{transfer}
-		}
+// instanceClaimable checks if the instance may be stored over the existing instance, instances without a reporter are claimable
+func instanceClaimable(existing types.ReportInstance, instance types.ReportInstance, takeOver bool) error {
+	if takeOver || existing.ReporterID == "" || existing.ReporterID == instance.ReporterID {
 		return nil
 	}
 
-	return fmt.Errorf("unable to find an instance for ID %s", transfer.InstanceID)
-}
-
-// CleanUp clears all instance and transfer data
-func (storage *Storage) CleanUp() {
-	logger := log.WithFields(log.Fields{
-		"package":  "service",
-		"function": "Storage.CleanUp",
-	})
-
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	storage.Instances = map[string]types.ReportInstance{}
-	storage.FileTransfers = map[string][]types.ReportFileTransfer{}
-
-	logger.Info("Cleaned up storage")
-}
-
-// clearOld clears old instance and transfer data
-func (storage *Storage) clearOld(daysOld int) {
-	logger := log.WithFields(log.Fields{
-		"package":  "service",
-		"function": "Storage.clearOld",
-	})
-
-	storage.Mutex.Lock()
-	defer storage.Mutex.Unlock()
-
-	instanceIDToBeRemoved := []string{}
-	lastWeek := time.Now().AddDate(0, 0, -1*daysOld)
-	for instanceID, instance := range storage.Instances {
-		if instance.CreationTime.Before(lastWeek) {
-			// delete
-			instanceIDToBeRemoved = append(instanceIDToBeRemoved, instanceID)
-		}
-	}
-
-	for _, instanceID := range instanceIDToBeRemoved {
-		delete(storage.FileTransfers, instanceID)
-		delete(storage.Instances, instanceID)
+	return &InstanceClaimedError{
+		InstanceID: instance.InstanceID,
 	}
-
-	logger.Infof("Cleaned up old data that are %d days old", daysOld)
 }
 
-// clearAWeekOld clears old instance and transfer data
-func (storage *Storage) clearAWeekOld() {
-	storage.clearOld(DataLifeSpanDays)
+// NewStorage creates a storage for the storage type given in config
+func NewStorage(config *Config) (Storage, error) {
+	switch config.StorageType {
+	case StorageTypeMemory, "":
+		return NewMemoryStorage(), nil
+	case StorageTypeBolt:
+		return NewBoltStorage(config.StoragePath), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %s", config.StorageType)
+	}
 }
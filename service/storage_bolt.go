@@ -0,0 +1,808 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltInstancesBucket = []byte("instances")
+	boltTransfersBucket = []byte("transfers")
+	boltRollupsBucket   = []byte("rollups")
+)
+
+// BoltStorage is a storage object that keeps data in an embedded BoltDB file
+type BoltStorage struct {
+	Path string
+	DB   *bolt.DB
+}
+
+// NewBoltStorage creates a bolt storage
+func NewBoltStorage(path string) *BoltStorage {
+	return &BoltStorage{
+		Path: path,
+		DB:   nil,
+	}
+}
+
+// Init initializes the storage
+func (storage *BoltStorage) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.Init",
+	})
+
+	logger.Infof("Initializing the bolt storage at %s", storage.Path)
+
+	dirPath := filepath.Dir(storage.Path)
+	err := os.MkdirAll(dirPath, 0700)
+	if err != nil {
+		logger.WithError(err).Errorf("Could not create a storage directory %s", dirPath)
+		return err
+	}
+
+	db, err := bolt.Open(storage.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		logger.WithError(err).Errorf("Could not open a bolt storage %s", storage.Path)
+		return err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltInstancesBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(boltTransfersBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(boltRollupsBucket)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Error("Could not create buckets")
+		db.Close()
+		return err
+	}
+
+	storage.DB = db
+	return nil
+}
+
+// Destroy destroys the storage
+func (storage *BoltStorage) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.Destroy",
+	})
+
+	logger.Info("Destroying the bolt storage")
+
+	if storage.DB != nil {
+		err := storage.DB.Close()
+		if err != nil {
+			logger.Error(err)
+		}
+		storage.DB = nil
+	}
+}
+
+// ListInstances lists instances
+func (storage *BoltStorage) ListInstances() []types.ReportInstance {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ListInstances",
+	})
+
+	result := []types.ReportInstance{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltInstancesBucket).ForEach(func(k []byte, v []byte) error {
+			var instance types.ReportInstance
+			err := json.Unmarshal(v, &instance)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, instance)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	sort.SliceStable(result, func(i int, j int) bool {
+		return result[i].CreationTime.Before(result[j].CreationTime)
+	})
+
+	return result
+}
+
+// GetInstance returns instance
+func (storage *BoltStorage) GetInstance(instanceID string) (types.ReportInstance, bool) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.GetInstance",
+	})
+
+	var instance types.ReportInstance
+	found := false
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltInstancesBucket).Get([]byte(instanceID))
+		if v == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(v, &instance)
+	})
+	if err != nil {
+		logger.Error(err)
+		return types.ReportInstance{}, false
+	}
+
+	return instance, found
+}
+
+// AddInstance adds an instance
+func (storage *BoltStorage) AddInstance(instance types.ReportInstance) error {
+	instanceBytes, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltInstancesBucket).Put([]byte(instance.InstanceID), instanceBytes)
+	})
+}
+
+// ClaimInstance adds an instance unless the stored instance is reported by another reporter, takeOver replaces the reporter anyway
+func (storage *BoltStorage) ClaimInstance(instance types.ReportInstance, takeOver bool) error {
+	instanceBytes, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltInstancesBucket)
+		if v := bucket.Get([]byte(instance.InstanceID)); v != nil {
+			var existing types.ReportInstance
+			err := json.Unmarshal(v, &existing)
+			if err != nil {
+				return err
+			}
+
+			err = instanceClaimable(existing, instance, takeOver)
+			if err != nil {
+				return err
+			}
+		}
+
+		return bucket.Put([]byte(instance.InstanceID), instanceBytes)
+	})
+}
+
+// updateInstance applies the update function to a stored instance, nothing is stored if it returns an error
+func (storage *BoltStorage) updateInstance(instanceID string, update func(instance *types.ReportInstance) error) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltInstancesBucket)
+		v := bucket.Get([]byte(instanceID))
+		if v == nil {
+			return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+		}
+
+		var instance types.ReportInstance
+		err := json.Unmarshal(v, &instance)
+		if err != nil {
+			return err
+		}
+
+		err = update(&instance)
+		if err != nil {
+			return err
+		}
+
+		instanceBytes, err := json.Marshal(instance)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(instanceID), instanceBytes)
+	})
+}
+
+// UpdateInstanceLastActivityTime updates the instance's last activity time to the given time, a stale or lost instance becomes active
+func (storage *BoltStorage) UpdateInstanceLastActivityTime(instanceID string, activityTime time.Time) error {
+	return storage.updateInstance(instanceID, func(instance *types.ReportInstance) error {
+		instance.LastActivityTime = activityTime
+		if instance.State == types.InstanceStateStale || instance.State == types.InstanceStateLost {
+			instance.State = types.InstanceStateActive
+		}
+		return nil
+	})
+}
+
+// SetInstanceState sets the state of the instance unless it is terminated
+// It returns InstanceActivityChangedError if the last activity time is not lastActivityTime, which is not checked if zero
+func (storage *BoltStorage) SetInstanceState(instanceID string, state string, lastActivityTime time.Time) error {
+	return storage.updateInstance(instanceID, func(instance *types.ReportInstance) error {
+		err := checkInstanceActivity(instance, lastActivityTime)
+		if err != nil {
+			return err
+		}
+
+		if !instance.Terminated {
+			instance.State = state
+		}
+		return nil
+	})
+}
+
+// TerminateInstance sets the instance terminated at the given time
+func (storage *BoltStorage) TerminateInstance(instanceID string, terminationTime time.Time) error {
+	return storage.updateInstance(instanceID, func(instance *types.ReportInstance) error {
+		instance.Terminated = true
+		instance.State = types.InstanceStateTerminated
+		instance.LastActivityTime = terminationTime
+		instance.TerminationTime = terminationTime
+		return nil
+	})
+}
+
+// ListFileTransfers lists all file transfers
+func (storage *BoltStorage) ListFileTransfers() []types.ReportFileTransfer {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ListFileTransfers",
+	})
+
+	result := []types.ReportFileTransfer{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		transfersBucket := tx.Bucket(boltTransfersBucket)
+		return transfersBucket.ForEach(func(k []byte, v []byte) error {
+			instanceBucket := transfersBucket.Bucket(k)
+			if instanceBucket == nil {
+				return nil
+			}
+
+			transfers, err := boltReadTransfers(instanceBucket)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, transfers...)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	return result
+}
+
+// ListFileTransfersForInstance lists file transfers of an instance
+func (storage *BoltStorage) ListFileTransfersForInstance(instanceID string) []types.ReportFileTransfer {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ListFileTransfersForInstance",
+	})
+
+	result := []types.ReportFileTransfer{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		instanceBucket := tx.Bucket(boltTransfersBucket).Bucket([]byte(instanceID))
+		if instanceBucket == nil {
+			return nil
+		}
+
+		transfers, err := boltReadTransfers(instanceBucket)
+		if err != nil {
+			return err
+		}
+
+		result = transfers
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	return result
+}
+
+// GetFileTransfer returns the file transfer of the instance with the sequence number
+func (storage *BoltStorage) GetFileTransfer(instanceID string, sequence int64) (types.ReportFileTransfer, bool) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.GetFileTransfer",
+	})
+
+	var transfer types.ReportFileTransfer
+	found := false
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		instanceBucket := tx.Bucket(boltTransfersBucket).Bucket([]byte(instanceID))
+		if instanceBucket == nil || sequence <= 0 {
+			return nil
+		}
+
+		key := boltSequenceKey(uint64(sequence))
+		v := instanceBucket.Get(key)
+		if v == nil {
+			return nil
+		}
+
+		found = true
+		return boltDecodeTransfer(key, v, &transfer)
+	})
+	if err != nil {
+		logger.Error(err)
+		return types.ReportFileTransfer{}, false
+	}
+
+	return transfer, found
+}
+
+// AddFileTransfer adds a file transfer with the next sequence number of its instance
+func (storage *BoltStorage) AddFileTransfer(transfer types.ReportFileTransfer) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		return boltAddFileTransfer(tx, transfer)
+	})
+}
+
+// AddFileTransfers adds file transfers at once, it returns an error for each transfer, nil if added
+func (storage *BoltStorage) AddFileTransfers(transfers []types.ReportFileTransfer) []error {
+	errs := make([]error, len(transfers))
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		instancesBucket := tx.Bucket(boltInstancesBucket)
+
+		for i, transfer := range transfers {
+			if instancesBucket.Get([]byte(transfer.InstanceID)) == nil {
+				errs[i] = fmt.Errorf("unable to find an instance for ID %s", transfer.InstanceID)
+				continue
+			}
+
+			err := boltAddFileTransfer(tx, transfer)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// the transaction is rolled back, nothing is added
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+
+	return errs
+}
+
+// LastTransferSequence returns the sequence number of the last transfer added to the instance, also if the transfer was removed
+func (storage *BoltStorage) LastTransferSequence(instanceID string) int64 {
+	var sequence int64
+	storage.DB.View(func(tx *bolt.Tx) error {
+		if instanceBucket := tx.Bucket(boltTransfersBucket).Bucket([]byte(instanceID)); instanceBucket != nil {
+			sequence = int64(instanceBucket.Sequence())
+		}
+		return nil
+	})
+
+	return sequence
+}
+
+// RestoreFileTransfers adds file transfers of an instance keeping their sequence numbers, and sets the last sequence number
+func (storage *BoltStorage) RestoreFileTransfers(instanceID string, transfers []types.ReportFileTransfer, lastSequence int64) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltInstancesBucket).Get([]byte(instanceID)) == nil {
+			return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+		}
+
+		instanceBucket, err := tx.Bucket(boltTransfersBucket).CreateBucketIfNotExists([]byte(instanceID))
+		if err != nil {
+			return err
+		}
+
+		sequence := int64(instanceBucket.Sequence())
+		if lastSequence > sequence {
+			sequence = lastSequence
+		}
+
+		for _, transfer := range transfers {
+			if transfer.Sequence <= 0 {
+				return fmt.Errorf("transfer of instance %s has no sequence number", instanceID)
+			}
+
+			transferBytes, err := json.Marshal(transfer)
+			if err != nil {
+				return err
+			}
+
+			err = instanceBucket.Put(boltSequenceKey(uint64(transfer.Sequence)), transferBytes)
+			if err != nil {
+				return err
+			}
+
+			if transfer.Sequence > sequence {
+				sequence = transfer.Sequence
+			}
+		}
+
+		return instanceBucket.SetSequence(uint64(sequence))
+	})
+}
+
+// boltAddFileTransfer adds a file transfer with the next sequence number of its instance bucket
+func boltAddFileTransfer(tx *bolt.Tx, transfer types.ReportFileTransfer) error {
+	if tx.Bucket(boltInstancesBucket).Get([]byte(transfer.InstanceID)) == nil {
+		return fmt.Errorf("unable to find an instance for ID %s", transfer.InstanceID)
+	}
+
+	instanceBucket, err := tx.Bucket(boltTransfersBucket).CreateBucketIfNotExists([]byte(transfer.InstanceID))
+	if err != nil {
+		return err
+	}
+
+	seq, err := instanceBucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	transfer.Sequence = int64(seq)
+	transferBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+
+	return instanceBucket.Put(boltSequenceKey(seq), transferBytes)
+}
+
+// CleanUp clears all instance, transfer and rollup data
+func (storage *BoltStorage) CleanUp() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.CleanUp",
+	})
+
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		for _, bucketName := range [][]byte{boltInstancesBucket, boltTransfersBucket, boltRollupsBucket} {
+			err := tx.DeleteBucket(bucketName)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.CreateBucket(bucketName)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	logger.Info("Cleaned up storage")
+}
+
+// ClearOld clears instance and transfer data older than given days at the given time
+func (storage *BoltStorage) ClearOld(daysOld int, now time.Time) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ClearOld",
+	})
+
+	lastWeek := now.AddDate(0, 0, -1*daysOld)
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		instancesBucket := tx.Bucket(boltInstancesBucket)
+		transfersBucket := tx.Bucket(boltTransfersBucket)
+		rollupsBucket := tx.Bucket(boltRollupsBucket)
+
+		instanceIDToBeRemoved := [][]byte{}
+		instancesToBeRemoved := []types.ReportInstance{}
+		err := instancesBucket.ForEach(func(k []byte, v []byte) error {
+			var instance types.ReportInstance
+			err := json.Unmarshal(v, &instance)
+			if err != nil {
+				return err
+			}
+
+			if instance.CreationTime.Before(lastWeek) {
+				// delete
+				instanceIDToBeRemoved = append(instanceIDToBeRemoved, append([]byte{}, k...))
+				instancesToBeRemoved = append(instancesToBeRemoved, instance)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for idx, instanceID := range instanceIDToBeRemoved {
+			if instanceBucket := transfersBucket.Bucket(instanceID); instanceBucket != nil {
+				transfers, err := boltReadTransfers(instanceBucket)
+				if err != nil {
+					return err
+				}
+
+				err = boltAddRollups(rollupsBucket, rollupTransfers(&instancesToBeRemoved[idx], transfers))
+				if err != nil {
+					return err
+				}
+
+				err = transfersBucket.DeleteBucket(instanceID)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = instancesBucket.Delete(instanceID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	logger.Infof("Cleaned up old data that are %d days old", daysOld)
+}
+
+// ApplyRetention removes instance and transfer data that the policy does not retain at the given time
+func (storage *BoltStorage) ApplyRetention(policy *RetentionPolicy, now time.Time) RetentionResult {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ApplyRetention",
+	})
+
+	result := RetentionResult{}
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		instancesBucket := tx.Bucket(boltInstancesBucket)
+		transfersBucket := tx.Bucket(boltTransfersBucket)
+		rollupsBucket := tx.Bucket(boltRollupsBucket)
+
+		instancesToBeRemoved := []types.ReportInstance{}
+		instancesRetained := []types.ReportInstance{}
+		err := instancesBucket.ForEach(func(k []byte, v []byte) error {
+			var instance types.ReportInstance
+			err := json.Unmarshal(v, &instance)
+			if err != nil {
+				return err
+			}
+
+			if policy.InstanceExpired(&instance, now) {
+				instancesToBeRemoved = append(instancesToBeRemoved, instance)
+			} else {
+				instancesRetained = append(instancesRetained, instance)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for idx := range instancesToBeRemoved {
+			instanceID := []byte(instancesToBeRemoved[idx].InstanceID)
+			if instanceBucket := transfersBucket.Bucket(instanceID); instanceBucket != nil {
+				transfers, err := boltReadTransfers(instanceBucket)
+				if err != nil {
+					return err
+				}
+
+				err = boltAddRollups(rollupsBucket, rollupTransfers(&instancesToBeRemoved[idx], transfers))
+				if err != nil {
+					return err
+				}
+
+				result.TransfersRemoved += len(transfers)
+
+				err = transfersBucket.DeleteBucket(instanceID)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = instancesBucket.Delete(instanceID)
+			if err != nil {
+				return err
+			}
+			result.InstancesRemoved++
+		}
+
+		for idx := range instancesRetained {
+			instanceBucket := transfersBucket.Bucket([]byte(instancesRetained[idx].InstanceID))
+			if instanceBucket == nil {
+				continue
+			}
+
+			keys := [][]byte{}
+			transfers := []types.ReportFileTransfer{}
+			err = instanceBucket.ForEach(func(k []byte, v []byte) error {
+				var transfer types.ReportFileTransfer
+				err := boltDecodeTransfer(k, v, &transfer)
+				if err != nil {
+					return err
+				}
+
+				keys = append(keys, append([]byte{}, k...))
+				transfers = append(transfers, transfer)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			retain := policy.RetainTransfers(transfers, now)
+			removed := []types.ReportFileTransfer{}
+			for i, key := range keys {
+				if retain[i] {
+					continue
+				}
+
+				err = instanceBucket.Delete(key)
+				if err != nil {
+					return err
+				}
+				removed = append(removed, transfers[i])
+			}
+
+			if len(removed) > 0 {
+				err = boltAddRollups(rollupsBucket, rollupTransfers(&instancesRetained[idx], removed))
+				if err != nil {
+					return err
+				}
+				result.TransfersRemoved += len(removed)
+			}
+		}
+
+		rollupKeysToBeRemoved := [][]byte{}
+		err = rollupsBucket.ForEach(func(k []byte, v []byte) error {
+			var rollup types.TransferRollup
+			err := json.Unmarshal(v, &rollup)
+			if err != nil {
+				return err
+			}
+
+			if policy.RollupExpired(&rollup, now) {
+				rollupKeysToBeRemoved = append(rollupKeysToBeRemoved, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range rollupKeysToBeRemoved {
+			err = rollupsBucket.Delete(key)
+			if err != nil {
+				return err
+			}
+			result.RollupsRemoved++
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+		return RetentionResult{}
+	}
+
+	return result
+}
+
+// ListRollups lists rollups of the resolution, or all rollups if resolution is empty
+func (storage *BoltStorage) ListRollups(resolution string) []types.TransferRollup {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "BoltStorage.ListRollups",
+	})
+
+	result := []types.TransferRollup{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRollupsBucket).ForEach(func(k []byte, v []byte) error {
+			var rollup types.TransferRollup
+			err := json.Unmarshal(v, &rollup)
+			if err != nil {
+				return err
+			}
+
+			if len(resolution) == 0 || rollup.Resolution == resolution {
+				result = append(result, rollup)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	sortRollups(result)
+	return result
+}
+
+// AddRollups adds counts of the rollups to stored rollups of the same resolution, time bucket and dimensions
+func (storage *BoltStorage) AddRollups(rollups []types.TransferRollup) error {
+	return storage.DB.Update(func(tx *bolt.Tx) error {
+		return boltAddRollups(tx.Bucket(boltRollupsBucket), rollups)
+	})
+}
+
+// boltAddRollups merges rollups into the rollups bucket
+func boltAddRollups(rollupsBucket *bolt.Bucket, rollups []types.TransferRollup) error {
+	for _, rollup := range rollups {
+		key := []byte(rollupKey(&rollup))
+		if v := rollupsBucket.Get(key); v != nil {
+			var existing types.TransferRollup
+			err := json.Unmarshal(v, &existing)
+			if err != nil {
+				return err
+			}
+
+			rollup.FileCount += existing.FileCount
+			rollup.TotalTransferSize += existing.TotalTransferSize
+		}
+
+		rollupBytes, err := json.Marshal(rollup)
+		if err != nil {
+			return err
+		}
+
+		err = rollupsBucket.Put(key, rollupBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltReadTransfers reads all transfers in an instance bucket in sequence order
+func boltReadTransfers(instanceBucket *bolt.Bucket) ([]types.ReportFileTransfer, error) {
+	result := []types.ReportFileTransfer{}
+	err := instanceBucket.ForEach(func(k []byte, v []byte) error {
+		var transfer types.ReportFileTransfer
+		err := boltDecodeTransfer(k, v, &transfer)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, transfer)
+		return nil
+	})
+
+	return result, err
+}
+
+// boltDecodeTransfer decodes a stored transfer
+// Transfers stored before sequence numbers were recorded take theirs from the key
+func boltDecodeTransfer(k []byte, v []byte, transfer *types.ReportFileTransfer) error {
+	err := json.Unmarshal(v, transfer)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Sequence == 0 && len(k) == 8 {
+		transfer.Sequence = int64(binary.BigEndian.Uint64(k))
+	}
+	return nil
+}
+
+// boltSequenceKey makes a key that sorts in sequence order
+func boltSequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
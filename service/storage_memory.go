@@ -0,0 +1,411 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// MemoryStorage is a storage object that keeps data in memory
+type MemoryStorage struct {
+	Instances     map[string]types.ReportInstance
+	FileTransfers map[string][]types.ReportFileTransfer
+	// TransferSequences are sequence numbers of the last transfers added to instances
+	TransferSequences map[string]int64
+	Rollups           map[string]types.TransferRollup
+	Mutex             sync.Mutex
+}
+
+// NewMemoryStorage creates a memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		Instances:         map[string]types.ReportInstance{},
+		FileTransfers:     map[string][]types.ReportFileTransfer{},
+		TransferSequences: map[string]int64{},
+		Rollups:           map[string]types.TransferRollup{},
+		Mutex:             sync.Mutex{},
+	}
+}
+
+// Init initializes the storage
+func (storage *MemoryStorage) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MemoryStorage.Init",
+	})
+
+	logger.Info("Initializing the memory storage")
+
+	return nil
+}
+
+// Destroy destroys the storage
+func (storage *MemoryStorage) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MemoryStorage.Destroy",
+	})
+
+	logger.Info("Destroying the memory storage")
+}
+
+// ListInstances lists instances
+func (storage *MemoryStorage) ListInstances() []types.ReportInstance {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	result := []types.ReportInstance{}
+	for _, v := range storage.Instances {
+		result = append(result, v)
+	}
+
+	sort.SliceStable(result, func(i int, j int) bool {
+		return result[i].CreationTime.Before(result[j].CreationTime)
+	})
+
+	return result
+}
+
+// GetInstance returns instance
+func (storage *MemoryStorage) GetInstance(instanceID string) (types.ReportInstance, bool) {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if v, ok := storage.Instances[instanceID]; ok {
+		return v, true
+	}
+
+	return types.ReportInstance{}, false
+}
+
+// AddInstance adds an instance
+func (storage *MemoryStorage) AddInstance(instance types.ReportInstance) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	storage.Instances[instance.InstanceID] = instance
+	return nil
+}
+
+// ClaimInstance adds an instance unless the stored instance is reported by another reporter, takeOver replaces the reporter anyway
+func (storage *MemoryStorage) ClaimInstance(instance types.ReportInstance, takeOver bool) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if existing, ok := storage.Instances[instance.InstanceID]; ok {
+		err := instanceClaimable(existing, instance, takeOver)
+		if err != nil {
+			return err
+		}
+	}
+
+	storage.Instances[instance.InstanceID] = instance
+	return nil
+}
+
+// UpdateInstanceLastActivityTime updates the instance's last activity time to the given time, a stale or lost instance becomes active
+func (storage *MemoryStorage) UpdateInstanceLastActivityTime(instanceID string, activityTime time.Time) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if instance, ok := storage.Instances[instanceID]; ok {
+		instance.LastActivityTime = activityTime
+		if instance.State == types.InstanceStateStale || instance.State == types.InstanceStateLost {
+			instance.State = types.InstanceStateActive
+		}
+		storage.Instances[instanceID] = instance
+		return nil
+	}
+
+	return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+}
+
+// SetInstanceState sets the state of the instance unless it is terminated
+// It returns InstanceActivityChangedError if the last activity time is not lastActivityTime, which is not checked if zero
+func (storage *MemoryStorage) SetInstanceState(instanceID string, state string, lastActivityTime time.Time) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if instance, ok := storage.Instances[instanceID]; ok {
+		err := checkInstanceActivity(&instance, lastActivityTime)
+		if err != nil {
+			return err
+		}
+
+		if !instance.Terminated {
+			instance.State = state
+			storage.Instances[instanceID] = instance
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+}
+
+// TerminateInstance sets the instance terminated at the given time
+func (storage *MemoryStorage) TerminateInstance(instanceID string, terminationTime time.Time) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if instance, ok := storage.Instances[instanceID]; ok {
+		instance.Terminated = true
+		instance.State = types.InstanceStateTerminated
+		instance.LastActivityTime = terminationTime
+		instance.TerminationTime = terminationTime
+		storage.Instances[instanceID] = instance
+		return nil
+	}
+
+	return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+}
+
+// ListFileTransfers lists all file transfers
+func (storage *MemoryStorage) ListFileTransfers() []types.ReportFileTransfer {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	result := []types.ReportFileTransfer{}
+	for _, v := range storage.FileTransfers {
+		result = append(result, v...)
+	}
+
+	return result
+}
+
+// ListFileTransfersForInstance lists file transfers of an instance
+func (storage *MemoryStorage) ListFileTransfersForInstance(instanceID string) []types.ReportFileTransfer {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if v, ok := storage.FileTransfers[instanceID]; ok {
+		return v
+	}
+
+	return []types.ReportFileTransfer{}
+}
+
+// GetFileTransfer returns the file transfer of the instance with the sequence number
+func (storage *MemoryStorage) GetFileTransfer(instanceID string, sequence int64) (types.ReportFileTransfer, bool) {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	transfers := storage.FileTransfers[instanceID]
+	// transfers are kept in sequence order
+	idx := sort.Search(len(transfers), func(i int) bool {
+		return transfers[i].Sequence >= sequence
+	})
+
+	if idx < len(transfers) && transfers[idx].Sequence == sequence {
+		return transfers[idx], true
+	}
+
+	return types.ReportFileTransfer{}, false
+}
+
+// AddFileTransfer adds a file transfer with the next sequence number of its instance
+func (storage *MemoryStorage) AddFileTransfer(transfer types.ReportFileTransfer) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	return storage.addFileTransfer(transfer)
+}
+
+// AddFileTransfers adds file transfers at once, it returns an error for each transfer, nil if added
+func (storage *MemoryStorage) AddFileTransfers(transfers []types.ReportFileTransfer) []error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	errs := make([]error, len(transfers))
+	for i, transfer := range transfers {
+		errs[i] = storage.addFileTransfer(transfer)
+	}
+
+	return errs
+}
+
+// addFileTransfer adds a file transfer, the caller must hold the lock
+func (storage *MemoryStorage) addFileTransfer(transfer types.ReportFileTransfer) error {
+	if _, ok := storage.Instances[transfer.InstanceID]; !ok {
+		return fmt.Errorf("unable to find an instance for ID %s", transfer.InstanceID)
+	}
+
+	storage.TransferSequences[transfer.InstanceID]++
+	transfer.Sequence = storage.TransferSequences[transfer.InstanceID]
+	storage.FileTransfers[transfer.InstanceID] = append(storage.FileTransfers[transfer.InstanceID], transfer)
+	return nil
+}
+
+// LastTransferSequence returns the sequence number of the last transfer added to the instance, also if the transfer was removed
+func (storage *MemoryStorage) LastTransferSequence(instanceID string) int64 {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	return storage.TransferSequences[instanceID]
+}
+
+// RestoreFileTransfers adds file transfers of an instance keeping their sequence numbers, and sets the last sequence number
+func (storage *MemoryStorage) RestoreFileTransfers(instanceID string, transfers []types.ReportFileTransfer, lastSequence int64) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	if _, ok := storage.Instances[instanceID]; !ok {
+		return fmt.Errorf("unable to find an instance for ID %s", instanceID)
+	}
+
+	// never nil, so instances without transfers list an empty slice
+	restored := append([]types.ReportFileTransfer{}, storage.FileTransfers[instanceID]...)
+	restored = append(restored, transfers...)
+	sort.SliceStable(restored, func(i int, j int) bool {
+		return restored[i].Sequence < restored[j].Sequence
+	})
+
+	for _, transfer := range restored {
+		if transfer.Sequence > lastSequence {
+			lastSequence = transfer.Sequence
+		}
+	}
+
+	storage.FileTransfers[instanceID] = restored
+	if lastSequence > storage.TransferSequences[instanceID] {
+		storage.TransferSequences[instanceID] = lastSequence
+	}
+	return nil
+}
+
+// CleanUp clears all instance, transfer and rollup data
+func (storage *MemoryStorage) CleanUp() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MemoryStorage.CleanUp",
+	})
+
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	storage.Instances = map[string]types.ReportInstance{}
+	storage.FileTransfers = map[string][]types.ReportFileTransfer{}
+	storage.TransferSequences = map[string]int64{}
+	storage.Rollups = map[string]types.TransferRollup{}
+
+	logger.Info("Cleaned up storage")
+}
+
+// ClearOld clears instance and transfer data older than given days at the given time
+func (storage *MemoryStorage) ClearOld(daysOld int, now time.Time) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "MemoryStorage.ClearOld",
+	})
+
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	instanceIDToBeRemoved := []string{}
+	lastWeek := now.AddDate(0, 0, -1*daysOld)
+	for instanceID, instance := range storage.Instances {
+		if instance.CreationTime.Before(lastWeek) {
+			// delete
+			instanceIDToBeRemoved = append(instanceIDToBeRemoved, instanceID)
+		}
+	}
+
+	for _, instanceID := range instanceIDToBeRemoved {
+		instance := storage.Instances[instanceID]
+		storage.addRollups(rollupTransfers(&instance, storage.FileTransfers[instanceID]))
+
+		delete(storage.FileTransfers, instanceID)
+		delete(storage.TransferSequences, instanceID)
+		delete(storage.Instances, instanceID)
+	}
+
+	logger.Infof("Cleaned up old data that are %d days old", daysOld)
+}
+
+// ApplyRetention removes instance and transfer data that the policy does not retain at the given time
+func (storage *MemoryStorage) ApplyRetention(policy *RetentionPolicy, now time.Time) RetentionResult {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	result := RetentionResult{}
+	for instanceID, instance := range storage.Instances {
+		transfers := storage.FileTransfers[instanceID]
+
+		if policy.InstanceExpired(&instance, now) {
+			storage.addRollups(rollupTransfers(&instance, transfers))
+
+			result.InstancesRemoved++
+			result.TransfersRemoved += len(transfers)
+			delete(storage.FileTransfers, instanceID)
+			delete(storage.TransferSequences, instanceID)
+			delete(storage.Instances, instanceID)
+			continue
+		}
+
+		retain := policy.RetainTransfers(transfers, now)
+		retained := []types.ReportFileTransfer{}
+		removed := []types.ReportFileTransfer{}
+		for i, transfer := range transfers {
+			if retain[i] {
+				retained = append(retained, transfer)
+			} else {
+				removed = append(removed, transfer)
+			}
+		}
+
+		if len(removed) > 0 {
+			storage.addRollups(rollupTransfers(&instance, removed))
+			result.TransfersRemoved += len(transfers) - len(retained)
+			storage.FileTransfers[instanceID] = retained
+		}
+	}
+
+	for key, rollup := range storage.Rollups {
+		if policy.RollupExpired(&rollup, now) {
+			delete(storage.Rollups, key)
+			result.RollupsRemoved++
+		}
+	}
+
+	return result
+}
+
+// ListRollups lists rollups of the resolution, or all rollups if resolution is empty
+func (storage *MemoryStorage) ListRollups(resolution string) []types.TransferRollup {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	result := []types.TransferRollup{}
+	for _, rollup := range storage.Rollups {
+		if len(resolution) == 0 || rollup.Resolution == resolution {
+			result = append(result, rollup)
+		}
+	}
+
+	sortRollups(result)
+	return result
+}
+
+// AddRollups adds counts of the rollups to stored rollups of the same resolution, time bucket and dimensions
+func (storage *MemoryStorage) AddRollups(rollups []types.TransferRollup) error {
+	storage.Mutex.Lock()
+	defer storage.Mutex.Unlock()
+
+	storage.addRollups(rollups)
+	return nil
+}
+
+// addRollups merges rollups, the caller must hold the lock
+func (storage *MemoryStorage) addRollups(rollups []types.TransferRollup) {
+	for _, rollup := range rollups {
+		key := rollupKey(&rollup)
+		if existing, ok := storage.Rollups[key]; ok {
+			rollup.FileCount += existing.FileCount
+			rollup.TotalTransferSize += existing.TotalTransferSize
+		}
+		storage.Rollups[key] = rollup
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TLSReloadCheckPeriod time.Duration = 10 * time.Second
+)
+
+// tlsNextProtos are protocols negotiated with ALPN, the server adds them to its own config
+// but not to configs returned by GetConfigForClient, so HTTP/2 needs them there as well
+var tlsNextProtos = []string{"h2", "http/1.1"}
+
+// TLSReloader provides TLS configuration that is reloaded when certificate files change on disk
+type TLSReloader struct {
+	CertPath     string
+	KeyPath      string
+	ClientCAPath string
+
+	config        *tls.Config
+	modTimes      map[string]time.Time
+	lastCheckTime time.Time
+	mutex         sync.Mutex
+}
+
+// NewTLSReloader creates a TLS reloader and loads certificates
+func NewTLSReloader(certPath string, keyPath string, clientCAPath string) (*TLSReloader, error) {
+	reloader := &TLSReloader{
+		CertPath:     certPath,
+		KeyPath:      keyPath,
+		ClientCAPath: clientCAPath,
+
+		config:        nil,
+		modTimes:      map[string]time.Time{},
+		lastCheckTime: time.Time{},
+		mutex:         sync.Mutex{},
+	}
+
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *TLSReloader) paths() []string {
+	paths := []string{reloader.CertPath, reloader.KeyPath}
+	if len(reloader.ClientCAPath) > 0 {
+		paths = append(paths, reloader.ClientCAPath)
+	}
+	return paths
+}
+
+// load reads certificate files and builds TLS configuration
+func (reloader *TLSReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, path := range reloader.paths() {
+		fileinfo, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = fileinfo.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.CertPath, reloader.KeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate - %v", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   tlsNextProtos,
+	}
+
+	if len(reloader.ClientCAPath) > 0 {
+		caBytes, err := ioutil.ReadFile(reloader.ClientCAPath)
+		if err != nil {
+			return err
+		}
+
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("failed to load client CA certificates from %s", reloader.ClientCAPath)
+		}
+
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	reloader.config = config
+	reloader.modTimes = modTimes
+	return nil
+}
+
+// changed checks if any certificate file is modified since loaded
+func (reloader *TLSReloader) changed() bool {
+	for _, path := range reloader.paths() {
+		fileinfo, err := os.Stat(path)
+		if err != nil {
+			// keep serving with loaded certificates while files are being replaced
+			return false
+		}
+
+		if !fileinfo.ModTime().Equal(reloader.modTimes[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetConfigForClient returns TLS configuration for a new connection, reloading it if files have changed
+func (reloader *TLSReloader) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "TLSReloader.GetConfigForClient",
+	})
+
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(reloader.lastCheckTime) >= TLSReloadCheckPeriod {
+		reloader.lastCheckTime = now
+
+		if reloader.changed() {
+			err := reloader.load()
+			if err != nil {
+				logger.WithError(err).Error("Could not reload certificates, keep using loaded certificates")
+			} else {
+				logger.Info("Reloaded certificates")
+			}
+		}
+	}
+
+	return reloader.config, nil
+}
+
+// GetCertificate returns the loaded server certificate
+func (reloader *TLSReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	return &reloader.config.Certificates[0], nil
+}
+
+// TLSConfig returns TLS configuration for a server
+func (reloader *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		NextProtos:         tlsNextProtos,
+		GetCertificate:     reloader.GetCertificate,
+		GetConfigForClient: reloader.GetConfigForClient,
+	}
+}
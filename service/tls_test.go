@@ -0,0 +1,102 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tlsTestCertificate writes a self-signed certificate for 127.0.0.1 and its key
+func tlsTestCertificate(t *testing.T, dirPath string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "irodsfs-monitor"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dirPath, "cert.pem")
+	keyPath := filepath.Join(dirPath, "key.pem")
+
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+// TestTLSReloaderNegotiatesProtocols checks that clients negotiate HTTP/2 with configs of the reloader
+func TestTLSReloaderNegotiatesProtocols(t *testing.T) {
+	certPath, keyPath := tlsTestCertificate(t, t.TempDir())
+
+	reloader, err := NewTLSReloader(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		TLSConfig: reloader.TLSConfig(),
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	for _, protocol := range tlsNextProtos {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			NextProtos:         []string{protocol},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		negotiated := conn.ConnectionState().NegotiatedProtocol
+		conn.Close()
+
+		if negotiated != protocol {
+			t.Errorf("expected protocol %s to be negotiated, got %q", protocol, negotiated)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// uiFiles are static files of the dashboard, built into the binary
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// newUIHandler creates a handler that serves the dashboard under /ui/
+// Static files are served without authentication, the dashboard sends a token to the APIs
+func newUIHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		// the directory is embedded at build time
+		panic(err)
+	}
+
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(files)))
+}
+
+func (svc *MonitorService) redirectUI(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+}
+
+func (svc *MonitorService) serveUI(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithFields(log.Fields{
+			"package":  "service",
+			"function": "MonitorService.serveUI",
+		})
+
+		logger.Debugf("Page access request (%s) from %s to %s", r.Method, r.RemoteAddr, r.RequestURI)
+
+		handler.ServeHTTP(w, r)
+	}
+}
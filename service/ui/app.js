@@ -0,0 +1,511 @@
+// Dashboard of the iRODS FUSE Lite Monitor, built on the JSON APIs of the service
+"use strict";
+
+// APIs are relative to /ui/, so the dashboard also works behind a reverse proxy with a path prefix
+const apiBase = "..";
+const refreshPeriod = 30000;
+const tokenKey = "irodsfs-monitor-token";
+
+const ranges = {
+  "1h": { label: "1 hour", duration: 3600 * 1000, bucket: "minute", bucketSize: 60 * 1000 },
+  "24h": { label: "24 hours", duration: 24 * 3600 * 1000, bucket: "hour", bucketSize: 3600 * 1000 },
+  "7d": { label: "7 days", duration: 7 * 24 * 3600 * 1000, bucket: "day", bucketSize: 24 * 3600 * 1000 },
+};
+
+let selectedRange = "24h";
+let refreshTimer = null;
+
+function escapeHTML(value) {
+  return String(value === undefined || value === null ? "" : value)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;")
+    .replace(/'/g, "&#39;");
+}
+
+function formatSize(size) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB", "PiB"];
+  let value = size || 0;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return (unit === 0 ? Math.round(value) : value.toFixed(1)) + " " + units[unit];
+}
+
+function formatTime(value) {
+  if (!value || value.startsWith("0001-")) {
+    return "";
+  }
+  return new Date(value).toLocaleString();
+}
+
+function formatNumber(value) {
+  return (value || 0).toLocaleString();
+}
+
+function isoTime(ms) {
+  return new Date(ms).toISOString();
+}
+
+function showError(message) {
+  const error = document.getElementById("error");
+  error.textContent = message;
+  error.hidden = !message;
+}
+
+// fetchAPI returns the response of a GET request, errors carry the status and the response text
+async function fetchAPI(path) {
+  const headers = {};
+  const token = localStorage.getItem(tokenKey);
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+
+  const resp = await fetch(apiBase + path, { headers: headers });
+  if (resp.status === 401 || resp.status === 403) {
+    throw new Error("Access denied, save an access token with the reader role");
+  }
+
+  if (!resp.ok) {
+    const text = await resp.text();
+    const err = new Error(path + ": " + resp.status + " " + text);
+    err.status = resp.status;
+    err.text = text;
+    throw err;
+  }
+
+  return resp;
+}
+
+async function api(path) {
+  const resp = await fetchAPI(path);
+  return resp.json();
+}
+
+// statsAPI queries transfer stats, stats that do not count some transfers removed by retention have the reason in .partial
+// The service rejects queries that only transfers removed by retention are in, those are returned as {unavailable: reason}
+// so the rest of the page still renders
+async function statsAPI(path) {
+  try {
+    const resp = await fetchAPI(path);
+    const stats = await resp.json();
+    stats.partial = resp.headers.get("X-Stats-Partial") || "";
+    return stats;
+  } catch (err) {
+    if (err.status === 400) {
+      return { unavailable: err.text };
+    }
+    throw err;
+  }
+}
+
+// partialNote tells that stats do not count some transfers removed by retention
+function partialNote(stats) {
+  if (!stats.partial) {
+    return "";
+  }
+  return '<div class="partial">Partial: ' + escapeHTML(stats.partial) + "</div>";
+}
+
+function stateBadge(state) {
+  return '<span class="state ' + escapeHTML(state) + '">' + escapeHTML(state) + "</span>";
+}
+
+function instanceHost(instance) {
+  return instance.client_hostname || instance.client_host_ip || "";
+}
+
+function instanceLink(instanceID) {
+  return '<a href="#/instances/' + encodeURIComponent(instanceID) + '">' + escapeHTML(instanceID) + "</a>";
+}
+
+function table(columns, rows, rowAttributes) {
+  let html = "<table><thead><tr>";
+  for (const column of columns) {
+    html += "<th" + (column.num ? ' class="num"' : "") + ">" + escapeHTML(column.label) + "</th>";
+  }
+  html += "</tr></thead><tbody>";
+
+  if (rows.length === 0) {
+    html += '<tr><td colspan="' + columns.length + '">none</td></tr>';
+  }
+
+  rows.forEach(function (row, idx) {
+    html += "<tr" + (rowAttributes ? rowAttributes(row, idx) : "") + ">";
+    for (const column of columns) {
+      const classes = [];
+      if (column.num) {
+        classes.push("num");
+      }
+      if (column.path) {
+        classes.push("path");
+      }
+      html += "<td" + (classes.length ? ' class="' + classes.join(" ") + '"' : "") + ">" + column.render(row, idx) + "</td>";
+    }
+    html += "</tr>";
+  });
+
+  return html + "</tbody></table>";
+}
+
+// throughputChart draws an SVG bar chart of bytes per second in each bucket of the range
+function throughputChart(stats, range) {
+  const now = Date.now();
+  const start = Math.floor((now - range.duration) / range.bucketSize) * range.bucketSize;
+  const bytes = {};
+  for (const entry of stats) {
+    if (entry.bucket_start) {
+      bytes[new Date(entry.bucket_start).getTime()] = entry.total_transfer_size;
+    }
+  }
+
+  const buckets = [];
+  for (let t = start; t <= now; t += range.bucketSize) {
+    buckets.push({ time: t, rate: (bytes[t] || 0) / (range.bucketSize / 1000) });
+  }
+
+  const width = 800;
+  const height = 200;
+  const left = 70;
+  const bottom = 20;
+  const max = Math.max.apply(null, buckets.map(function (b) { return b.rate; }).concat([1]));
+  const barWidth = (width - left) / buckets.length;
+
+  let svg = '<svg class="chart" viewBox="0 0 ' + width + " " + height + '" preserveAspectRatio="none">';
+  for (const fraction of [0, 0.5, 1]) {
+    const y = (height - bottom) * (1 - fraction);
+    svg += '<line x1="' + left + '" x2="' + width + '" y1="' + y + '" y2="' + y + '" stroke="#eee"/>';
+    svg += '<text x="' + (left - 4) + '" y="' + (y + 4) + '" text-anchor="end">' + escapeHTML(formatSize(max * fraction)) + "/s</text>";
+  }
+
+  buckets.forEach(function (bucket, idx) {
+    const barHeight = (height - bottom) * bucket.rate / max;
+    const x = left + idx * barWidth;
+    svg += '<rect class="bar" x="' + x + '" y="' + (height - bottom - barHeight) + '" width="' + Math.max(barWidth - 1, 1) + '" height="' + barHeight + '">';
+    svg += "<title>" + escapeHTML(new Date(bucket.time).toLocaleString() + ": " + formatSize(bucket.rate) + "/s") + "</title></rect>";
+  });
+
+  const labelEvery = Math.ceil(buckets.length / 6);
+  buckets.forEach(function (bucket, idx) {
+    if (idx % labelEvery === 0) {
+      const date = new Date(bucket.time);
+      const label = range.bucket === "day" ? date.toLocaleDateString() : date.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+      svg += '<text x="' + (left + idx * barWidth) + '" y="' + (height - 4) + '">' + escapeHTML(label) + "</text>";
+    }
+  });
+
+  return svg + "</svg>";
+}
+
+// drawBlockMap draws transfer blocks on a canvas over the file size, from blue (first accessed) to orange (last accessed)
+// Bytes accessed more than once are drawn darker
+function drawBlockMap(canvas, transfer) {
+  const blocks = (transfer.transfer_blocks || []).filter(function (block) { return block.length > 0; });
+  const rect = canvas.getBoundingClientRect();
+  canvas.width = Math.max(Math.floor(rect.width), 1);
+  canvas.height = Math.max(Math.floor(rect.height), 1);
+
+  const context = canvas.getContext("2d");
+  context.clearRect(0, 0, canvas.width, canvas.height);
+  if (blocks.length === 0) {
+    return;
+  }
+
+  let fileSize = transfer.file_size;
+  for (const block of blocks) {
+    fileSize = Math.max(fileSize, block.offset + block.length);
+  }
+
+  const ordered = blocks.slice().sort(function (a, b) {
+    return new Date(a.access_time) - new Date(b.access_time);
+  });
+
+  context.globalAlpha = 0.6;
+  ordered.forEach(function (block, idx) {
+    const hue = 215 - 185 * (ordered.length > 1 ? idx / (ordered.length - 1) : 0);
+    context.fillStyle = "hsl(" + hue + ", 75%, 50%)";
+    const x = block.offset / fileSize * canvas.width;
+    const w = Math.max(block.length / fileSize * canvas.width, 1);
+    context.fillRect(x, 0, w, canvas.height);
+  });
+}
+
+function drawBlockMaps(container) {
+  for (const canvas of container.querySelectorAll("canvas.blockmap")) {
+    drawBlockMap(canvas, canvas.transfer);
+  }
+}
+
+function transferMode(transfer) {
+  return escapeHTML(transfer.file_open_mode) + (transfer.error ? ' <span class="state lost">error</span>' : "");
+}
+
+async function renderOverview(view) {
+  const range = ranges[selectedRange];
+  // stats are aligned to buckets, so transfers removed by retention are counted when the range is hourly or daily
+  const start = isoTime(Math.floor((Date.now() - range.duration) / range.bucketSize) * range.bucketSize);
+
+  const [instances, alerts, totals, throughput, users, files, transfers] = await Promise.all([
+    api("/instances"),
+    api("/alerts?state=firing"),
+    statsAPI("/stats/transfers?start=" + encodeURIComponent(start)),
+    statsAPI("/stats/transfers?bucket=" + range.bucket + "&start=" + encodeURIComponent(start)),
+    statsAPI("/stats/transfers?group_by=user&start=" + encodeURIComponent(start)),
+    api("/stats/top?limit=10&start=" + encodeURIComponent(start)),
+    api("/transfers?sort=file_open_time&order=desc&limit=25"),
+  ]);
+
+  const counts = { active: 0, stale: 0, lost: 0, terminated: 0 };
+  for (const instance of instances) {
+    counts[instance.state] = (counts[instance.state] || 0) + 1;
+  }
+
+  const total = !totals.unavailable && totals.length > 0 ? totals[0] : { file_count: 0, total_transfer_size: 0 };
+  const instanceUsers = {};
+  for (const instance of instances) {
+    instanceUsers[instance.instance_id] = instance.client_user;
+  }
+
+  let html = '<div class="cards">';
+  html += '<div class="card"><div class="value">' + formatNumber(counts.active) + '</div><div class="label">active instances</div></div>';
+  html += '<div class="card"><div class="value">' + formatNumber(counts.stale + counts.lost) + '</div><div class="label">stale or lost instances</div></div>';
+  html += '<div class="card"><div class="value">' + (totals.unavailable ? "n/a" : formatNumber(total.file_count)) + '</div><div class="label">transfers in ' + range.label + "</div></div>";
+  html += '<div class="card"><div class="value">' + (totals.unavailable ? "n/a" : formatSize(total.total_transfer_size)) + '</div><div class="label">transferred in ' + range.label + "</div></div>";
+  html += '<div class="card' + (alerts.length > 0 ? " alert" : "") + '"><div class="value">' + formatNumber(alerts.length) + '</div><div class="label">firing alerts</div></div>';
+  html += "</div>";
+  if (!totals.unavailable) {
+    html += partialNote(totals);
+  }
+
+  if (alerts.length > 0) {
+    html += "<h2>Firing alerts</h2><div class=\"panel\">";
+    html += table([
+      { label: "Severity", render: function (a) { return escapeHTML(a.severity); } },
+      { label: "Alert", render: function (a) { return escapeHTML(a.id); } },
+      { label: "Message", render: function (a) { return escapeHTML(a.message); } },
+      { label: "Since", render: function (a) { return escapeHTML(formatTime(a.start_time)); } },
+    ], alerts);
+    html += "</div>";
+  }
+
+  html += '<h2>Throughput <span class="range">';
+  for (const key of Object.keys(ranges)) {
+    html += '<button data-range="' + key + '"' + (key === selectedRange ? ' class="selected"' : "") + ">" + ranges[key].label + "</button>";
+  }
+  html += '</span></h2><div class="panel">' + (throughput.unavailable ? escapeHTML(throughput.unavailable) : throughputChart(throughput, range) + partialNote(throughput)) + "</div>";
+
+  const topUsers = users.unavailable ? [] : users;
+  topUsers.sort(function (a, b) { return b.total_transfer_size - a.total_transfer_size; });
+
+  html += '<div class="columns"><div><h2>Top users</h2><div class="panel">';
+  html += table([
+    { label: "User", render: function (u) { return escapeHTML(u.group); } },
+    { label: "Files", num: true, render: function (u) { return formatNumber(u.file_count); } },
+    { label: "Transferred", num: true, render: function (u) { return formatSize(u.total_transfer_size); } },
+  ], topUsers.slice(0, 10));
+  if (!users.unavailable) {
+    html += partialNote(users);
+  }
+  html += '</div></div><div><h2>Top files</h2><div class="panel">';
+  html += table([
+    { label: "Path", path: true, render: function (f) { return '<span title="' + escapeHTML(f.path) + '">' + escapeHTML(f.path) + "</span>"; } },
+    { label: "Opens", num: true, render: function (f) { return formatNumber(f.open_count); } },
+    { label: "Transferred", num: true, render: function (f) { return formatSize(f.transfer_size); } },
+  ], files);
+  html += "</div></div></div>";
+
+  const active = instances.filter(function (i) { return i.state !== "terminated"; });
+  active.sort(function (a, b) { return new Date(b.last_activity_time) - new Date(a.last_activity_time); });
+
+  html += '<h2>Running instances</h2><div class="panel">';
+  html += table([
+    { label: "Instance", render: function (i) { return instanceLink(i.instance_id); } },
+    { label: "State", render: function (i) { return stateBadge(i.state); } },
+    { label: "User", render: function (i) { return escapeHTML(i.client_user); } },
+    { label: "Host", render: function (i) { return escapeHTML(instanceHost(i)); } },
+    { label: "Zone", render: function (i) { return escapeHTML(i.zone); } },
+    { label: "Created", render: function (i) { return escapeHTML(formatTime(i.creation_time)); } },
+    { label: "Last activity", render: function (i) { return escapeHTML(formatTime(i.last_activity_time)); } },
+  ], active);
+  html += "</div>";
+
+  html += '<h2>Recent transfers</h2><div class="panel">';
+  html += table([
+    { label: "Opened", render: function (t) { return escapeHTML(formatTime(t.file_open_time)); } },
+    { label: "Instance", render: function (t) { return instanceLink(t.instance_id); } },
+    { label: "User", render: function (t) { return escapeHTML(instanceUsers[t.instance_id]); } },
+    { label: "Path", path: true, render: function (t) { return '<span title="' + escapeHTML(t.file_path) + '">' + escapeHTML(t.file_path) + "</span>"; } },
+    { label: "Mode", render: transferMode },
+    { label: "Transferred", num: true, render: function (t) { return formatSize(t.transfer_size); } },
+  ], transfers);
+  html += "</div>";
+
+  view.innerHTML = html;
+
+  for (const button of view.querySelectorAll(".range button")) {
+    button.addEventListener("click", function () {
+      selectedRange = button.dataset.range;
+      render();
+    });
+  }
+}
+
+function configDetails(instance) {
+  const fields = [
+    ["User", instance.client_user],
+    ["Proxy user", instance.proxy_user],
+    ["Host", instanceHost(instance)],
+    ["iRODS", instance.host + ":" + instance.port + " (zone " + instance.zone + ")"],
+    ["Auth scheme", instance.auth_scheme],
+    ["Pool address", instance.pool_address],
+    ["Read-ahead max", instance.read_ahead_max],
+    ["Buffer size max", formatSize(instance.buffer_size_max)],
+    ["Connection max", instance.connection_max],
+    ["Operation timeout", instance.operation_timeout],
+    ["Connection idle timeout", instance.connection_idle_timeout],
+    ["Metadata cache timeout", instance.metadata_cache_timeout],
+    ["Created", formatTime(instance.creation_time)],
+    ["Last activity", formatTime(instance.last_activity_time)],
+    ["Terminated", formatTime(instance.termination_time)],
+  ];
+
+  let html = '<dl class="details">';
+  for (const field of fields) {
+    html += "<dt>" + escapeHTML(field[0]) + "</dt><dd>" + escapeHTML(field[1]) + "</dd>";
+  }
+  return html + "</dl>";
+}
+
+async function renderTransferDetail(container, instanceID, transfer) {
+  let html = "<h2>" + escapeHTML(transfer.file_path) + "</h2>";
+  html += '<div class="panel"><canvas class="blockmap large"></canvas>';
+  html += '<p class="legend">Blocks over the file size of ' + formatSize(transfer.file_size) + ", from blue (accessed first) to orange (accessed last), darker where accessed more than once.</p>";
+  html += '<div class="analysis">analyzing...</div></div>';
+  container.innerHTML = html;
+
+  const canvas = container.querySelector("canvas");
+  canvas.transfer = transfer;
+  drawBlockMap(canvas, transfer);
+
+  try {
+    const analysis = await api("/stats/access?file_path=" + encodeURIComponent(transfer.file_path) + "&instance_id=" + encodeURIComponent(instanceID));
+    container.querySelector(".analysis").innerHTML = '<dl class="details">' +
+      "<dt>Pattern of the path on this instance</dt><dd>" + escapeHTML(analysis.pattern) + (analysis.stride ? " (stride " + formatSize(analysis.stride) + ")" : "") + "</dd>" +
+      "<dt>Transfers</dt><dd>" + formatNumber(analysis.transfer_count) + "</dd>" +
+      "<dt>Coverage</dt><dd>" + (analysis.coverage * 100).toFixed(1) + "%</dd>" +
+      "<dt>Re-read amplification</dt><dd>" + (analysis.reread_amplification || 0).toFixed(2) + "</dd>" +
+      "</dl>";
+  } catch (err) {
+    container.querySelector(".analysis").textContent = err.message;
+  }
+}
+
+async function renderInstance(view, instanceID) {
+  const path = encodeURIComponent(instanceID);
+  const [instance, transfers] = await Promise.all([
+    api("/instances/" + path),
+    api("/transfers/" + path + "?sort=file_open_time&order=desc&limit=100"),
+  ]);
+
+  let recommendations = null;
+  try {
+    recommendations = await api("/instances/" + path + "/recommendations");
+  } catch (err) {
+    recommendations = null;
+  }
+
+  let html = '<p><a href="#/">&larr; Overview</a></p>';
+  html += "<h2>Instance " + escapeHTML(instanceID) + " " + stateBadge(instance.state) + "</h2>";
+  html += '<div class="columns"><div class="panel">' + configDetails(instance) + "</div>";
+
+  html += '<div class="panel"><h2>Recommendations</h2>';
+  if (recommendations && recommendations.recommendations.length > 0) {
+    html += table([
+      { label: "Setting", render: function (r) { return escapeHTML(r.setting); } },
+      { label: "Current", render: function (r) { return escapeHTML(r.current); } },
+      { label: "Recommended", render: function (r) { return escapeHTML(r.recommended); } },
+      { label: "Reason", render: function (r) { return escapeHTML(r.reason); } },
+    ], recommendations.recommendations);
+  } else if (recommendations && recommendations.note) {
+    html += "<p>" + escapeHTML(recommendations.note) + "</p>";
+  } else {
+    html += "<p>Settings look fine for the observed transfers.</p>";
+  }
+  html += "</div></div>";
+
+  html += '<h2>Transfers</h2><div class="panel">';
+  html += table([
+    { label: "Opened", render: function (t) { return escapeHTML(formatTime(t.file_open_time)); } },
+    { label: "Path", path: true, render: function (t) { return '<span title="' + escapeHTML(t.file_path) + '">' + escapeHTML(t.file_path) + "</span>"; } },
+    { label: "Mode", render: transferMode },
+    { label: "File size", num: true, render: function (t) { return formatSize(t.file_size); } },
+    { label: "Transferred", num: true, render: function (t) { return formatSize(t.transfer_size); } },
+    { label: "Blocks", render: function (t, idx) { return '<canvas class="blockmap" data-index="' + idx + '"></canvas>'; } },
+  ], transfers, function (t, idx) { return ' class="clickable" data-index="' + idx + '"'; });
+  html += '</div><div id="transfer-detail"></div>';
+
+  view.innerHTML = html;
+
+  for (const canvas of view.querySelectorAll("canvas.blockmap")) {
+    canvas.transfer = transfers[Number(canvas.dataset.index)];
+  }
+  drawBlockMaps(view);
+
+  for (const row of view.querySelectorAll("tr.clickable")) {
+    row.addEventListener("click", function () {
+      for (const selected of view.querySelectorAll("tr.selected")) {
+        selected.classList.remove("selected");
+      }
+      row.classList.add("selected");
+
+      const detail = document.getElementById("transfer-detail");
+      renderTransferDetail(detail, instanceID, transfers[Number(row.dataset.index)]);
+      detail.scrollIntoView({ behavior: "smooth" });
+    });
+  }
+}
+
+async function render() {
+  const view = document.getElementById("view");
+  const hash = location.hash || "#/";
+
+  clearTimeout(refreshTimer);
+
+  try {
+    const match = hash.match(/^#\/instances\/(.+)$/);
+    if (match) {
+      await renderInstance(view, decodeURIComponent(match[1]));
+    } else {
+      await renderOverview(view);
+      // only the overview refreshes, so a selected transfer stays open
+      refreshTimer = setTimeout(render, refreshPeriod);
+    }
+
+    showError("");
+    document.getElementById("updated").textContent = "updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    showError(err.message);
+  }
+}
+
+document.getElementById("token").value = localStorage.getItem(tokenKey) || "";
+document.getElementById("token-form").addEventListener("submit", function (event) {
+  event.preventDefault();
+  const token = document.getElementById("token").value.trim();
+  if (token) {
+    localStorage.setItem(tokenKey, token);
+  } else {
+    localStorage.removeItem(tokenKey);
+  }
+  render();
+});
+
+window.addEventListener("hashchange", render);
+window.addEventListener("resize", function () {
+  drawBlockMaps(document.getElementById("view"));
+});
+
+render();
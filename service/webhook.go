@@ -0,0 +1,452 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	WebhookEventHeader     string = "X-Irodsfs-Monitor-Event"
+	WebhookDeliveryHeader  string = "X-Irodsfs-Monitor-Delivery"
+	WebhookSignatureHeader string = "X-Irodsfs-Monitor-Signature"
+
+	webhookBackoffBase time.Duration = 5 * time.Second
+	webhookBackoffMax  time.Duration = 1 * time.Hour
+	webhookPollPeriod  time.Duration = 1 * time.Second
+	// webhookEventBufferSize is the number of published events that wait to be queued, publishing blocks when it is full
+	webhookEventBufferSize int = 1024
+)
+
+// WebhookDelivery is a pending delivery of an event to a webhook
+type WebhookDelivery struct {
+	ID              string      `json:"id"`
+	WebhookName     string      `json:"webhook_name"`
+	Event           types.Event `json:"event"`
+	Attempts        int         `json:"attempts"`
+	NextAttemptTime time.Time   `json:"next_attempt_time"`
+	LastError       string      `json:"last_error,omitempty"`
+}
+
+// WebhookDispatcher delivers events to webhooks, pending deliveries are persisted in a queue directory
+// Events are handed over while they are published and queued in the background, so a burst of reports never drops them
+type WebhookDispatcher struct {
+	Webhooks   map[string]WebhookConfig
+	QueuePath  string
+	MaxRetries int
+
+	broker        *EventBroker
+	handler       *EventHandler
+	httpClient    *http.Client
+	events        chan types.Event
+	queue         map[string]*WebhookDelivery
+	mutex         sync.Mutex
+	terminateChan chan bool
+	waitGroup     sync.WaitGroup
+}
+
+// webhookName returns the name of the webhook, the URL is used if the name is not given
+func webhookName(webhook *WebhookConfig) string {
+	if len(webhook.Name) > 0 {
+		return webhook.Name
+	}
+	return webhook.URL
+}
+
+// NewWebhookDispatcher creates a webhook dispatcher that receives events from the broker
+func NewWebhookDispatcher(config *Config, broker *EventBroker) *WebhookDispatcher {
+	webhooks := map[string]WebhookConfig{}
+	for _, webhook := range config.Webhooks {
+		webhooks[webhookName(&webhook)] = webhook
+	}
+
+	return &WebhookDispatcher{
+		Webhooks:   webhooks,
+		QueuePath:  config.WebhookQueuePath,
+		MaxRetries: config.WebhookMaxRetries,
+
+		broker:  broker,
+		handler: nil,
+		httpClient: &http.Client{
+			Timeout: config.WebhookTimeout,
+		},
+		events:        make(chan types.Event, webhookEventBufferSize),
+		queue:         map[string]*WebhookDelivery{},
+		mutex:         sync.Mutex{},
+		terminateChan: make(chan bool),
+		waitGroup:     sync.WaitGroup{},
+	}
+}
+
+// Init loads pending deliveries and starts delivering
+func (dispatcher *WebhookDispatcher) Init() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.Init",
+	})
+
+	logger.Infof("Initializing the webhook dispatcher with %d webhooks", len(dispatcher.Webhooks))
+
+	err := os.MkdirAll(dispatcher.QueuePath, 0700)
+	if err != nil {
+		logger.WithError(err).Errorf("Could not create a webhook queue directory %s", dispatcher.QueuePath)
+		return err
+	}
+
+	err = dispatcher.loadQueue()
+	if err != nil {
+		logger.WithError(err).Error("Could not load the webhook queue")
+		return err
+	}
+
+	dispatcher.handler = &EventHandler{
+		Handle: dispatcher.receive,
+	}
+	dispatcher.broker.AddHandler(dispatcher.handler)
+
+	dispatcher.waitGroup.Add(2)
+	go dispatcher.enqueueLoop()
+	go dispatcher.deliverLoop()
+
+	return nil
+}
+
+// Destroy stops delivering, received events are queued and pending deliveries stay in the queue
+func (dispatcher *WebhookDispatcher) Destroy() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.Destroy",
+	})
+
+	logger.Info("Destroying the webhook dispatcher")
+
+	if dispatcher.handler == nil {
+		return
+	}
+
+	// no event is received after the handler is removed
+	dispatcher.broker.RemoveHandler(dispatcher.handler)
+	close(dispatcher.events)
+	close(dispatcher.terminateChan)
+	dispatcher.waitGroup.Wait()
+	dispatcher.handler = nil
+}
+
+// Pending returns the number of pending deliveries, and received events that are not queued yet
+func (dispatcher *WebhookDispatcher) Pending() int {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	return len(dispatcher.queue) + len(dispatcher.events)
+}
+
+func (dispatcher *WebhookDispatcher) deliveryPath(deliveryID string) string {
+	return filepath.Join(dispatcher.QueuePath, deliveryID+".json")
+}
+
+func (dispatcher *WebhookDispatcher) loadQueue() error {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.loadQueue",
+	})
+
+	entries, err := ioutil.ReadDir(dispatcher.QueuePath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		deliveryPath := filepath.Join(dispatcher.QueuePath, entry.Name())
+		deliveryBytes, err := ioutil.ReadFile(deliveryPath)
+		if err != nil {
+			return err
+		}
+
+		var delivery WebhookDelivery
+		err = json.Unmarshal(deliveryBytes, &delivery)
+		if err != nil {
+			logger.WithError(err).Warnf("Removing a broken webhook delivery %s", deliveryPath)
+			os.Remove(deliveryPath)
+			continue
+		}
+
+		dispatcher.queue[delivery.ID] = &delivery
+	}
+
+	logger.Infof("Loaded %d pending webhook deliveries", len(dispatcher.queue))
+	return nil
+}
+
+// saveDelivery writes the delivery to the queue directory
+func (dispatcher *WebhookDispatcher) saveDelivery(delivery *WebhookDelivery) error {
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	tempPath := dispatcher.deliveryPath(delivery.ID) + ".tmp"
+	err = ioutil.WriteFile(tempPath, deliveryBytes, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, dispatcher.deliveryPath(delivery.ID))
+}
+
+// receive hands the event over to enqueueLoop, it is called while the event is published
+func (dispatcher *WebhookDispatcher) receive(event *types.Event) {
+	dispatcher.events <- *event
+}
+
+// enqueueLoop queues deliveries of received events until the dispatcher is destroyed
+func (dispatcher *WebhookDispatcher) enqueueLoop() {
+	defer dispatcher.waitGroup.Done()
+
+	for event := range dispatcher.events {
+		dispatcher.enqueue(&event)
+	}
+}
+
+// enqueue persists deliveries of the event to webhooks that match, and queues them
+func (dispatcher *WebhookDispatcher) enqueue(event *types.Event) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.enqueue",
+	})
+
+	deliveries := []*WebhookDelivery{}
+	for name, webhook := range dispatcher.Webhooks {
+		filter := &EventFilter{
+			Types:      webhook.Events,
+			InstanceID: webhook.InstanceID,
+			Zone:       webhook.Zone,
+			ClientUser: webhook.ClientUser,
+		}
+
+		if !filter.Match(event) {
+			continue
+		}
+
+		delivery := &WebhookDelivery{
+			ID:              xid.New().String(),
+			WebhookName:     name,
+			Event:           *event,
+			Attempts:        0,
+			NextAttemptTime: time.Now().UTC(),
+		}
+
+		err := dispatcher.saveDelivery(delivery)
+		if err != nil {
+			logger.WithError(err).Errorf("Could not persist a webhook delivery to %s", name)
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	for _, delivery := range deliveries {
+		dispatcher.queue[delivery.ID] = delivery
+	}
+}
+
+func (dispatcher *WebhookDispatcher) deliverLoop() {
+	defer dispatcher.waitGroup.Done()
+
+	ticker := time.NewTicker(webhookPollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dispatcher.terminateChan:
+			return
+		case <-ticker.C:
+			dispatcher.deliverDue()
+		}
+	}
+}
+
+// dueDeliveries returns deliveries whose next attempt time has come
+func (dispatcher *WebhookDispatcher) dueDeliveries() []*WebhookDelivery {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	now := time.Now()
+	due := []*WebhookDelivery{}
+	for _, delivery := range dispatcher.queue {
+		if !delivery.NextAttemptTime.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	return due
+}
+
+func (dispatcher *WebhookDispatcher) deliverDue() {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.deliverDue",
+	})
+
+	for _, delivery := range dispatcher.dueDeliveries() {
+		select {
+		case <-dispatcher.terminateChan:
+			return
+		default:
+		}
+
+		webhook, ok := dispatcher.Webhooks[delivery.WebhookName]
+		if !ok {
+			logger.Warnf("Dropping a webhook delivery %s to unknown webhook %s", delivery.ID, delivery.WebhookName)
+			dispatcher.finish(delivery)
+			continue
+		}
+
+		err := dispatcher.deliver(&webhook, delivery)
+		if err == nil {
+			dispatcher.finish(delivery)
+			continue
+		}
+
+		dispatcher.retry(delivery, err)
+	}
+}
+
+// finish removes the delivery from the queue
+func (dispatcher *WebhookDispatcher) finish(delivery *WebhookDelivery) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.finish",
+	})
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	delete(dispatcher.queue, delivery.ID)
+
+	err := os.Remove(dispatcher.deliveryPath(delivery.ID))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error(err)
+	}
+}
+
+// retry schedules the next attempt of the delivery with exponential backoff
+func (dispatcher *WebhookDispatcher) retry(delivery *WebhookDelivery, deliveryErr error) {
+	logger := log.WithFields(log.Fields{
+		"package":  "service",
+		"function": "WebhookDispatcher.retry",
+	})
+
+	delivery.Attempts++
+	delivery.LastError = deliveryErr.Error()
+
+	if delivery.Attempts > dispatcher.MaxRetries {
+		logger.WithError(deliveryErr).Errorf("Giving up a webhook delivery %s to %s after %d attempts", delivery.ID, delivery.WebhookName, delivery.Attempts)
+		dispatcher.finish(delivery)
+		return
+	}
+
+	backoff := webhookBackoffBase << uint(delivery.Attempts-1)
+	if backoff > webhookBackoffMax || backoff <= 0 {
+		backoff = webhookBackoffMax
+	}
+
+	logger.WithError(deliveryErr).Warnf("Failed a webhook delivery %s to %s, retrying in %s", delivery.ID, delivery.WebhookName, backoff)
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	delivery.NextAttemptTime = time.Now().UTC().Add(backoff)
+	err := dispatcher.saveDelivery(delivery)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// deliver sends the delivery to the webhook
+func (dispatcher *WebhookDispatcher) deliver(webhook *WebhookConfig, delivery *WebhookDelivery) error {
+	body, err := makeWebhookBody(webhook, &delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	if len(webhook.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(webhook.Secret, body))
+	}
+
+	resp, err := dispatcher.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook error returned - %s", resp.Status)
+	}
+
+	return nil
+}
+
+// SignWebhookBody returns a signature of the body, receivers compute the same to verify deliveries
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// makeWebhookBody makes a request body of the event in the webhook's format
+func makeWebhookBody(webhook *WebhookConfig, event *types.Event) ([]byte, error) {
+	if webhook.Format == WebhookFormatSlack {
+		return json.Marshal(map[string]string{
+			"text": describeEvent(event),
+		})
+	}
+
+	return json.Marshal(event)
+}
+
+// describeEvent returns a human readable summary of the event
+func describeEvent(event *types.Event) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("[%s]", event.Type))
+
+	if event.Transfer != nil {
+		sb.WriteString(fmt.Sprintf(" %s (%s, %d bytes transferred)", event.Transfer.FilePath, event.Transfer.FileOpenMode, event.Transfer.TransferSize))
+		if len(event.Transfer.Error) > 0 {
+			sb.WriteString(fmt.Sprintf(" error: %s", event.Transfer.Error))
+		}
+	}
+
+	if event.Instance != nil {
+		sb.WriteString(fmt.Sprintf(" instance %s of %s on %s (zone %s)", event.Instance.InstanceID, event.Instance.ClientUser, event.Instance.ClientHostname, event.Instance.Zone))
+	}
+
+	return sb.String()
+}
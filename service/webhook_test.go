@@ -0,0 +1,75 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cyverse/irodsfs-monitor/types"
+)
+
+func newWebhookTestDispatcher(t *testing.T, queuePath string, broker *EventBroker) *WebhookDispatcher {
+	config := &Config{
+		Webhooks: []WebhookConfig{
+			// nothing listens on the port, so deliveries stay in the queue
+			{Name: "ops", URL: "http://127.0.0.1:1", Events: []string{types.EventTypeInstanceCreated}},
+		},
+		WebhookQueuePath:  queuePath,
+		WebhookTimeout:    time.Second,
+		WebhookMaxRetries: 10,
+	}
+
+	dispatcher := NewWebhookDispatcher(config, broker)
+	err := dispatcher.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dispatcher
+}
+
+// TestWebhookDispatcherPersistsPublishedEvents checks that events handed over while they are published are queued on disk by Destroy
+func TestWebhookDispatcherPersistsPublishedEvents(t *testing.T) {
+	queuePath := t.TempDir()
+
+	broker := NewEventBroker()
+	dispatcher := newWebhookTestDispatcher(t, queuePath, broker)
+
+	instanceIDs := []string{}
+	for i := 0; i < webhookEventBufferSize*2; i++ {
+		instance := types.ReportInstance{InstanceID: fmt.Sprintf("i%d", i)}
+		broker.Publish(types.Event{Type: types.EventTypeInstanceCreated, Instance: &instance})
+		broker.Publish(types.Event{Type: types.EventTypeInstanceLost, Instance: &instance})
+		instanceIDs = append(instanceIDs, instance.InstanceID)
+	}
+
+	dispatcher.Destroy()
+
+	// events published after Destroy are not received
+	broker.Publish(types.Event{Type: types.EventTypeInstanceCreated, Instance: &types.ReportInstance{InstanceID: "late"}})
+
+	reloaded := newWebhookTestDispatcher(t, queuePath, NewEventBroker())
+	defer reloaded.Destroy()
+
+	reloaded.mutex.Lock()
+	queued := []string{}
+	for _, delivery := range reloaded.queue {
+		if delivery.WebhookName != "ops" || delivery.Event.Type != types.EventTypeInstanceCreated {
+			t.Errorf("unexpected delivery of %s to %s", delivery.Event.Type, delivery.WebhookName)
+		}
+		queued = append(queued, delivery.Event.Instance.InstanceID)
+	}
+	reloaded.mutex.Unlock()
+
+	sort.Strings(instanceIDs)
+	sort.Strings(queued)
+	if len(queued) != len(instanceIDs) {
+		t.Fatalf("expected %d queued deliveries, got %d", len(instanceIDs), len(queued))
+	}
+
+	for i := range queued {
+		if queued[i] != instanceIDs[i] {
+			t.Fatalf("expected deliveries of instances %v, got %v", instanceIDs, queued)
+		}
+	}
+}
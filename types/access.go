@@ -0,0 +1,102 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	AccessPatternSequential  string = "sequential"
+	AccessPatternStrided     string = "strided"
+	AccessPatternRandom      string = "random"
+	AccessPatternReRead      string = "re_read"
+	AccessPatternPartialRead string = "partial_read"
+	// AccessPatternUnknown is for transfers reported without blocks
+	AccessPatternUnknown string = "unknown"
+)
+
+// AccessQuery is a struct used to request access pattern analysis of file transfers on a path
+type AccessQuery struct {
+	FilePath   string
+	InstanceID string
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// NewAccessQueryFromValues creates AccessQuery from URL query values
+func NewAccessQueryFromValues(values url.Values) (*AccessQuery, error) {
+	var err error
+	query := &AccessQuery{
+		FilePath:   values.Get("file_path"),
+		InstanceID: values.Get("instance_id"),
+	}
+
+	if len(query.FilePath) == 0 {
+		return nil, fmt.Errorf("file_path is not given")
+	}
+
+	query.StartTime, err = parseTimeValue(values, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	query.EndTime, err = parseTimeValue(values, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *AccessQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "file_path", query.FilePath)
+	setStringValue(values, "instance_id", query.InstanceID)
+	setTimeValue(values, "start", query.StartTime)
+	setTimeValue(values, "end", query.EndTime)
+	return values
+}
+
+// BlockSizeBucket is a bucket of a block size histogram, sizes are between Min and Max inclusive
+type BlockSizeBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// AccessAnalysis is a struct that holds the access pattern of a file transfer, or of file transfers on a path
+type AccessAnalysis struct {
+	FilePath string `json:"file_path"`
+	// TransferID is given for the analysis of a single transfer
+	TransferID    string `json:"transfer_id,omitempty"`
+	TransferCount int64  `json:"transfer_count"`
+	FileSize      int64  `json:"file_size"`
+	BlockCount    int64  `json:"block_count"`
+
+	// AccessedSize is the sum of block lengths, UniqueSize is the number of distinct bytes accessed
+	AccessedSize int64 `json:"accessed_size"`
+	UniqueSize   int64 `json:"unique_size"`
+	// Coverage is UniqueSize over FileSize
+	Coverage float64 `json:"coverage"`
+	// ReReadAmplification is AccessedSize over UniqueSize, 1 if no byte is accessed twice
+	ReReadAmplification float64 `json:"reread_amplification"`
+
+	// Pattern is the most common of sequential, strided, random or unknown
+	Pattern string `json:"pattern"`
+	// PatternCounts are the numbers of transfers with each pattern, including re_read and partial_read
+	PatternCounts map[string]int64 `json:"pattern_counts"`
+	// Stride is the distance between strided blocks, if the pattern is strided
+	Stride int64 `json:"stride,omitempty"`
+
+	// SequentialAccessReported is the number of transfers reported as sequential by clients,
+	// SequentialAccessObserved is the number of transfers found sequential from their blocks,
+	// and SequentialAccessMismatches is the number of transfers the two disagree
+	SequentialAccessReported   int64 `json:"sequential_access_reported"`
+	SequentialAccessObserved   int64 `json:"sequential_access_observed"`
+	SequentialAccessMismatches int64 `json:"sequential_access_mismatches"`
+
+	BlockSizeHistogram []BlockSizeBucket `json:"block_size_histogram"`
+}
@@ -0,0 +1,50 @@
+package types
+
+import "time"
+
+const (
+	// AlertRuleInstanceInactive fires for each instance without heartbeats or transfers for the rule's duration
+	AlertRuleInstanceInactive string = "instance_inactive"
+	// AlertRuleInstancesPerUser fires for each user running more instances than the threshold
+	AlertRuleInstancesPerUser string = "instances_per_user"
+	// AlertRuleHostTransferSize fires for each host that transferred more bytes than the threshold in the rule's window
+	AlertRuleHostTransferSize string = "host_transfer_size"
+	// AlertRuleTerminatedInstances fires when more instances than the threshold are terminated in the rule's window
+	AlertRuleTerminatedInstances string = "terminated_instances"
+
+	AlertStateFiring   string = "firing"
+	AlertStateResolved string = "resolved"
+
+	AlertSeverityInfo     string = "info"
+	AlertSeverityWarning  string = "warning"
+	AlertSeverityCritical string = "critical"
+)
+
+// AlertRuleTypes are all alert rule types
+var AlertRuleTypes = []string{
+	AlertRuleInstanceInactive,
+	AlertRuleInstancesPerUser,
+	AlertRuleHostTransferSize,
+	AlertRuleTerminatedInstances,
+}
+
+// Alert is a struct that holds the state of an alert raised by a rule
+type Alert struct {
+	// ID is the rule name and the subject, an alert fires once per subject until it is resolved
+	ID       string `json:"id"`
+	Rule     string `json:"rule"`
+	RuleType string `json:"rule_type"`
+	Severity string `json:"severity"`
+	// Subject is the instance ID, user or host the alert is about, empty for rules on the whole fleet
+	Subject string `json:"subject,omitempty"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+
+	// Value is the observed value of the last evaluation that fired, compared with Threshold
+	Value     int64 `json:"value"`
+	Threshold int64 `json:"threshold"`
+
+	StartTime    time.Time `json:"start_time"`
+	LastFireTime time.Time `json:"last_fire_time"`
+	ResolvedTime time.Time `json:"resolved_time,omitempty"` // may be empty
+}
@@ -0,0 +1,69 @@
+package types
+
+const (
+	ConfigFieldOperationTimeout         string = "operation_timeout"
+	ConfigFieldConnectionIdleTimeout    string = "connection_idle_timeout"
+	ConfigFieldConnectionMax            string = "connection_max"
+	ConfigFieldMetadataCacheTimeout     string = "metadata_cache_timeout"
+	ConfigFieldMetadataCacheCleanupTime string = "metadata_cache_cleanup_time"
+	ConfigFieldBufferSizeMax            string = "buffer_size_max"
+	ConfigFieldReadAheadMax             string = "read_ahead_max"
+	ConfigFieldAuthScheme               string = "auth_scheme"
+	ConfigFieldPoolAddress              string = "pool_address"
+
+	// BaselineSourceDeclared is for a baseline given in the service config or the request
+	BaselineSourceDeclared string = "declared"
+	// BaselineSourceMajority is for the configuration of most instances, when no baseline is declared
+	BaselineSourceMajority string = "majority"
+)
+
+// ConfigFields are fields of ReportInstance that make the configuration of an instance, in report order
+var ConfigFields = []string{
+	ConfigFieldOperationTimeout,
+	ConfigFieldConnectionIdleTimeout,
+	ConfigFieldConnectionMax,
+	ConfigFieldMetadataCacheTimeout,
+	ConfigFieldMetadataCacheCleanupTime,
+	ConfigFieldBufferSizeMax,
+	ConfigFieldReadAheadMax,
+	ConfigFieldAuthScheme,
+	ConfigFieldPoolAddress,
+}
+
+// ConfigFieldDrift is a field of a configuration that differs from the baseline
+type ConfigFieldDrift struct {
+	Field    string `json:"field"`
+	Baseline string `json:"baseline"`
+	Actual   string `json:"actual"`
+}
+
+// ConfigGroup is a struct that holds instances with the same configuration
+type ConfigGroup struct {
+	Fingerprint   string             `json:"fingerprint"`
+	Config        map[string]string  `json:"config"`
+	InstanceCount int                `json:"instance_count"`
+	InstanceIDs   []string           `json:"instance_ids"`
+	Hosts         []string           `json:"hosts"`
+	Drift         []ConfigFieldDrift `json:"drift"`
+}
+
+// ConfigOutlier is an instance whose configuration differs from the baseline
+type ConfigOutlier struct {
+	InstanceID     string   `json:"instance_id"`
+	ClientHostname string   `json:"client_hostname"`
+	ClientHostIP   string   `json:"client_host_ip"`
+	Fingerprint    string   `json:"fingerprint"`
+	Fields         []string `json:"fields"`
+}
+
+// ConfigDriftReport is a struct that holds configurations of instances compared with a baseline
+type ConfigDriftReport struct {
+	// Baseline has the fields to compare, fields not in a declared baseline are not compared
+	Baseline       map[string]string `json:"baseline"`
+	BaselineSource string            `json:"baseline_source"`
+	InstanceCount  int               `json:"instance_count"`
+	CompliantCount int               `json:"compliant_count"`
+	// Groups are ordered by the number of instances, the largest first
+	Groups   []ConfigGroup   `json:"groups"`
+	Outliers []ConfigOutlier `json:"outliers"`
+}
@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+const (
+	EventTypeInstanceCreated    string = "instance_created"
+	EventTypeInstanceTerminated string = "instance_terminated"
+	EventTypeInstanceStale      string = "instance_stale"
+	EventTypeInstanceLost       string = "instance_lost"
+	EventTypeFileTransferAdded  string = "file_transfer_added"
+	EventTypeLargeTransfer      string = "large_transfer"
+	EventTypeTransferError      string = "transfer_error"
+)
+
+// EventTypes are all event types
+var EventTypes = []string{
+	EventTypeInstanceCreated,
+	EventTypeInstanceTerminated,
+	EventTypeInstanceStale,
+	EventTypeInstanceLost,
+	EventTypeFileTransferAdded,
+	EventTypeLargeTransfer,
+	EventTypeTransferError,
+}
+
+// Event is a struct used to notify an accepted report
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Instance is the instance that the event is about, or the instance that made the transfer
+	Instance *ReportInstance     `json:"instance,omitempty"`
+	Transfer *ReportFileTransfer `json:"transfer,omitempty"`
+}
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	ExportTableInstances string = "instances"
+	ExportTableTransfers string = "transfers"
+	ExportTableBlocks    string = "blocks"
+
+	ExportFormatCSV     string = "csv"
+	ExportFormatNDJSON  string = "ndjson"
+	ExportFormatParquet string = "parquet"
+)
+
+// ExportQuery is a struct used to request a table of instances, transfers or transfer blocks in a file format
+type ExportQuery struct {
+	// Table is one of ExportTable*
+	Table string
+	// Format is one of ExportFormat*
+	Format string
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// NewExportQueryFromValues creates ExportQuery from URL query values
+func NewExportQueryFromValues(values url.Values) (*ExportQuery, error) {
+	var err error
+	query := &ExportQuery{
+		Table:  values.Get("table"),
+		Format: values.Get("format"),
+	}
+
+	if len(query.Table) == 0 {
+		query.Table = ExportTableTransfers
+	}
+
+	if len(query.Format) == 0 {
+		query.Format = ExportFormatCSV
+	}
+
+	switch query.Table {
+	case ExportTableInstances, ExportTableTransfers, ExportTableBlocks:
+	default:
+		return nil, fmt.Errorf("unknown table %s", query.Table)
+	}
+
+	switch query.Format {
+	case ExportFormatCSV, ExportFormatNDJSON, ExportFormatParquet:
+	default:
+		return nil, fmt.Errorf("unknown format %s", query.Format)
+	}
+
+	query.StartTime, err = parseTimeValue(values, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	query.EndTime, err = parseTimeValue(values, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *ExportQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "table", query.Table)
+	setStringValue(values, "format", query.Format)
+	setTimeValue(values, "start", query.StartTime)
+	setTimeValue(values, "end", query.EndTime)
+	return values
+}
@@ -0,0 +1,336 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// NextCursorHeader is a response header that carries a cursor for the next page
+	NextCursorHeader string = "X-Next-Cursor"
+
+	SortOrderAscending  string = "asc"
+	SortOrderDescending string = "desc"
+)
+
+// InstanceQuery is a struct used to filter, sort and paginate instances
+type InstanceQuery struct {
+	Zone           string
+	ClientUser     string
+	ProxyUser      string
+	ClientHostname string
+	ClientHostIP   string
+	PoolAddress    string
+	Terminated     *bool
+	State          string
+
+	CreatedAfter       time.Time
+	CreatedBefore      time.Time
+	LastActivityAfter  time.Time
+	LastActivityBefore time.Time
+
+	SortBy    string
+	SortOrder string
+	Limit     int
+	Cursor    string
+}
+
+// NewInstanceQueryFromValues creates InstanceQuery from URL query values
+func NewInstanceQueryFromValues(values url.Values) (*InstanceQuery, error) {
+	var err error
+	query := &InstanceQuery{
+		Zone:           values.Get("zone"),
+		ClientUser:     values.Get("client_user"),
+		ProxyUser:      values.Get("proxy_user"),
+		ClientHostname: values.Get("client_hostname"),
+		ClientHostIP:   values.Get("client_host_ip"),
+		PoolAddress:    values.Get("pool_address"),
+		State:          values.Get("state"),
+		SortBy:         values.Get("sort"),
+		SortOrder:      values.Get("order"),
+		Cursor:         values.Get("cursor"),
+	}
+
+	query.Terminated, err = parseBoolValue(values, "terminated")
+	if err != nil {
+		return nil, err
+	}
+
+	query.CreatedAfter, err = parseTimeValue(values, "created_after")
+	if err != nil {
+		return nil, err
+	}
+
+	query.CreatedBefore, err = parseTimeValue(values, "created_before")
+	if err != nil {
+		return nil, err
+	}
+
+	query.LastActivityAfter, err = parseTimeValue(values, "last_activity_after")
+	if err != nil {
+		return nil, err
+	}
+
+	query.LastActivityBefore, err = parseTimeValue(values, "last_activity_before")
+	if err != nil {
+		return nil, err
+	}
+
+	query.Limit, err = parseIntValue(values, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *InstanceQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "zone", query.Zone)
+	setStringValue(values, "client_user", query.ClientUser)
+	setStringValue(values, "proxy_user", query.ProxyUser)
+	setStringValue(values, "client_hostname", query.ClientHostname)
+	setStringValue(values, "client_host_ip", query.ClientHostIP)
+	setStringValue(values, "pool_address", query.PoolAddress)
+	setBoolValue(values, "terminated", query.Terminated)
+	setStringValue(values, "state", query.State)
+	setTimeValue(values, "created_after", query.CreatedAfter)
+	setTimeValue(values, "created_before", query.CreatedBefore)
+	setTimeValue(values, "last_activity_after", query.LastActivityAfter)
+	setTimeValue(values, "last_activity_before", query.LastActivityBefore)
+	setStringValue(values, "sort", query.SortBy)
+	setStringValue(values, "order", query.SortOrder)
+	setIntValue(values, "limit", query.Limit)
+	setStringValue(values, "cursor", query.Cursor)
+	return values
+}
+
+// TransferQuery is a struct used to filter, sort and paginate file transfers
+type TransferQuery struct {
+	InstanceID     string
+	Zone           string
+	ClientUser     string
+	ClientHostname string
+	ClientHostIP   string
+
+	FilePath       string
+	FilePathPrefix string
+	FilePathGlob   string
+	FileOpenMode   string
+
+	// size filters are ignored if negative
+	MinFileSize     int64
+	MaxFileSize     int64
+	MinTransferSize int64
+	MaxTransferSize int64
+
+	SequentialAccess *bool
+
+	OpenedAfter  time.Time
+	OpenedBefore time.Time
+	ClosedAfter  time.Time
+	ClosedBefore time.Time
+
+	SortBy    string
+	SortOrder string
+	Limit     int
+	Cursor    string
+}
+
+// NewTransferQuery creates an empty TransferQuery that matches all transfers
+func NewTransferQuery() *TransferQuery {
+	return &TransferQuery{
+		MinFileSize:     -1,
+		MaxFileSize:     -1,
+		MinTransferSize: -1,
+		MaxTransferSize: -1,
+	}
+}
+
+// NewTransferQueryFromValues creates TransferQuery from URL query values
+func NewTransferQueryFromValues(values url.Values) (*TransferQuery, error) {
+	var err error
+	query := &TransferQuery{
+		InstanceID:     values.Get("instance_id"),
+		Zone:           values.Get("zone"),
+		ClientUser:     values.Get("client_user"),
+		ClientHostname: values.Get("client_hostname"),
+		ClientHostIP:   values.Get("client_host_ip"),
+		FilePath:       values.Get("file_path"),
+		FilePathPrefix: values.Get("file_path_prefix"),
+		FilePathGlob:   values.Get("file_path_glob"),
+		FileOpenMode:   values.Get("file_open_mode"),
+		SortBy:         values.Get("sort"),
+		SortOrder:      values.Get("order"),
+		Cursor:         values.Get("cursor"),
+	}
+
+	query.MinFileSize, err = parseInt64Value(values, "min_file_size")
+	if err != nil {
+		return nil, err
+	}
+
+	query.MaxFileSize, err = parseInt64Value(values, "max_file_size")
+	if err != nil {
+		return nil, err
+	}
+
+	query.MinTransferSize, err = parseInt64Value(values, "min_transfer_size")
+	if err != nil {
+		return nil, err
+	}
+
+	query.MaxTransferSize, err = parseInt64Value(values, "max_transfer_size")
+	if err != nil {
+		return nil, err
+	}
+
+	query.SequentialAccess, err = parseBoolValue(values, "sequential_access")
+	if err != nil {
+		return nil, err
+	}
+
+	query.OpenedAfter, err = parseTimeValue(values, "opened_after")
+	if err != nil {
+		return nil, err
+	}
+
+	query.OpenedBefore, err = parseTimeValue(values, "opened_before")
+	if err != nil {
+		return nil, err
+	}
+
+	query.ClosedAfter, err = parseTimeValue(values, "closed_after")
+	if err != nil {
+		return nil, err
+	}
+
+	query.ClosedBefore, err = parseTimeValue(values, "closed_before")
+	if err != nil {
+		return nil, err
+	}
+
+	query.Limit, err = parseIntValue(values, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *TransferQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "instance_id", query.InstanceID)
+	setStringValue(values, "zone", query.Zone)
+	setStringValue(values, "client_user", query.ClientUser)
+	setStringValue(values, "client_hostname", query.ClientHostname)
+	setStringValue(values, "client_host_ip", query.ClientHostIP)
+	setStringValue(values, "file_path", query.FilePath)
+	setStringValue(values, "file_path_prefix", query.FilePathPrefix)
+	setStringValue(values, "file_path_glob", query.FilePathGlob)
+	setStringValue(values, "file_open_mode", query.FileOpenMode)
+	setInt64Value(values, "min_file_size", query.MinFileSize)
+	setInt64Value(values, "max_file_size", query.MaxFileSize)
+	setInt64Value(values, "min_transfer_size", query.MinTransferSize)
+	setInt64Value(values, "max_transfer_size", query.MaxTransferSize)
+	setBoolValue(values, "sequential_access", query.SequentialAccess)
+	setTimeValue(values, "opened_after", query.OpenedAfter)
+	setTimeValue(values, "opened_before", query.OpenedBefore)
+	setTimeValue(values, "closed_after", query.ClosedAfter)
+	setTimeValue(values, "closed_before", query.ClosedBefore)
+	setStringValue(values, "sort", query.SortBy)
+	setStringValue(values, "order", query.SortOrder)
+	setIntValue(values, "limit", query.Limit)
+	setStringValue(values, "cursor", query.Cursor)
+	return values
+}
+
+func parseBoolValue(values url.Values, key string) (*bool, error) {
+	v := values.Get(key)
+	if len(v) == 0 {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a boolean", key)
+	}
+
+	return &b, nil
+}
+
+func parseTimeValue(values url.Values, key string) (time.Time, error) {
+	v := values.Get(key)
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a RFC3339 time", key)
+	}
+
+	return t, nil
+}
+
+func parseIntValue(values url.Values, key string) (int, error) {
+	v := values.Get(key)
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s is not a non-negative number", key)
+	}
+
+	return i, nil
+}
+
+func parseInt64Value(values url.Values, key string) (int64, error) {
+	v := values.Get(key)
+	if len(v) == 0 {
+		return -1, nil
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || i < 0 {
+		return -1, fmt.Errorf("%s is not a non-negative number", key)
+	}
+
+	return i, nil
+}
+
+func setStringValue(values url.Values, key string, v string) {
+	if len(v) > 0 {
+		values.Set(key, v)
+	}
+}
+
+func setBoolValue(values url.Values, key string, v *bool) {
+	if v != nil {
+		values.Set(key, strconv.FormatBool(*v))
+	}
+}
+
+func setTimeValue(values url.Values, key string, v time.Time) {
+	if !v.IsZero() {
+		values.Set(key, v.Format(time.RFC3339Nano))
+	}
+}
+
+func setIntValue(values url.Values, key string, v int) {
+	if v > 0 {
+		values.Set(key, strconv.Itoa(v))
+	}
+}
+
+func setInt64Value(values url.Values, key string, v int64) {
+	if v >= 0 {
+		values.Set(key, strconv.FormatInt(v, 10))
+	}
+}
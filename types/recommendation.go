@@ -0,0 +1,47 @@
+package types
+
+const (
+	SettingReadAheadMax         string = "read_ahead_max"
+	SettingBufferSizeMax        string = "buffer_size_max"
+	SettingConnectionMax        string = "connection_max"
+	SettingMetadataCacheTimeout string = "metadata_cache_timeout"
+)
+
+// InstanceWorkload is a struct that summarizes observed file transfers of an instance
+type InstanceWorkload struct {
+	TransferCount      int64 `json:"transfer_count"`
+	ReadTransferCount  int64 `json:"read_transfer_count"`
+	WriteTransferCount int64 `json:"write_transfer_count"`
+
+	// SequentialReadFraction and RandomReadFraction are fractions of read transfers with blocks,
+	// strided reads count as random
+	SequentialReadFraction float64 `json:"sequential_read_fraction"`
+	RandomReadFraction     float64 `json:"random_read_fraction"`
+
+	MedianReadBlockSize int64 `json:"median_read_block_size"`
+	MedianReadSize      int64 `json:"median_read_size"`
+	MedianWriteSize     int64 `json:"median_write_size"`
+
+	// PeakConcurrentTransfers is the max number of files open at the same time
+	PeakConcurrentTransfers int64 `json:"peak_concurrent_transfers"`
+	// MedianReopenInterval is the median time a file stays closed before it is opened again, empty if no file is reopened
+	MedianReopenInterval string `json:"median_reopen_interval,omitempty"`
+}
+
+// Recommendation is a struct that holds a recommended value of a setting of an instance
+type Recommendation struct {
+	// Setting is one of Setting*
+	Setting     string `json:"setting"`
+	Current     string `json:"current"`
+	Recommended string `json:"recommended"`
+	Reason      string `json:"reason"`
+}
+
+// InstanceRecommendations is a struct that holds recommended settings of an instance from its observed workload
+type InstanceRecommendations struct {
+	InstanceID      string           `json:"instance_id"`
+	Workload        InstanceWorkload `json:"workload"`
+	Recommendations []Recommendation `json:"recommendations"`
+	// Note tells why nothing is recommended, if so
+	Note string `json:"note,omitempty"`
+}
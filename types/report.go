@@ -2,6 +2,13 @@ package types
 
 import "time"
 
+const (
+	InstanceStateActive     string = "active"
+	InstanceStateStale      string = "stale"
+	InstanceStateLost       string = "lost"
+	InstanceStateTerminated string = "terminated"
+)
+
 // ReportInstance is a struct used to report an instance creation
 type ReportInstance struct {
 	Host                     string `json:"host"`
@@ -23,11 +30,26 @@ type ReportInstance struct {
 	ClientHostname string `json:"client_hostname,omitempty"`
 	ClientHostIP   string `json:"client_host_ip,omitempty"` // filled by server
 	InstanceID     string `json:"instance_id"`
+	ReporterID     string `json:"reporter_id,omitempty"` // filled by server
 
 	CreationTime     time.Time `json:"creation_time"`
 	LastActivityTime time.Time `json:"last_activity_time,omitempty"`
 	TerminationTime  time.Time `json:"termination_time,omitempty"` // may be empty
 	Terminated       bool      `json:"terminated,omitempty"`
+	State            string    `json:"state,omitempty"` // filled by server
+}
+
+// GetState returns the state of the instance, one of InstanceState*
+func (instance *ReportInstance) GetState() string {
+	if instance.Terminated {
+		return InstanceStateTerminated
+	}
+
+	if len(instance.State) == 0 {
+		return InstanceStateActive
+	}
+
+	return instance.State
 }
 
 // FileBlock is an internal struct used in other structs
@@ -40,6 +62,8 @@ type FileBlock struct {
 // ReportFileTransfer is a struct used to report file transfer information
 type ReportFileTransfer struct {
 	InstanceID string `json:"instance_id"`
+	// Sequence identifies the transfer among transfers of the instance, it is not reused after the transfer is removed
+	Sequence int64 `json:"sequence,omitempty"` // filled by server
 
 	FilePath     string `json:"file_path"`
 	FileSize     int64  `json:"file_size"`
@@ -54,4 +78,37 @@ type ReportFileTransfer struct {
 
 	FileOpenTime  time.Time `json:"file_open_time"`
 	FileCloseTime time.Time `json:"file_close_time"`
+
+	Error string `json:"error,omitempty"` // may be empty
+}
+
+// ReportFileTransferResult is a result of a file transfer in a batch
+type ReportFileTransferResult struct {
+	Index    int    `json:"index"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"` // empty if accepted
+}
+
+// ReportFileTransferBatchResult is a result of a batch of file transfers
+type ReportFileTransferBatchResult struct {
+	Accepted int                        `json:"accepted"`
+	Rejected int                        `json:"rejected"`
+	Results  []ReportFileTransferResult `json:"results"`
+}
+
+// ReportImportError is an error of a record in an import
+type ReportImportError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ReportImportResult is a result of an import of instances and file transfers
+type ReportImportResult struct {
+	InstancesImported int `json:"instances_imported"`
+	// InstancesSkipped and TransfersSkipped are the numbers of records that already exist, with deduplication
+	InstancesSkipped  int                 `json:"instances_skipped"`
+	TransfersImported int                 `json:"transfers_imported"`
+	TransfersSkipped  int                 `json:"transfers_skipped"`
+	Rejected          int                 `json:"rejected"`
+	Errors            []ReportImportError `json:"errors"`
 }
@@ -0,0 +1,188 @@
+package types
+
+import (
+	"net/url"
+	"time"
+)
+
+const (
+	StatsGroupByUser       string = "user"
+	StatsGroupByZone       string = "zone"
+	StatsGroupByHost       string = "host"
+	StatsGroupByInstance   string = "instance"
+	StatsGroupByPathPrefix string = "path_prefix"
+
+	StatsBucketMinute string = "minute"
+	StatsBucketHour   string = "hour"
+	StatsBucketDay    string = "day"
+
+	StatsPathDepthDefault int = 3
+
+	// StatsPartialHeader is a response header that carries why transfer stats do not count some transfers removed by retention
+	StatsPartialHeader string = "X-Stats-Partial"
+
+	TopTargetFile       string = "file"
+	TopTargetCollection string = "collection"
+
+	TopRankByBytes     string = "bytes"
+	TopRankByOpens     string = "opens"
+	TopRankByInstances string = "instances"
+
+	TopAccessRead  string = "read"
+	TopAccessWrite string = "write"
+
+	TopLimitDefault int = 20
+)
+
+// StatsQuery is a struct used to request aggregated statistics of file transfers
+type StatsQuery struct {
+	// GroupBy is one of StatsGroupBy*, all transfers are in one group if empty
+	GroupBy string
+	// PathDepth is the number of path components used for StatsGroupByPathPrefix
+	PathDepth int
+	// Bucket is one of StatsBucket*, the whole window is one bucket if empty
+	Bucket string
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// NewStatsQueryFromValues creates StatsQuery from URL query values
+func NewStatsQueryFromValues(values url.Values) (*StatsQuery, error) {
+	var err error
+	query := &StatsQuery{
+		GroupBy: values.Get("group_by"),
+		Bucket:  values.Get("bucket"),
+	}
+
+	query.PathDepth, err = parseIntValue(values, "path_depth")
+	if err != nil {
+		return nil, err
+	}
+
+	if query.PathDepth == 0 {
+		query.PathDepth = StatsPathDepthDefault
+	}
+
+	query.StartTime, err = parseTimeValue(values, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	query.EndTime, err = parseTimeValue(values, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *StatsQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "group_by", query.GroupBy)
+	setIntValue(values, "path_depth", query.PathDepth)
+	setStringValue(values, "bucket", query.Bucket)
+	setTimeValue(values, "start", query.StartTime)
+	setTimeValue(values, "end", query.EndTime)
+	return values
+}
+
+// TransferStats is a struct that holds aggregated statistics of file transfers in a group and a time bucket
+type TransferStats struct {
+	Group       string     `json:"group"`
+	BucketStart *time.Time `json:"bucket_start,omitempty"`
+
+	FileCount           int64 `json:"file_count"`
+	TotalTransferSize   int64 `json:"total_transfer_size"`
+	AverageTransferSize int64 `json:"average_transfer_size"`
+}
+
+// TopQuery is a struct used to request a ranking of hot files or collections
+type TopQuery struct {
+	// Target is one of TopTarget*
+	Target string
+	// RankBy is one of TopRankBy*
+	RankBy string
+	// Access is one of TopAccess*, all transfers are counted if empty
+	Access string
+	Limit  int
+
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// NewTopQueryFromValues creates TopQuery from URL query values
+func NewTopQueryFromValues(values url.Values) (*TopQuery, error) {
+	var err error
+	query := &TopQuery{
+		Target: values.Get("target"),
+		RankBy: values.Get("rank_by"),
+		Access: values.Get("access"),
+	}
+
+	if len(query.Target) == 0 {
+		query.Target = TopTargetFile
+	}
+
+	if len(query.RankBy) == 0 {
+		query.RankBy = TopRankByBytes
+	}
+
+	query.Limit, err = parseIntValue(values, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Limit == 0 {
+		query.Limit = TopLimitDefault
+	}
+
+	query.StartTime, err = parseTimeValue(values, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	query.EndTime, err = parseTimeValue(values, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+// Values returns URL query values of the query
+func (query *TopQuery) Values() url.Values {
+	values := url.Values{}
+	setStringValue(values, "target", query.Target)
+	setStringValue(values, "rank_by", query.RankBy)
+	setStringValue(values, "access", query.Access)
+	setIntValue(values, "limit", query.Limit)
+	setTimeValue(values, "start", query.StartTime)
+	setTimeValue(values, "end", query.EndTime)
+	return values
+}
+
+// TopEntry is a struct that holds access counts of a file or a collection
+type TopEntry struct {
+	Path          string `json:"path"`
+	TransferSize  int64  `json:"transfer_size"`
+	OpenCount     int64  `json:"open_count"`
+	InstanceCount int64  `json:"instance_count"`
+}
+
+// TransferRollup is a struct that holds file and byte counts of file transfers summarized in a time bucket
+// Rollups are made from raw file transfers before they are removed by retention
+type TransferRollup struct {
+	// Resolution is StatsBucketHour or StatsBucketDay
+	Resolution  string    `json:"resolution"`
+	BucketStart time.Time `json:"bucket_start"`
+
+	Zone           string `json:"zone"`
+	ClientUser     string `json:"client_user"`
+	ClientHostname string `json:"client_hostname"`
+	PathPrefix     string `json:"path_prefix"`
+
+	FileCount         int64 `json:"file_count"`
+	TotalTransferSize int64 `json:"total_transfer_size"`
+}